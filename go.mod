@@ -1,3 +1,11 @@
 module fileserv
 
 go 1.24.5
+
+require golang.org/x/crypto v0.45.0
+
+require golang.org/x/net v0.47.0
+
+require golang.org/x/image v0.33.0
+
+require github.com/yuin/goldmark v1.8.6
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Access is what a user may do with a path. Each level includes the ones
+// below it.
+type Access int
+
+const (
+	// AccessNone keeps the path from the user altogether
+	AccessNone Access = iota
+	// AccessRead lets the user list, download and share
+	AccessRead
+	// AccessWrite also lets the user upload, create, rename, move and
+	// delete
+	AccessWrite
+	// AccessAdmin also lets the user manage the trash and create drop
+	// boxes
+	AccessAdmin
+)
+
+// ParseAccess reads the name of an access level
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "read":
+		return AccessRead, nil
+	case "write":
+		return AccessWrite, nil
+	case "admin":
+		return AccessAdmin, nil
+	}
+	return AccessNone, fmt.Errorf("unknown access %q (want read, write or admin)", s)
+}
+
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// Everyone among the users of a rule stands for every signed-in user
+const Everyone = "*"
+
+// Rule grants users, and members of groups, access to a mount or a subtree
+// of one, named by its URL path such as "/docs" or "/docs/private"
+type Rule struct {
+	Path   string
+	Users  []string
+	Groups []string
+	Access Access
+}
+
+// grants reports whether the rule applies to u
+func (r Rule) grants(u *User) bool {
+	if u == nil {
+		return false
+	}
+	if slices.Contains(r.Users, Everyone) || slices.Contains(r.Users, u.Name) {
+		return true
+	}
+	for _, g := range r.Groups {
+		if u.InGroup(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL decides access to paths by rules. The rules of the deepest path at or
+// above a request's path decide alone, so a subtree with rules of its own
+// can be closed to users of the mount around it. Paths no rule covers are
+// left to the mounts' users and groups. A nil ACL has no rules.
+type ACL struct {
+	rules map[string][]Rule
+}
+
+// NewACL returns an ACL holding rules
+func NewACL(rules []Rule) *ACL {
+	a := &ACL{rules: make(map[string][]Rule)}
+	for _, r := range rules {
+		r.Path = path.Clean("/" + r.Path)
+		a.rules[r.Path] = append(a.rules[r.Path], r)
+	}
+	return a
+}
+
+// Scope returns the path whose rules decide access to urlPath, and false
+// when no rule covers it
+func (a *ACL) Scope(urlPath string) (string, bool) {
+	if a == nil || len(a.rules) == 0 {
+		return "", false
+	}
+	for p := path.Clean("/" + urlPath); ; p = path.Dir(p) {
+		if _, ok := a.rules[p]; ok {
+			return p, true
+		}
+		if p == "/" {
+			return "", false
+		}
+	}
+}
+
+// Access returns the most the rules covering urlPath grant u, and false
+// when no rule covers it
+func (a *ACL) Access(u *User, urlPath string) (Access, bool) {
+	scope, ok := a.Scope(urlPath)
+	if !ok {
+		return AccessNone, false
+	}
+	return a.granted(u, scope), true
+}
+
+// Restricted returns a path strictly below urlPath whose rules grant u
+// less than need, the first in sorted order, and false when there is none.
+// Deleting, moving or renaming urlPath as a whole would reach past its
+// rules.
+func (a *ACL) Restricted(u *User, urlPath string, need Access) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	prefix := strings.TrimSuffix(path.Clean("/"+urlPath), "/") + "/"
+	var found string
+	for p := range a.rules {
+		if strings.HasPrefix(p, prefix) && a.granted(u, p) < need && (found == "" || p < found) {
+			found = p
+		}
+	}
+	return found, found != ""
+}
+
+// granted returns the most the rules of scope grant u
+func (a *ACL) granted(u *User, scope string) Access {
+	access := AccessNone
+	for _, r := range a.rules[scope] {
+		if r.grants(u) {
+			access = max(access, r.Access)
+		}
+	}
+	return access
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fileserv/internal/models"
+)
+
+var (
+	alice = &User{Name: "alice", Groups: []string{"interns"}}
+	bob   = &User{Name: "bob", Groups: []string{"finance"}}
+	carol = &User{Name: "carol"}
+)
+
+var testRules = []Rule{
+	{Path: "/team", Users: []string{Everyone}, Access: AccessWrite},
+	{Path: "/team/reports", Groups: []string{"finance"}, Access: AccessAdmin},
+	{Path: "/team/reports", Users: []string{"alice"}, Access: AccessRead},
+	{Path: "/team/reports/drafts", Users: []string{"bob"}, Access: AccessRead},
+	{Path: "team/secret/", Users: []string{"carol"}, Access: AccessWrite},
+}
+
+func TestACLAccess(t *testing.T) {
+	acl := NewACL(testRules)
+	tests := []struct {
+		user        *User
+		path        string
+		want        Access
+		wantCovered bool
+	}{
+		{alice, "/team", AccessWrite, true},
+		{alice, "/team/notes/todo.txt", AccessWrite, true},
+		// The deepest rules decide alone, whether they grant more or less
+		{alice, "/team/reports/q1.pdf", AccessRead, true},
+		{bob, "/team/reports/q1.pdf", AccessAdmin, true},
+		{bob, "/team/reports/drafts/q2.pdf", AccessRead, true},
+		{alice, "/team/reports/drafts", AccessNone, true},
+		{alice, "/team/secret", AccessNone, true},
+		{carol, "/team/secret/plans", AccessWrite, true},
+		// Neither a member of another group nor an unknown user is let in
+		{&User{Name: "dave", Groups: []string{"interns"}}, "/team/reports", AccessNone, true},
+		{carol, "/team/reports", AccessNone, true},
+		// Anonymous users get nothing, even from rules for everyone
+		{nil, "/team", AccessNone, true},
+		{nil, "/team/secret", AccessNone, true},
+		// Paths are cleaned before they are looked up
+		{carol, "/team/../team/secret/", AccessWrite, true},
+		{carol, "team/secret", AccessWrite, true},
+		// Names sharing a prefix are different paths
+		{carol, "/team/secrets", AccessWrite, true},
+		{alice, "/teamwork", AccessNone, false},
+		{nil, "/", AccessNone, false},
+	}
+	for _, tt := range tests {
+		got, covered := acl.Access(tt.user, tt.path)
+		if got != tt.want || covered != tt.wantCovered {
+			t.Errorf("Access(%v, %q) = %v, %v, want %v, %v", tt.user, tt.path, got, covered, tt.want, tt.wantCovered)
+		}
+	}
+
+	var none *ACL
+	if got, covered := none.Access(alice, "/team"); got != AccessNone || covered {
+		t.Errorf("nil ACL: Access = %v, %v, want none, false", got, covered)
+	}
+}
+
+func TestACLRestricted(t *testing.T) {
+	acl := NewACL(testRules)
+	tests := []struct {
+		user *User
+		path string
+		need Access
+		want string
+	}{
+		{alice, "/team", AccessWrite, "/team/reports"},
+		{alice, "/team/", AccessRead, "/team/reports/drafts"},
+		{bob, "/team", AccessWrite, "/team/reports/drafts"},
+		{bob, "/team/reports", AccessRead, ""},
+		{carol, "/team", AccessWrite, "/team/reports"},
+		{carol, "/team/secret", AccessWrite, ""},
+		// Only rules strictly below the path count
+		{alice, "/team/reports", AccessWrite, "/team/reports/drafts"},
+		{alice, "/team/reports/drafts", AccessWrite, ""},
+		{nil, "/", AccessRead, "/team"},
+		{alice, "/other", AccessAdmin, ""},
+	}
+	for _, tt := range tests {
+		got, ok := acl.Restricted(tt.user, tt.path, tt.need)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("Restricted(%v, %q, %v) = %q, %v, want %q", tt.user, tt.path, tt.need, got, ok, tt.want)
+		}
+	}
+
+	var none *ACL
+	if got, ok := none.Restricted(alice, "/", AccessAdmin); ok {
+		t.Errorf("nil ACL: Restricted = %q, want none", got)
+	}
+}
+
+type testStore map[string]*User
+
+func (s testStore) Authenticate(name, password string) (*User, bool) {
+	u, ok := s[name]
+	return u, ok && password == "pw"
+}
+
+func (s testStore) Lookup(name string) (*User, bool) {
+	u, ok := s[name]
+	return u, ok
+}
+
+// Paths with rules need a signed-in user even on open mounts; which users
+// may do what there is left to the handlers
+func TestGuardACL(t *testing.T) {
+	mounts := func(p string) (models.Directory, string, bool) {
+		return models.Directory{Name: "team"}, p, true
+	}
+	g := NewGuard(testStore{"alice": alice, "carol": carol}, nil, mounts, NewACL(testRules), "", false)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := UserFrom(r.Context()); u != nil {
+			w.Header().Set("X-User", u.Name)
+		}
+	})
+	h := g.Wrap(next)
+
+	tests := []struct {
+		path, user string
+		want       int
+	}{
+		{"/team/notes.txt", "", http.StatusUnauthorized},
+		{"/team/secret/plans", "", http.StatusUnauthorized},
+		{"/team/secret/plans", "alice", http.StatusOK},
+		{"/team/secret/plans", "carol", http.StatusOK},
+		{"/team/secret/plans", "mallory", http.StatusUnauthorized},
+		{"/public/readme.txt", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, "pw")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("GET %s as %q = %d, want %d", tt.path, tt.user, w.Code, tt.want)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("X-User") != tt.user {
+			t.Errorf("GET %s as %q reached the handler as %q", tt.path, tt.user, w.Header().Get("X-User"))
+		}
+	}
+}
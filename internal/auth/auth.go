@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"fileserv/internal/models"
+	"fileserv/internal/netfilter"
+)
+
+// User is an authenticated user
+type User struct {
+	Name   string
+	Groups []string
+}
+
+// InGroup reports whether the user belongs to group
+func (u *User) InGroup(group string) bool {
+	return slices.Contains(u.Groups, group)
+}
+
+// Store verifies credentials and looks up users. It is shared by every
+// authentication method so they all agree on who exists.
+type Store interface {
+	// Authenticate returns the user if the password is correct
+	Authenticate(name, password string) (*User, bool)
+	// Lookup returns a known user without checking a password
+	Lookup(name string) (*User, bool)
+}
+
+type contextKey struct{}
+
+// identity is what the guard stores in the request context
+type identity struct {
+	user    *User
+	session bool
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity{user: u})
+}
+
+// UserFrom returns the authenticated user, or nil for anonymous requests
+func UserFrom(ctx context.Context) *User {
+	id, _ := ctx.Value(contextKey{}).(identity)
+	return id.user
+}
+
+// HasSession reports whether the request was authenticated with a login
+// session, and so can log out
+func HasSession(ctx context.Context) bool {
+	id, _ := ctx.Value(contextKey{}).(identity)
+	return id.session
+}
+
+// CanAccess reports whether u may access a mount restricted to users and
+// groups. Mounts without restrictions are open to everyone, including
+// anonymous users.
+func CanAccess(u *User, dir models.Directory) bool {
+	if len(dir.Users) == 0 && len(dir.Groups) == 0 {
+		return true
+	}
+	if u == nil {
+		return false
+	}
+	if slices.Contains(dir.Users, u.Name) {
+		return true
+	}
+	for _, g := range dir.Groups {
+		if u.InGroup(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admins are the users, and members of the groups, who may see the
+// server's status
+type Admins struct {
+	Users  []string
+	Groups []string
+}
+
+// Contains reports whether u is an admin
+func (a Admins) Contains(u *User) bool {
+	if u == nil {
+		return false
+	}
+	if slices.Contains(a.Users, u.Name) {
+		return true
+	}
+	for _, g := range a.Groups {
+		if u.InGroup(g) {
+			return true
+		}
+	}
+	return false
+}
+
+// MountLookup finds the mount serving a URL path. It also returns the path
+// of the file the URL is about, which differs for URLs such as those of the
+// API.
+type MountLookup func(path string) (dir models.Directory, filePath string, ok bool)
+
+// Guard authenticates requests with a login session cookie or HTTP Basic
+// credentials and enforces the per-mount user and group restrictions. Paths
+// the ACL has rules for need a signed-in user too; what each user may do
+// there is for the handlers to decide.
+type Guard struct {
+	store    Store
+	sessions *Sessions
+	mounts   MountLookup
+	acl      *ACL
+	realm    string
+	// required makes every request authenticate, not only those to
+	// restricted mounts
+	required bool
+}
+
+// NewGuard creates a guard checking credentials against store. Browsers are
+// sent to the login page when sessions is not nil.
+func NewGuard(store Store, sessions *Sessions, mounts MountLookup, acl *ACL, realm string, required bool) *Guard {
+	if realm == "" {
+		realm = "fileserv"
+	}
+	realm = strings.ReplaceAll(realm, `"`, "'")
+	return &Guard{store: store, sessions: sessions, mounts: mounts, acl: acl, realm: realm, required: required}
+}
+
+// Wrap returns a handler that only passes permitted requests to next
+func (g *Guard) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, presented := g.authenticate(r)
+		if presented && id.user == nil {
+			g.deny(w, r)
+			return
+		}
+
+		dir, filePath, isMount := g.mounts(r.URL.Path)
+		restricted := isMount && (len(dir.Users) > 0 || len(dir.Groups) > 0)
+		if _, covered := g.acl.Scope(filePath); covered {
+			restricted = true
+		}
+
+		if id.user == nil && (g.required || restricted) {
+			g.deny(w, r)
+			return
+		}
+		if isMount && !CanAccess(id.user, dir) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// Cookies and cached Basic credentials are sent along with
+		// cross-site requests too, so changes made from a browser must come
+		// from our own pages. Clients such as curl send no Origin.
+		crossSite := id.session || r.Header.Get("Origin") != ""
+		if crossSite && !isSafeMethod(r.Method) && !sameOrigin(r) {
+			http.Error(w, "Forbidden: cross-origin request", http.StatusForbidden)
+			return
+		}
+
+		if id.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate identifies the user from the session cookie or the Basic
+// credentials. presented is false when the request carries neither.
+func (g *Guard) authenticate(r *http.Request) (id identity, presented bool) {
+	if g.sessions != nil {
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if name, ok := g.sessions.Get(c.Value); ok {
+				if user, ok := g.store.Lookup(name); ok {
+					return identity{user: user, session: true}, true
+				}
+			}
+			// A stale cookie alone does not count as presenting credentials
+		}
+	}
+
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return identity{}, false
+	}
+	user, _ := g.store.Authenticate(name, pass)
+	return identity{user: user}, true
+}
+
+// deny asks the client to authenticate: browsers are sent to the login
+// page, everything else gets a Basic challenge
+func (g *Guard) deny(w http.ResponseWriter, r *http.Request) {
+	if g.sessions != nil && wantsHTML(r) {
+		http.Redirect(w, r, LoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+g.realm+`", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// clientAddr names the client of a request for the log: the address found
+// behind trusted proxies when there is one
+func clientAddr(r *http.Request) string {
+	if addr, ok := netfilter.Client(r.Context()); ok && addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// wantsHTML reports whether the request comes from a browser navigating
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sameOrigin reports whether the request's Origin (or, failing that,
+// Referer) names this server
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
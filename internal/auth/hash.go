@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verifyPassword checks password against an htpasswd hash. Supported formats
+// are bcrypt ($2y$, $2a$, $2b$), SHA-256 and SHA-512 crypt ($5$, $6$), the
+// unsalted {SHA} format, Apache MD5 ($apr1$) and, for entries that fileserv
+// adds itself, plain text.
+func verifyPassword(hashed, password string) bool {
+	switch {
+	case strings.HasPrefix(hashed, "$2y$"), strings.HasPrefix(hashed, "$2a$"), strings.HasPrefix(hashed, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return constantTimeEqual(hashed[len("{SHA}"):], base64.StdEncoding.EncodeToString(sum[:]))
+	case strings.HasPrefix(hashed, "$5$"):
+		return constantTimeEqual(hashed, shaCrypt(sha256.New, "$5$", hashed, password))
+	case strings.HasPrefix(hashed, "$6$"):
+		return constantTimeEqual(hashed, shaCrypt(sha512.New, "$6$", hashed, password))
+	case strings.HasPrefix(hashed, "$apr1$"):
+		return constantTimeEqual(hashed, apr1Crypt(hashed, password))
+	case strings.HasPrefix(hashed, plainPrefix):
+		return constantTimeEqual(hashed[len(plainPrefix):], password)
+	}
+	return false
+}
+
+// plainPrefix marks plain-text passwords taken from the configuration file.
+// It cannot start a line of a real htpasswd file's hash field.
+const plainPrefix = "\x00plain:"
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// cryptAlphabet is the base64 alphabet used by crypt(3) hashes
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode appends n characters encoding the 24-bit group b2 b1 b0
+func cryptEncode(dst []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		dst = append(dst, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return dst
+}
+
+// Byte orders in which SHA-crypt serialises its final digest, three bytes
+// per group. The last group of each is shorter and handled separately.
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt computes the SHA-crypt hash of password using the salt and round
+// count found in setting, following Ulrich Drepper's specification
+func shaCrypt(newHash func() hash.Hash, magic, setting, password string) string {
+	rest := strings.TrimPrefix(setting, magic)
+
+	rounds, customRounds := 5000, false
+	if strings.HasPrefix(rest, "rounds=") {
+		n, after, ok := strings.Cut(rest[len("rounds="):], "$")
+		r, err := strconv.Atoi(n)
+		if !ok || err != nil {
+			return ""
+		}
+		rounds, customRounds, rest = min(max(r, 1000), 999999999), true, after
+	}
+
+	salt, _, _ := strings.Cut(rest, "$")
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	h := newHash()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+	size := len(b)
+
+	h.Reset()
+	h.Write(p)
+	h.Write(s)
+	for n := len(p); n > 0; n -= size {
+		h.Write(b[:min(n, size)])
+	}
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range p {
+		h.Write(p)
+	}
+	dp := h.Sum(nil)
+	pSeq := make([]byte, 0, len(p))
+	for n := len(p); n > 0; n -= size {
+		pSeq = append(pSeq, dp[:min(n, size)]...)
+	}
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	sSeq := ds[:len(s)]
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(pSeq)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(sSeq)
+		}
+		if i%7 != 0 {
+			h.Write(pSeq)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(pSeq)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := []byte(magic)
+	if customRounds {
+		out = append(out, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+	out = append(out, salt...)
+	out = append(out, '$')
+
+	if size == sha256.Size {
+		for _, g := range sha256CryptOrder {
+			out = cryptEncode(out, c[g[0]], c[g[1]], c[g[2]], 4)
+		}
+		out = cryptEncode(out, 0, c[31], c[30], 3)
+	} else {
+		for _, g := range sha512CryptOrder {
+			out = cryptEncode(out, c[g[0]], c[g[1]], c[g[2]], 4)
+		}
+		out = cryptEncode(out, 0, 0, c[63], 2)
+	}
+	return string(out)
+}
+
+// apr1Crypt computes Apache's MD5-based hash of password using the salt in
+// setting
+func apr1Crypt(setting, password string) string {
+	const magic = "$apr1$"
+
+	salt, _, _ := strings.Cut(strings.TrimPrefix(setting, magic), "$")
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	p, s := []byte(password), []byte(salt)
+
+	h := md5.New()
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	final := h.Sum(nil)
+
+	h.Reset()
+	h.Write(p)
+	h.Write([]byte(magic))
+	h.Write(s)
+	for n := len(p); n > 0; n -= md5.Size {
+		h.Write(final[:min(n, md5.Size)])
+	}
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+	final = h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h.Reset()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(p)
+		}
+		final = h.Sum(nil)
+	}
+
+	out := []byte(magic + salt + "$")
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		out = cryptEncode(out, final[g[0]], final[g[1]], final[g[2]], 4)
+	}
+	out = cryptEncode(out, 0, 0, final[11], 2)
+	return string(out)
+}
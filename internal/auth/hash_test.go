@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"strings"
+	"testing"
+)
+
+// The SHA-crypt vectors are those of Ulrich Drepper's specification, as
+// glibc's crypt produces them
+var shaCryptTests = []struct {
+	setting, password, want string
+}{
+	{
+		"$5$saltstring", "Hello world!",
+		"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+	},
+	{
+		"$5$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+	},
+	{
+		"$5$rounds=5000$toolongsaltstring", "This is just a test",
+		"$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5",
+	},
+	{
+		"$5$rounds=1400$anotherlongsaltstring",
+		"a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		"$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1",
+	},
+	{
+		"$5$rounds=77777$short", "we have a short salt string but not a short password",
+		"$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/",
+	},
+	{
+		"$5$rounds=123456$asaltof16chars..", "a short string",
+		"$5$rounds=123456$asaltof16chars..$gP3VQ/6X7UUEW3HkBn2w1/Ptq2jxPyzV/cZKmF/wJvD",
+	},
+	{
+		"$5$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC",
+	},
+	{
+		"$6$saltstring", "Hello world!",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+	},
+	{
+		"$6$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+	},
+	{
+		"$6$rounds=5000$toolongsaltstring", "This is just a test",
+		"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0",
+	},
+	{
+		"$6$rounds=1400$anotherlongsaltstring",
+		"a very much longer text to encrypt.  This one even stretches over morethan one line.",
+		"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1",
+	},
+	{
+		"$6$rounds=77777$short", "we have a short salt string but not a short password",
+		"$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0",
+	},
+	{
+		"$6$rounds=123456$asaltof16chars..", "a short string",
+		"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1",
+	},
+	{
+		"$6$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX.",
+	},
+	// Malformed round counts give no hash, which matches nothing
+	{"$5$rounds=$salt", "pw", ""},
+	{"$5$rounds=many$salt", "pw", ""},
+	{"$6$rounds=5000", "pw", ""},
+}
+
+func TestShaCrypt(t *testing.T) {
+	for _, tt := range shaCryptTests {
+		newHash, magic := sha256.New, "$5$"
+		if strings.HasPrefix(tt.setting, "$6$") {
+			newHash, magic = sha512.New, "$6$"
+		}
+		if got := shaCrypt(newHash, magic, tt.setting, tt.password); got != tt.want {
+			t.Errorf("shaCrypt(%q, %q) = %q, want %q", tt.setting, tt.password, got, tt.want)
+		}
+	}
+}
+
+// The apr1 vectors were made with openssl passwd -apr1
+var apr1Tests = []struct {
+	setting, password, want string
+}{
+	{"$apr1$saltsalt", "password", "$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/"},
+	{"$apr1$x", "", "$apr1$x$tMwYqBfQwi3FYAr0aJc8M/"},
+	// Salts are cut to eight characters
+	{"$apr1$toolongsaltstring", "Hello world!", "$apr1$toolongs$nso6ozuKeWIzbK43V6ykZ1"},
+	{"$apr1$rosebud", "a much longer password with spaces, punctuation & ünïcödé", "$apr1$rosebud$0Uf/WgsbMrpkT5bJMhUz70"},
+}
+
+func TestApr1Crypt(t *testing.T) {
+	for _, tt := range apr1Tests {
+		if got := apr1Crypt(tt.setting, tt.password); got != tt.want {
+			t.Errorf("apr1Crypt(%q, %q) = %q, want %q", tt.setting, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	tests := []struct {
+		hashed, password string
+		want             bool
+	}{
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!", true},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world", false},
+		{"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0", "This is just a test", true},
+		// The round count in a hash is the one it was made with
+		{"$5$rounds=10$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC", "the minimum number is still observed", false},
+		{"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC", "the minimum number is still observed", true},
+		{"$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/", "password", true},
+		{"$apr1$saltsalt$yAAkm4libquA.ZWLHbSBq/", "Password", false},
+		{"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", "password", true},
+		{"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", "passwort", false},
+		{"$2y$04$Tghc7a0b9JPytEpsEOm6n.tMslVqnnx42e.A6PStThSeP.gGm/fEi", "password", true},
+		{"$2y$04$Tghc7a0b9JPytEpsEOm6n.tMslVqnnx42e.A6PStThSeP.gGm/fEi", "wrong", false},
+		{plainPrefix + "s3cret", "s3cret", true},
+		{plainPrefix + "s3cret", "s3cre", false},
+		{"s3cret", "s3cret", false},
+		{"$5$rounds=many$salt$", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifyPassword(tt.hashed, tt.password); got != tt.want {
+			t.Errorf("verifyPassword(%q, %q) = %v, want %v", tt.hashed, tt.password, got, tt.want)
+		}
+	}
+}
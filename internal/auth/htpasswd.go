@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadInterval bounds how often the backing files are checked for changes
+const reloadInterval = 2 * time.Second
+
+// UserStore holds users loaded from an Apache-style htpasswd file, optional
+// group memberships from an htgroups file, and users added directly. The
+// files are re-read when they change on disk.
+type UserStore struct {
+	htpasswd string
+	htgroups string
+
+	mu        sync.RWMutex
+	hashes    map[string]string
+	groups    map[string][]string
+	static    map[string]string
+	stamps    map[string]fileStamp
+	lastCheck time.Time
+}
+
+// fileStamp identifies a version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewUserStore creates a store backed by the given htpasswd and htgroups
+// files. Either may be empty.
+func NewUserStore(htpasswd, htgroups string) (*UserStore, error) {
+	s := &UserStore{
+		htpasswd: htpasswd,
+		htgroups: htgroups,
+		static:   make(map[string]string),
+		stamps:   make(map[string]fileStamp),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.lastCheck = time.Now()
+	return s, nil
+}
+
+// AddPlain adds a user with a plain-text password, as declared inline in the
+// configuration file
+func (s *UserStore) AddPlain(name, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prev, ok := s.static[name]; ok && prev != plainPrefix+password {
+		return fmt.Errorf("user %q is declared with different passwords", name)
+	}
+	s.static[name] = plainPrefix + password
+	return nil
+}
+
+// Empty reports whether the store has no users at all
+func (s *UserStore) Empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.hashes) == 0 && len(s.static) == 0
+}
+
+// Authenticate implements Store
+func (s *UserStore) Authenticate(name, password string) (*User, bool) {
+	s.reloadIfChanged()
+
+	s.mu.RLock()
+	hashed, ok := s.hashes[name]
+	if !ok {
+		hashed, ok = s.static[name]
+	}
+	s.mu.RUnlock()
+
+	if !ok || !verifyPassword(hashed, password) {
+		return nil, false
+	}
+	return s.Lookup(name)
+}
+
+// Lookup implements Store
+func (s *UserStore) Lookup(name string) (*User, bool) {
+	s.reloadIfChanged()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, inFile := s.hashes[name]
+	_, inline := s.static[name]
+	if !inFile && !inline {
+		return nil, false
+	}
+	return &User{Name: name, Groups: s.groups[name]}, true
+}
+
+// reloadIfChanged re-reads the backing files when they changed, at most once
+// per reloadInterval. A file that fails to parse keeps the previous contents.
+func (s *UserStore) reloadIfChanged() {
+	s.mu.Lock()
+	if time.Since(s.lastCheck) < reloadInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastCheck = time.Now()
+	changed := false
+	for _, name := range []string{s.htpasswd, s.htgroups} {
+		if name != "" && s.stamps[name] != stampOf(name) {
+			changed = true
+		}
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := s.load(); err != nil {
+		log.Printf("Error reloading users: %v", err)
+		return
+	}
+	log.Printf("Reloaded users from %s", s.htpasswd)
+}
+
+func (s *UserStore) load() error {
+	hashes := make(map[string]string)
+	groups := make(map[string][]string)
+	stamps := make(map[string]fileStamp)
+
+	if s.htpasswd != "" {
+		stamps[s.htpasswd] = stampOf(s.htpasswd)
+		err := readLines(s.htpasswd, func(line string, n int) error {
+			name, hashed, ok := strings.Cut(line, ":")
+			if !ok || name == "" || hashed == "" {
+				return fmt.Errorf("%s:%d: expected user:hash", s.htpasswd, n)
+			}
+			hashes[name] = hashed
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.htgroups != "" {
+		stamps[s.htgroups] = stampOf(s.htgroups)
+		err := readLines(s.htgroups, func(line string, n int) error {
+			group, members, ok := strings.Cut(line, ":")
+			group = strings.TrimSpace(group)
+			if !ok || group == "" {
+				return fmt.Errorf("%s:%d: expected group: user1 user2", s.htgroups, n)
+			}
+			for _, user := range strings.Fields(members) {
+				groups[user] = append(groups[user], group)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.hashes, s.groups, s.stamps = hashes, groups, stamps
+	s.mu.Unlock()
+	return nil
+}
+
+// readLines calls fn for every non-empty, non-comment line of a file
+func readLines(name string, fn func(line string, n int) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line, n); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func stampOf(name string) fileStamp {
+	info, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"fileserv/internal/models"
+	"fileserv/internal/template"
+)
+
+const (
+	// LoginPath serves the login form
+	LoginPath = "/_fileserv/login"
+	// LogoutPath ends the current session
+	LogoutPath = "/_fileserv/logout"
+)
+
+// ServeLogin shows the login form and starts a session on success
+func (g *Guard) ServeLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		g.renderLogin(w, models.LoginData{Next: next}, http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.Header.Get("Origin") != "" && !sameOrigin(r) {
+		http.Error(w, "Forbidden: cross-origin request", http.StatusForbidden)
+		return
+	}
+
+	name := r.PostFormValue("username")
+	user, ok := g.store.Authenticate(name, r.PostFormValue("password"))
+	if !ok {
+		log.Printf("Failed login for %q from %s", name, clientAddr(r))
+		g.renderLogin(w, models.LoginData{Next: next, Username: name, Error: "Invalid username or password"}, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := g.sessions.Create(user.Name)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(g.sessions.MaxAge().Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// ServeLogout ends the session and returns to the login form
+func (g *Guard) ServeLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// As in Wrap, other sites must not be able to sign users out
+	c, err := r.Cookie(SessionCookie)
+	if (err == nil || r.Header.Get("Origin") != "") && !sameOrigin(r) {
+		http.Error(w, "Forbidden: cross-origin request", http.StatusForbidden)
+		return
+	}
+
+	if err == nil {
+		g.sessions.Delete(c.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, LoginPath, http.StatusSeeOther)
+}
+
+func (g *Guard) renderLogin(w http.ResponseWriter, data models.LoginData, status int) {
+	data.Realm = g.realm
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := template.RenderLogin(w, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// safeNext only allows redirects to paths on this server
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
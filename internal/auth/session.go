@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// SessionCookie is the name of the cookie carrying the session token
+const SessionCookie = "fileserv_session"
+
+// session is a logged-in user's server-side state
+type session struct {
+	user     string
+	created  time.Time
+	lastSeen time.Time
+}
+
+// Sessions keeps login sessions in memory. A session ends when it has not
+// been used for the idle timeout or when it reaches the absolute lifetime,
+// whichever comes first.
+type Sessions struct {
+	idle     time.Duration
+	absolute time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewSessions creates a session store and starts expiring old sessions
+func NewSessions(idle, absolute time.Duration) *Sessions {
+	s := &Sessions{
+		idle:     idle,
+		absolute: absolute,
+		sessions: make(map[string]*session),
+	}
+	go s.expireLoop()
+	return s
+}
+
+// Create starts a session for user and returns its token
+func (s *Sessions) Create(user string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	s.mu.Lock()
+	s.sessions[token] = &session{user: user, created: now, lastSeen: now}
+	s.mu.Unlock()
+	return token, nil
+}
+
+// Get returns the user name of a live session and marks it as used
+func (s *Sessions) Get(token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	now := time.Now()
+	if s.expired(sess, now) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	sess.lastSeen = now
+	return sess.user, true
+}
+
+// Delete ends a session
+func (s *Sessions) Delete(token string) {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+}
+
+// MaxAge is the longest a session can live, used for the cookie lifetime
+func (s *Sessions) MaxAge() time.Duration {
+	return s.absolute
+}
+
+func (s *Sessions) expired(sess *session, now time.Time) bool {
+	return now.Sub(sess.lastSeen) > s.idle || now.Sub(sess.created) > s.absolute
+}
+
+func (s *Sessions) expireLoop() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		s.mu.Lock()
+		for token, sess := range s.sessions {
+			if s.expired(sess, now) {
+				delete(s.sessions, token)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
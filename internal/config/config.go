@@ -0,0 +1,797 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fileserv/internal/netfilter"
+)
+
+// Config is the server configuration loaded from a file
+type Config struct {
+	Listen []string
+	// StateDir holds data fileserv generates, such as certificates
+	StateDir string
+	TLS      TLS
+	Auth     Auth
+	Network  Network
+	Limits   Limits
+	Upload   Upload
+	Trash    Trash
+	Index    Index
+	FullText FullText
+	Mounts   []Mount
+	ACL      []ACLRule
+}
+
+// ACLRule grants users and groups access to a mount or a subtree of one
+type ACLRule struct {
+	// Path is the URL path of the mount or directory, such as "/docs"
+	Path string
+	// Users may include "*" for every signed-in user
+	Users  []string
+	Groups []string
+	// Access is "read", "write" or "admin"
+	Access string
+	// Source is the file:line the rule was declared at, used in errors
+	Source string
+}
+
+// FullText selects the files of mounts with fulltext = true whose contents
+// are indexed
+type FullText struct {
+	// MaxSize is the largest file indexed, in bytes; 0 means the default
+	MaxSize int64
+	// Extensions (such as ".go") and MimeTypes (prefixes such as "text/")
+	// select the files indexed; both empty means the defaults
+	Extensions []string
+	MimeTypes  []string
+}
+
+// Index configures the file name index of mounts with index = true
+type Index struct {
+	// Rescan is how often indexed mounts are walked again in full; 0 means
+	// the default
+	Rescan time.Duration
+}
+
+// Trash configures the trash that deleted files are moved to
+type Trash struct {
+	// Retention is how long deleted files are kept; 0 means the default
+	Retention time.Duration
+}
+
+// Upload configures uploads into writable mounts
+type Upload struct {
+	// MaxSize limits each uploaded file, in bytes; 0 means no limit
+	MaxSize int64
+	// OnConflict is "reject", "overwrite" or "rename"
+	OnConflict string
+}
+
+// Auth configures authentication
+type Auth struct {
+	// Htpasswd is an Apache-style password file; when set, every request
+	// must authenticate
+	Htpasswd string
+	// Htgroups is an Apache-style group file ("group: user1 user2")
+	Htgroups string
+	Realm    string
+	// SessionIdle ends login sessions unused for this long
+	SessionIdle time.Duration
+	// SessionMax ends login sessions this long after sign-in
+	SessionMax time.Duration
+	// Admins and AdminGroups are the users, and members of the groups,
+	// who may see the server's status
+	Admins      []string
+	AdminGroups []string
+}
+
+// Network restricts the addresses clients may connect from
+type Network struct {
+	// Allow and Deny are address ranges in CIDR notation, or single
+	// addresses. Denied ranges win; an empty Allow lets in every address
+	// that is not denied.
+	Allow []string
+	Deny  []string
+	// TrustedProxies are the ranges of reverse proxies whose Forwarded
+	// and X-Forwarded-For headers are believed
+	TrustedProxies []string
+}
+
+// Limits caps how fast clients may make requests and download
+type Limits struct {
+	// Requests is how many requests per second each client address may
+	// make, and RequestsBurst how many it may make at once after a quiet
+	// spell; 0 means no limit
+	Requests      float64
+	RequestsBurst int64
+	// UserRequests and UserRequestsBurst limit each signed-in user the
+	// same way, whatever address they come from
+	UserRequests      float64
+	UserRequestsBurst int64
+	// Bandwidth caps the bytes per second sent to all clients together,
+	// and ConnectionBandwidth those sent on each connection; 0 means no
+	// cap
+	Bandwidth           int64
+	ConnectionBandwidth int64
+	// BandwidthBurst is how many bytes may go out at once after a quiet
+	// spell; 0 means one second's worth
+	BandwidthBurst int64
+}
+
+// TLS configures HTTPS serving
+type TLS struct {
+	Cert       string
+	Key        string
+	SelfSigned bool
+	// Redirect is an address to listen on for plain HTTP requests that
+	// are redirected to HTTPS
+	Redirect string
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLS) Enabled() bool {
+	return t.Cert != "" || t.SelfSigned
+}
+
+// Mount describes a directory to serve and its settings
+type Mount struct {
+	Name     string
+	Path     string
+	ReadOnly bool
+	Hidden   bool
+	Listing  bool
+	// Trash moves deleted files to the mount's trash instead of removing
+	// them
+	Trash bool
+	// Exclude holds glob patterns of files that are never listed, served
+	// or archived
+	Exclude []string
+	// Readme shows README files and directory descriptions with listings
+	Readme bool
+	// Index keeps a file name index of the mount for fast searches
+	Index bool
+	// FullText also indexes the contents of text files, implying Index
+	FullText bool
+	// Auth holds "user:password" pairs allowed to access the mount
+	Auth []string
+	// Users and Groups restrict access to the listed users and groups
+	Users  []string
+	Groups []string
+	// Allow and Deny restrict access to clients in address ranges, as
+	// Network does for the whole server
+	Allow []string
+	Deny  []string
+	// Bandwidth caps the bytes per second sent from the mount; 0 means
+	// no cap
+	Bandwidth int64
+	// Source is the file:line the mount was declared at, used in errors
+	Source string
+}
+
+// Load reads and validates the configuration file at path
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := parse(f, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(doc, path)
+}
+
+func decode(doc *document, file string) (*Config, error) {
+	cfg := &Config{}
+	base := filepath.Dir(file)
+
+	d := newDecoder(file, doc.root)
+	d.strings("listen", &cfg.Listen)
+	d.str("state_dir", &cfg.StateDir)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if cfg.StateDir != "" {
+		cfg.StateDir = resolvePath(base, cfg.StateDir)
+	}
+
+	for _, t := range doc.tables {
+		switch {
+		case t.name == "tls" && !t.array:
+			if err := decodeTLS(file, base, t, &cfg.TLS); err != nil {
+				return nil, err
+			}
+		case t.name == "upload" && !t.array:
+			if err := decodeUpload(file, t, &cfg.Upload); err != nil {
+				return nil, err
+			}
+		case t.name == "trash" && !t.array:
+			if err := decodeTrash(file, t, &cfg.Trash); err != nil {
+				return nil, err
+			}
+		case t.name == "fulltext" && !t.array:
+			if err := decodeFullText(file, t, &cfg.FullText); err != nil {
+				return nil, err
+			}
+		case t.name == "index" && !t.array:
+			if err := decodeIndex(file, t, &cfg.Index); err != nil {
+				return nil, err
+			}
+		case t.name == "auth" && !t.array:
+			if err := decodeAuth(file, base, t, &cfg.Auth); err != nil {
+				return nil, err
+			}
+		case t.name == "network" && !t.array:
+			if err := decodeNetwork(file, t, &cfg.Network); err != nil {
+				return nil, err
+			}
+		case t.name == "limits" && !t.array:
+			if err := decodeLimits(file, t, &cfg.Limits); err != nil {
+				return nil, err
+			}
+		case t.name == "mount" && t.array:
+			m, err := decodeMount(file, base, t)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Mounts = append(cfg.Mounts, m)
+		case t.name == "mount":
+			return nil, &Error{File: file, Line: t.line, Msg: "mounts must be declared as [[mount]]"}
+		case t.name == "acl" && t.array:
+			rule, err := decodeACLRule(file, t)
+			if err != nil {
+				return nil, err
+			}
+			cfg.ACL = append(cfg.ACL, rule)
+		case t.name == "acl":
+			return nil, &Error{File: file, Line: t.line, Msg: "access rules must be declared as [[acl]]"}
+		default:
+			return nil, &Error{File: file, Line: t.line, Msg: fmt.Sprintf("unknown section %q", t.name)}
+		}
+	}
+
+	return cfg, nil
+}
+
+func decodeMount(file, base string, t *table) (Mount, error) {
+	m := Mount{
+		ReadOnly: true,
+		Listing:  true,
+		Trash:    true,
+		Readme:   true,
+		Source:   fmt.Sprintf("%s:%d", file, t.line),
+	}
+
+	d := newDecoder(file, t)
+	d.str("name", &m.Name)
+	d.str("path", &m.Path)
+	d.bool("read_only", &m.ReadOnly)
+	d.bool("hidden", &m.Hidden)
+	d.bool("listing", &m.Listing)
+	d.bool("trash", &m.Trash)
+	d.strings("exclude", &m.Exclude)
+	d.bool("readme", &m.Readme)
+	d.bool("index", &m.Index)
+	d.bool("fulltext", &m.FullText)
+	d.strings("auth", &m.Auth)
+	d.strings("users", &m.Users)
+	d.strings("groups", &m.Groups)
+	d.strings("allow", &m.Allow)
+	d.strings("deny", &m.Deny)
+	d.size("bandwidth", &m.Bandwidth)
+	if err := d.finish(); err != nil {
+		return Mount{}, err
+	}
+	if err := checkRanges(file, t, "allow", m.Allow); err != nil {
+		return Mount{}, err
+	}
+	if err := checkRanges(file, t, "deny", m.Deny); err != nil {
+		return Mount{}, err
+	}
+
+	if m.Name != "" {
+		if err := ValidateMountName(m.Name); err != nil {
+			return Mount{}, &Error{File: file, Line: t.keys["name"].line, Msg: err.Error()}
+		}
+	}
+	if m.Path == "" {
+		return Mount{}, &Error{File: file, Line: t.line, Msg: "mount is missing required key \"path\""}
+	}
+	m.Path = resolvePath(base, m.Path)
+
+	for i, pattern := range m.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil || strings.Trim(pattern, "/") == "" {
+			return Mount{}, &Error{File: file, Line: itemLine(t, "exclude", i),
+				Msg: fmt.Sprintf("invalid exclude pattern %q", pattern)}
+		}
+	}
+
+	for i, cred := range m.Auth {
+		user, _, ok := strings.Cut(cred, ":")
+		if !ok || user == "" {
+			return Mount{}, &Error{File: file, Line: itemLine(t, "auth", i),
+				Msg: fmt.Sprintf("auth entry %q must have the form \"user:password\"", cred)}
+		}
+	}
+
+	return m, nil
+}
+
+func decodeACLRule(file string, t *table) (ACLRule, error) {
+	rule := ACLRule{Source: fmt.Sprintf("%s:%d", file, t.line)}
+
+	d := newDecoder(file, t)
+	d.str("path", &rule.Path)
+	d.strings("users", &rule.Users)
+	d.strings("groups", &rule.Groups)
+	d.str("access", &rule.Access)
+	if err := d.finish(); err != nil {
+		return ACLRule{}, err
+	}
+
+	if rule.Path == "" {
+		return ACLRule{}, &Error{File: file, Line: t.line, Msg: "[[acl]] is missing required key \"path\""}
+	}
+	if !strings.HasPrefix(rule.Path, "/") || path.Clean(rule.Path) != rule.Path {
+		return ACLRule{}, &Error{File: file, Line: t.keys["path"].line,
+			Msg: fmt.Sprintf("path %q must be a clean URL path such as \"/docs\"", rule.Path)}
+	}
+	switch rule.Access {
+	case "read", "write", "admin":
+	case "":
+		return ACLRule{}, &Error{File: file, Line: t.line, Msg: "[[acl]] is missing required key \"access\""}
+	default:
+		return ACLRule{}, &Error{File: file, Line: t.keys["access"].line,
+			Msg: fmt.Sprintf("access must be \"read\", \"write\" or \"admin\", got %q", rule.Access)}
+	}
+	if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+		return ACLRule{}, &Error{File: file, Line: t.line, Msg: "[[acl]] needs \"users\" or \"groups\""}
+	}
+	return rule, nil
+}
+
+func decodeTLS(file, base string, t *table, tc *TLS) error {
+	d := newDecoder(file, t)
+	d.str("cert", &tc.Cert)
+	d.str("key", &tc.Key)
+	d.bool("self_signed", &tc.SelfSigned)
+	d.str("redirect", &tc.Redirect)
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	if (tc.Cert == "") != (tc.Key == "") {
+		return &Error{File: file, Line: t.line, Msg: "[tls] needs both \"cert\" and \"key\""}
+	}
+	if tc.Cert != "" && tc.SelfSigned {
+		return &Error{File: file, Line: t.keys["self_signed"].line, Msg: "self_signed cannot be combined with cert and key"}
+	}
+	if tc.Redirect != "" && !tc.Enabled() {
+		return &Error{File: file, Line: t.keys["redirect"].line, Msg: "redirect requires a certificate or self_signed = true"}
+	}
+	if tc.Cert != "" {
+		tc.Cert = resolvePath(base, tc.Cert)
+		tc.Key = resolvePath(base, tc.Key)
+	}
+	return nil
+}
+
+func decodeAuth(file, base string, t *table, ac *Auth) error {
+	d := newDecoder(file, t)
+	d.str("htpasswd", &ac.Htpasswd)
+	d.str("htgroups", &ac.Htgroups)
+	d.str("realm", &ac.Realm)
+	d.duration("session_idle", &ac.SessionIdle)
+	d.duration("session_max", &ac.SessionMax)
+	d.strings("admins", &ac.Admins)
+	d.strings("admin_groups", &ac.AdminGroups)
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	if ac.Htgroups != "" && ac.Htpasswd == "" {
+		return &Error{File: file, Line: t.keys["htgroups"].line, Msg: "htgroups requires htpasswd"}
+	}
+	if ac.Htpasswd != "" {
+		ac.Htpasswd = resolvePath(base, ac.Htpasswd)
+	}
+	if ac.Htgroups != "" {
+		ac.Htgroups = resolvePath(base, ac.Htgroups)
+	}
+	return nil
+}
+
+func decodeNetwork(file string, t *table, nc *Network) error {
+	d := newDecoder(file, t)
+	d.strings("allow", &nc.Allow)
+	d.strings("deny", &nc.Deny)
+	d.strings("trusted_proxies", &nc.TrustedProxies)
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	if err := checkRanges(file, t, "allow", nc.Allow); err != nil {
+		return err
+	}
+	if err := checkRanges(file, t, "deny", nc.Deny); err != nil {
+		return err
+	}
+	return checkRanges(file, t, "trusted_proxies", nc.TrustedProxies)
+}
+
+// checkRanges reports the first entry of the list at key that is not an
+// address range
+func checkRanges(file string, t *table, key string, ranges []string) error {
+	for i, s := range ranges {
+		if _, err := netfilter.ParsePrefix(s); err != nil {
+			return &Error{File: file, Line: itemLine(t, key, i), Msg: err.Error()}
+		}
+	}
+	return nil
+}
+
+func decodeLimits(file string, t *table, lc *Limits) error {
+	d := newDecoder(file, t)
+	d.rate("requests", &lc.Requests)
+	d.count("requests_burst", &lc.RequestsBurst)
+	d.rate("user_requests", &lc.UserRequests)
+	d.count("user_requests_burst", &lc.UserRequestsBurst)
+	d.size("bandwidth", &lc.Bandwidth)
+	d.size("connection_bandwidth", &lc.ConnectionBandwidth)
+	d.size("bandwidth_burst", &lc.BandwidthBurst)
+	return d.finish()
+}
+
+// itemLine returns the line of the i-th entry of the list at key, or of
+// the key itself when a lone string was given for the list
+func itemLine(t *table, key string, i int) int {
+	v := t.keys[key]
+	if i < len(v.items) {
+		return v.items[i].line
+	}
+	return v.line
+}
+
+func decodeUpload(file string, t *table, uc *Upload) error {
+	d := newDecoder(file, t)
+	d.size("max_size", &uc.MaxSize)
+	d.str("on_conflict", &uc.OnConflict)
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	switch uc.OnConflict {
+	case "", "reject", "overwrite", "rename":
+	default:
+		return &Error{File: file, Line: t.keys["on_conflict"].line,
+			Msg: fmt.Sprintf("on_conflict must be \"reject\", \"overwrite\" or \"rename\", got %q", uc.OnConflict)}
+	}
+	return nil
+}
+
+func decodeTrash(file string, t *table, tc *Trash) error {
+	d := newDecoder(file, t)
+	d.duration("retention", &tc.Retention)
+	return d.finish()
+}
+
+func decodeIndex(file string, t *table, ic *Index) error {
+	d := newDecoder(file, t)
+	d.duration("rescan", &ic.Rescan)
+	return d.finish()
+}
+
+func decodeFullText(file string, t *table, fc *FullText) error {
+	d := newDecoder(file, t)
+	d.size("max_size", &fc.MaxSize)
+	d.strings("extensions", &fc.Extensions)
+	d.strings("mime_types", &fc.MimeTypes)
+	if err := d.finish(); err != nil {
+		return err
+	}
+
+	for i, ext := range fc.Extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext == "." || strings.ContainsAny(ext, "/ ") {
+			return &Error{File: file, Line: itemLine(t, "extensions", i),
+				Msg: fmt.Sprintf("invalid extension %q", fc.Extensions[i])}
+		}
+		fc.Extensions[i] = ext
+	}
+	for i, mt := range fc.MimeTypes {
+		if mt == "" || strings.ContainsAny(mt, " ;") {
+			return &Error{File: file, Line: itemLine(t, "mime_types", i),
+				Msg: fmt.Sprintf("invalid MIME type %q", mt)}
+		}
+	}
+	return nil
+}
+
+// resolvePath expands ~ and makes path absolute relative to base
+func resolvePath(base, path string) string {
+	path = ExpandTilde(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	return path
+}
+
+// ReservedNames are the first URL path segments of fileserv's own pages
+// and APIs, which no mount may use
+var ReservedNames = []string{"_fileserv", "api", "dav"}
+
+// DefaultStateDir returns the directory used for generated data when no
+// state_dir is configured
+func DefaultStateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".fileserv"
+	}
+	return filepath.Join(dir, "fileserv")
+}
+
+// ParseDuration parses a Go duration, additionally accepting a number of
+// days such as "30d"
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil || dur < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return dur, nil
+}
+
+// ParseSize parses a byte count with an optional binary unit: "1024",
+// "512K", "100MB", "2GiB"
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimRight(strings.ToUpper(strings.TrimSpace(s)), "IB")
+	mult := int64(1)
+	if num != "" {
+		if i := strings.IndexByte("KMGTP", num[len(num)-1]); i >= 0 {
+			mult = 1 << (10 * (i + 1))
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// ParseRate parses a number of events per second, minute or hour, such as
+// "20/s", "300/m" or "1000/h". A bare number counts per second.
+func ParseRate(s string) (float64, error) {
+	num, unit, _ := strings.Cut(strings.TrimSpace(s), "/")
+	var per float64
+	switch strings.TrimSpace(unit) {
+	case "", "s":
+		per = 1
+	case "m":
+		per = 60
+	case "h":
+		per = 3600
+	default:
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n / per, nil
+}
+
+// ValidateMountName checks that name can be used as a mount's URL name. Names
+// may be nested with slashes, as in "team/docs".
+func ValidateMountName(name string) error {
+	if name == "" {
+		return fmt.Errorf("mount name is empty")
+	}
+	if first, _, _ := strings.Cut(name, "/"); slices.Contains(ReservedNames, first) {
+		return fmt.Errorf("mount name %q is reserved for fileserv's own pages", name)
+	}
+	for _, seg := range strings.Split(name, "/") {
+		switch seg {
+		case "":
+			return fmt.Errorf("mount name %q has an empty path segment", name)
+		case ".", "..":
+			return fmt.Errorf("mount name %q may not contain %q", name, seg)
+		}
+		if strings.ContainsAny(seg, "\\?#%~") {
+			return fmt.Errorf("mount name %q may not contain any of \\ ? # %% ~", name)
+		}
+		for _, c := range seg {
+			if c < 0x20 || c == 0x7f {
+				return fmt.Errorf("mount name %q contains a control character", name)
+			}
+		}
+	}
+	return nil
+}
+
+// ExpandTilde expands ~ to the user's home directory
+func ExpandTilde(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	if path == "~" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return home
+		}
+	}
+	return path
+}
+
+// decoder pulls typed values out of a table and remembers the first error
+type decoder struct {
+	file string
+	t    *table
+	used map[string]bool
+	err  error
+}
+
+func newDecoder(file string, t *table) *decoder {
+	return &decoder{file: file, t: t, used: make(map[string]bool)}
+}
+
+func (d *decoder) lookup(key string, kind valueKind) (value, bool) {
+	v, ok := d.t.keys[key]
+	if !ok || d.err != nil {
+		return value{}, false
+	}
+	d.used[key] = true
+	if v.kind != kind {
+		d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q must be a %s, got %s", key, kind, v.kind)}
+		return value{}, false
+	}
+	return v, true
+}
+
+func (d *decoder) str(key string, dst *string) {
+	if v, ok := d.lookup(key, kindString); ok {
+		*dst = v.str
+	}
+}
+
+func (d *decoder) bool(key string, dst *bool) {
+	if v, ok := d.lookup(key, kindBool); ok {
+		*dst = v.b
+	}
+}
+
+// duration accepts Go durations ("90m", "1h30m") and whole days ("7d")
+func (d *decoder) duration(key string, dst *time.Duration) {
+	v, ok := d.lookup(key, kindString)
+	if !ok {
+		return
+	}
+	dur, err := ParseDuration(v.str)
+	if err != nil {
+		d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q: %v", key, err)}
+		return
+	}
+	*dst = dur
+}
+
+// size accepts a byte count or a string with a unit such as "512MB"
+func (d *decoder) size(key string, dst *int64) {
+	v, ok := d.t.keys[key]
+	if ok && v.kind == kindInt && d.err == nil {
+		d.used[key] = true
+		*dst = v.num
+		return
+	}
+	v, ok = d.lookup(key, kindString)
+	if !ok {
+		return
+	}
+	n, err := ParseSize(v.str)
+	if err != nil {
+		d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q: %v", key, err)}
+		return
+	}
+	*dst = n
+}
+
+// count accepts a whole number that is not negative
+func (d *decoder) count(key string, dst *int64) {
+	v, ok := d.lookup(key, kindInt)
+	if !ok {
+		return
+	}
+	if v.num < 0 {
+		d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q must not be negative", key)}
+		return
+	}
+	*dst = v.num
+}
+
+// rate accepts a number per second, or a string such as "300/m"
+func (d *decoder) rate(key string, dst *float64) {
+	v, ok := d.t.keys[key]
+	if ok && v.kind == kindInt && d.err == nil {
+		d.used[key] = true
+		if v.num < 0 {
+			d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q must not be negative", key)}
+			return
+		}
+		*dst = float64(v.num)
+		return
+	}
+	v, ok = d.lookup(key, kindString)
+	if !ok {
+		return
+	}
+	r, err := ParseRate(v.str)
+	if err != nil {
+		d.err = &Error{File: d.file, Line: v.line, Msg: fmt.Sprintf("%q: %v", key, err)}
+		return
+	}
+	*dst = r
+}
+
+func (d *decoder) strings(key string, dst *[]string) {
+	v, ok := d.t.keys[key]
+	if ok && v.kind == kindString && d.err == nil {
+		// Accept a lone string where a list is expected
+		d.used[key] = true
+		*dst = []string{v.str}
+		return
+	}
+	if v, ok := d.lookup(key, kindArray); ok {
+		out := make([]string, 0, len(v.items))
+		for _, item := range v.items {
+			if item.kind != kindString {
+				d.err = &Error{File: d.file, Line: item.line, Msg: fmt.Sprintf("%q must only contain strings", key)}
+				return
+			}
+			out = append(out, item.str)
+		}
+		*dst = out
+	}
+}
+
+// finish reports the first decoding error or the first unknown key
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	for _, key := range d.t.order {
+		if !d.used[key] {
+			section := "top level"
+			if d.t.name != "" {
+				section = "[" + d.t.name + "]"
+				if d.t.array {
+					section = "[" + section + "]"
+				}
+			}
+			return &Error{File: d.file, Line: d.t.keys[key].line, Msg: fmt.Sprintf("unknown key %q in %s", key, section)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Error describes a problem found in a configuration file
+type Error struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindInt
+	kindBool
+	kindArray
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case kindString:
+		return "string"
+	case kindInt:
+		return "integer"
+	case kindBool:
+		return "boolean"
+	default:
+		return "array"
+	}
+}
+
+// value is a single right-hand side of a key = value pair
+type value struct {
+	kind  valueKind
+	str   string
+	num   int64
+	b     bool
+	items []value
+	line  int
+}
+
+// table is a [name] or [[name]] section together with its keys
+type table struct {
+	name  string
+	array bool
+	line  int
+	keys  map[string]value
+	order []string
+}
+
+// document is the parsed but not yet interpreted configuration file
+type document struct {
+	root   *table
+	tables []*table
+}
+
+// parser reads the TOML subset understood by fileserv: comments, bare and
+// quoted keys, [tables], [[arrays of tables]], strings, integers, booleans
+// and (possibly multi-line) arrays of those.
+type parser struct {
+	file string
+	sc   *bufio.Scanner
+	line int
+}
+
+func parse(r io.Reader, file string) (*document, error) {
+	p := &parser{file: file, sc: bufio.NewScanner(r)}
+	doc := &document{root: &table{keys: make(map[string]value)}}
+	current := doc.root
+
+	for p.sc.Scan() {
+		p.line++
+		text := strings.TrimSpace(stripComment(p.sc.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			t, err := p.parseHeader(text)
+			if err != nil {
+				return nil, err
+			}
+			if !t.array {
+				for _, other := range doc.tables {
+					if other.name == t.name {
+						return nil, p.errorf("table [%s] already defined on line %d", t.name, other.line)
+					}
+				}
+			}
+			doc.tables = append(doc.tables, t)
+			current = t
+			continue
+		}
+
+		key, rest, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, p.errorf("expected key = value, got %q", text)
+		}
+		key, err := p.parseKey(strings.TrimSpace(key))
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := current.keys[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+
+		start := p.line
+		v, err := p.parseValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, err
+		}
+		v.line = start
+		current.keys[key] = v
+		current.order = append(current.order, key)
+	}
+	if err := p.sc.Err(); err != nil {
+		return nil, &Error{File: p.file, Msg: err.Error()}
+	}
+
+	return doc, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{File: p.file, Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parseHeader(text string) (*table, error) {
+	t := &table{line: p.line, keys: make(map[string]value)}
+	inner := text
+	if strings.HasPrefix(text, "[[") {
+		if !strings.HasSuffix(text, "]]") {
+			return nil, p.errorf("unterminated table header %q", text)
+		}
+		t.array = true
+		inner = text[2 : len(text)-2]
+	} else {
+		if !strings.HasSuffix(text, "]") {
+			return nil, p.errorf("unterminated table header %q", text)
+		}
+		inner = text[1 : len(text)-1]
+	}
+
+	name, err := p.parseKey(strings.TrimSpace(inner))
+	if err != nil {
+		return nil, err
+	}
+	t.name = name
+	return t, nil
+}
+
+func (p *parser) parseKey(key string) (string, error) {
+	if key == "" {
+		return "", p.errorf("empty key")
+	}
+	if key[0] == '"' || key[0] == '\'' {
+		s, rest, err := p.parseString(key)
+		if err != nil {
+			return "", err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return "", p.errorf("unexpected %q after key", rest)
+		}
+		return s, nil
+	}
+	for _, c := range key {
+		if !isBareKeyChar(c) {
+			return "", p.errorf("invalid character %q in key %q", c, key)
+		}
+	}
+	return key, nil
+}
+
+func isBareKeyChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.'
+}
+
+func (p *parser) parseValue(text string) (value, error) {
+	v, rest, err := p.parseValuePrefix(text)
+	if err != nil {
+		return value{}, err
+	}
+	if strings.TrimSpace(rest) != "" {
+		return value{}, p.errorf("unexpected %q after value", rest)
+	}
+	return v, nil
+}
+
+// parseValuePrefix parses one value at the start of text and returns the
+// unconsumed remainder. Arrays may continue onto the following lines.
+func (p *parser) parseValuePrefix(text string) (value, string, error) {
+	switch {
+	case text == "":
+		return value{}, "", p.errorf("missing value")
+	case text[0] == '"' || text[0] == '\'':
+		s, rest, err := p.parseString(text)
+		return value{kind: kindString, str: s}, rest, err
+	case text[0] == '[':
+		return p.parseArray(text[1:])
+	}
+
+	end := strings.IndexAny(text, ",] \t")
+	if end < 0 {
+		end = len(text)
+	}
+	word, rest := text[:end], text[end:]
+
+	switch word {
+	case "true":
+		return value{kind: kindBool, b: true}, rest, nil
+	case "false":
+		return value{kind: kindBool, b: false}, rest, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 0, 64)
+	if err != nil {
+		return value{}, "", p.errorf("invalid value %q (strings must be quoted)", word)
+	}
+	return value{kind: kindInt, num: n}, rest, nil
+}
+
+func (p *parser) parseArray(text string) (value, string, error) {
+	arr := value{kind: kindArray}
+	for {
+		text = strings.TrimSpace(text)
+		for text == "" {
+			if !p.sc.Scan() {
+				return value{}, "", p.errorf("unterminated array")
+			}
+			p.line++
+			text = strings.TrimSpace(stripComment(p.sc.Text()))
+		}
+
+		if text[0] == ']' {
+			return arr, text[1:], nil
+		}
+
+		item, rest, err := p.parseValuePrefix(text)
+		if err != nil {
+			return value{}, "", err
+		}
+		if item.kind == kindArray {
+			return value{}, "", p.errorf("nested arrays are not supported")
+		}
+		item.line = p.line
+		arr.items = append(arr.items, item)
+
+		rest = strings.TrimSpace(rest)
+		switch {
+		case strings.HasPrefix(rest, ","):
+			text = rest[1:]
+		case strings.HasPrefix(rest, "]"), rest == "":
+			text = rest
+		default:
+			return value{}, "", p.errorf("expected , or ] in array, got %q", rest)
+		}
+	}
+}
+
+// parseString parses a basic ("...") or literal ('...') string at the start
+// of text and returns the remainder after the closing quote.
+func (p *parser) parseString(text string) (string, string, error) {
+	quote := text[0]
+	if quote == '\'' {
+		end := strings.IndexByte(text[1:], '\'')
+		if end < 0 {
+			return "", "", p.errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case '"':
+			return b.String(), text[i+1:], nil
+		case '\\':
+			i++
+			if i >= len(text) {
+				return "", "", p.errorf("unterminated string")
+			}
+			switch text[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(text[i])
+			case 'u':
+				if i+4 >= len(text) {
+					return "", "", p.errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(text[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", "", p.errorf("invalid unicode escape %q", text[i-1:i+5])
+				}
+				b.WriteRune(rune(r))
+				i += 4
+			default:
+				return "", "", p.errorf("invalid escape \\%c", text[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", p.errorf("unterminated string")
+}
+
+// stripComment removes a trailing # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
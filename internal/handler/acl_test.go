@@ -0,0 +1,402 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/models"
+)
+
+var (
+	alice = &auth.User{Name: "alice", Groups: []string{"interns"}}
+	carol = &auth.User{Name: "carol"}
+)
+
+// aclServer serves a mount "team" that every signed-in user may write to,
+// except /team/proj/secret which only carol may, and a mount "out" with no
+// rules. The tree below /team/proj also holds an excluded and an internal
+// file.
+func aclServer(t *testing.T) (*FileServer, string, string) {
+	t.Helper()
+	team, out := t.TempDir(), t.TempDir()
+	for name, content := range map[string]string{
+		"notes.txt":             "notes",
+		"proj/a.txt":            "alpha",
+		"proj/e.tmp":            "excluded",
+		"proj/.fileserv-x":      "internal",
+		"proj/secret/s.txt":     "secret",
+		"proj/secret/deep/d.md": "deeper",
+	} {
+		p := filepath.Join(team, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	acl := auth.NewACL([]auth.Rule{
+		{Path: "/team", Users: []string{auth.Everyone}, Access: auth.AccessWrite},
+		{Path: "/team/proj/secret", Users: []string{"carol"}, Access: auth.AccessWrite},
+	})
+	fs := NewFileServer([]models.Directory{
+		{Name: "team", Path: team, Exclude: []string{"*.tmp"}, DisableTrash: true},
+		{Name: "out", Path: out, DisableTrash: true},
+	}, Options{ACL: acl})
+	return fs, team, out
+}
+
+// serve sends a request as u, nil for anonymous, and returns the response
+func serve(h http.HandlerFunc, u *auth.User, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	if u != nil {
+		r = r.WithContext(auth.WithUser(r.Context(), u))
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func exists(t *testing.T, p string) bool {
+	t.Helper()
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func TestACLListing(t *testing.T) {
+	fs, _, _ := aclServer(t)
+	tests := []struct {
+		user   *auth.User
+		path   string
+		status int
+		want   []string
+	}{
+		{alice, "/team/proj", http.StatusOK, []string{"a.txt"}},
+		{carol, "/team/proj", http.StatusOK, []string{"a.txt", "secret"}},
+		{carol, "/team/proj/secret", http.StatusOK, []string{"deep", "s.txt"}},
+		{alice, "/team/proj/secret", http.StatusForbidden, nil},
+		{alice, "/team/proj/secret/deep", http.StatusForbidden, nil},
+		{nil, "/team/proj", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		w := serve(fs.HandleAPI, tt.user, http.MethodGet, APIPath+"list"+tt.path, "", nil)
+		if w.Code != tt.status {
+			t.Errorf("list %s as %v = %d, want %d", tt.path, tt.user, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var listing apiListing
+		if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("list %s as %v = %q, want %q", tt.path, tt.user, names, tt.want)
+		}
+	}
+
+	// The HTML listing and downloads keep to the same rules
+	w := serve(fs.HandleRequest, alice, http.MethodGet, "/team/proj/", "", nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("listing /team/proj as alice = %d, mentions secret: %v", w.Code, strings.Contains(w.Body.String(), "secret"))
+	}
+	for _, u := range []*auth.User{alice, nil} {
+		if w := serve(fs.HandleRequest, u, http.MethodGet, "/team/proj/secret/s.txt", "", nil); w.Code != http.StatusForbidden {
+			t.Errorf("GET /team/proj/secret/s.txt as %v = %d, want 403", u, w.Code)
+		}
+	}
+	if w := serve(fs.HandleRequest, carol, http.MethodGet, "/team/proj/secret/s.txt", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /team/proj/secret/s.txt as carol = %d, want 200", w.Code)
+	}
+}
+
+func TestACLOperations(t *testing.T) {
+	json := map[string]string{"Content-Type": "application/json"}
+	tests := []struct {
+		name   string
+		user   *auth.User
+		op     string
+		body   string
+		status int
+		// gone and made are paths below the mounts, as "team/..." or
+		// "out/...", that must be gone and made afterwards
+		gone, made []string
+	}{
+		{"mkdir in a denied subtree", alice, "mkdir", `{"path":"/team/proj/secret/new"}`, http.StatusForbidden, nil, nil},
+		{"mkdir", alice, "mkdir", `{"path":"/team/proj/new"}`, http.StatusOK, nil, []string{"team/proj/new"}},
+		{"anonymous mkdir", nil, "mkdir", `{"path":"/team/new"}`, http.StatusForbidden, nil, nil},
+		{"delete a denied file", alice, "delete", `{"paths":["/team/proj/secret/s.txt"]}`, http.StatusForbidden, nil, nil},
+		{"rename a denied file", alice, "rename", `{"path":"/team/proj/secret/s.txt","name":"t.txt"}`, http.StatusForbidden, nil, nil},
+		{"rename into a denied name", alice, "rename", `{"path":"/team/notes.txt","name":"x"}`, http.StatusOK, []string{"team/notes.txt"}, []string{"team/x"}},
+
+		// Regressions: a directory holding a stricter subtree cannot be
+		// deleted, moved or renamed as a whole
+		{"delete around a denied subtree", alice, "delete", `{"paths":["/team/proj"]}`, http.StatusForbidden, nil, []string{"team/proj/secret/s.txt"}},
+		{"rename around a denied subtree", alice, "rename", `{"path":"/team/proj","name":"p2"}`, http.StatusForbidden, nil, []string{"team/proj/secret/s.txt"}},
+		{"move around a denied subtree", alice, "move", `{"paths":["/team/proj"],"dest":"/out"}`, http.StatusForbidden, []string{"out/proj"}, []string{"team/proj/secret/s.txt"}},
+		{"move the whole mount's contents", alice, "move", `{"paths":["/team/proj/a.txt"],"dest":"/out"}`, http.StatusOK, []string{"team/proj/a.txt"}, []string{"out/a.txt"}},
+		{"move around as the subtree's user", carol, "move", `{"paths":["/team/proj"],"dest":"/out"}`, http.StatusOK, []string{"team/proj"}, []string{"out/proj/secret/s.txt"}},
+		{"delete as the subtree's user", carol, "delete", `{"paths":["/team/proj"]}`, http.StatusOK, []string{"team/proj"}, nil},
+
+		// Regression: copies leave out what the user cannot read, along
+		// with excluded and internal entries
+		{"copy around a denied subtree", alice, "copy", `{"paths":["/team/proj"],"dest":"/out"}`, http.StatusOK,
+			[]string{"out/proj/secret", "out/proj/e.tmp", "out/proj/.fileserv-x"}, []string{"out/proj/a.txt", "team/proj/secret/s.txt"}},
+		{"copy as the subtree's user", carol, "copy", `{"paths":["/team/proj"],"dest":"/out"}`, http.StatusOK,
+			[]string{"out/proj/e.tmp", "out/proj/.fileserv-x"}, []string{"out/proj/a.txt", "out/proj/secret/deep/d.md"}},
+		{"copy out of a denied subtree", alice, "copy", `{"paths":["/team/proj/secret/s.txt"],"dest":"/out"}`, http.StatusForbidden, []string{"out/s.txt"}, nil},
+		{"copy into a denied subtree", alice, "copy", `{"paths":["/team/notes.txt"],"dest":"/team/proj/secret"}`, http.StatusForbidden, []string{"team/proj/secret/notes.txt"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, team, out := aclServer(t)
+			root := func(p string) string {
+				mount, rel, _ := strings.Cut(p, "/")
+				if mount == "out" {
+					return filepath.Join(out, filepath.FromSlash(rel))
+				}
+				return filepath.Join(team, filepath.FromSlash(rel))
+			}
+
+			w := serve(fs.HandleOperation, tt.user, http.MethodPost, OpsPath+tt.op, tt.body, json)
+			if w.Code != tt.status {
+				t.Errorf("%s %s = %d, want %d: %s", tt.op, tt.body, w.Code, tt.status, w.Body)
+			}
+			for _, p := range tt.gone {
+				if exists(t, root(p)) {
+					t.Errorf("%s exists", p)
+				}
+			}
+			for _, p := range tt.made {
+				if !exists(t, root(p)) {
+					t.Errorf("%s does not exist", p)
+				}
+			}
+		})
+	}
+}
+
+func TestACLWebDAV(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *auth.User
+		method string
+		path   string
+		dest   string
+		status int
+		gone   []string
+		made   []string
+	}{
+		{"get a denied file", alice, http.MethodGet, "/team/proj/secret/s.txt", "", http.StatusNotFound, nil, nil},
+		{"get as the subtree's user", carol, http.MethodGet, "/team/proj/secret/s.txt", "", http.StatusOK, nil, nil},
+		{"put into a denied subtree", alice, http.MethodPut, "/team/proj/secret/new.txt", "", http.StatusForbidden, []string{"proj/secret/new.txt"}, nil},
+		{"mkcol in a denied subtree", alice, "MKCOL", "/team/proj/secret/new", "", http.StatusForbidden, []string{"proj/secret/new"}, nil},
+		{"delete a denied file", alice, http.MethodDelete, "/team/proj/secret/s.txt", "", http.StatusForbidden, nil, []string{"proj/secret/s.txt"}},
+
+		// Regressions for the subtree bypasses
+		{"delete around a denied subtree", alice, http.MethodDelete, "/team/proj", "", http.StatusForbidden, nil, []string{"proj/secret/s.txt"}},
+		{"move around a denied subtree", alice, "MOVE", "/team/proj", "/team/p2", http.StatusForbidden, []string{"p2"}, []string{"proj/secret/s.txt"}},
+		{"copy over a denied subtree", alice, "COPY", "/team/notes.txt", "/team/proj", http.StatusForbidden, nil, []string{"proj/secret/s.txt"}},
+		{"copy around a denied subtree", alice, "COPY", "/team/proj", "/team/p2", http.StatusCreated, []string{"p2/secret", "p2/e.tmp", "p2/.fileserv-x"}, []string{"p2/a.txt"}},
+		{"move as the subtree's user", carol, "MOVE", "/team/proj", "/team/p2", http.StatusCreated, []string{"proj"}, []string{"p2/secret/s.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, team, _ := aclServer(t)
+			header := map[string]string{}
+			if tt.dest != "" {
+				header["Destination"] = "http://example.com" + strings.TrimSuffix(DAVPath, "/") + tt.dest
+			}
+			body := ""
+			if tt.method == http.MethodPut {
+				body = "new"
+			}
+			w := serve(fs.DAVHandler().ServeHTTP, tt.user, tt.method, strings.TrimSuffix(DAVPath, "/")+tt.path, body, header)
+			if w.Code != tt.status {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.status)
+			}
+			for _, p := range tt.gone {
+				if exists(t, filepath.Join(team, p)) {
+					t.Errorf("%s exists", p)
+				}
+			}
+			for _, p := range tt.made {
+				if !exists(t, filepath.Join(team, p)) {
+					t.Errorf("%s does not exist", p)
+				}
+			}
+		})
+	}
+
+	// PROPFIND listings leave out denied subtrees
+	fs, _, _ := aclServer(t)
+	w := serve(fs.DAVHandler().ServeHTTP, alice, "PROPFIND", strings.TrimSuffix(DAVPath, "/")+"/team/proj", "", map[string]string{"Depth": "1"})
+	if w.Code != http.StatusMultiStatus || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("PROPFIND /team/proj as alice = %d, mentions secret: %v", w.Code, strings.Contains(w.Body.String(), "secret"))
+	}
+}
+
+func TestACLResumableTarget(t *testing.T) {
+	fs, team, _ := aclServer(t)
+	tests := []struct {
+		user *auth.User
+		dir  string
+		want error
+	}{
+		{alice, "/team/proj", nil},
+		{alice, "/team/proj/secret", os.ErrPermission},
+		{nil, "/team/proj", os.ErrPermission},
+		{carol, "/team/proj/secret", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, ResumablePath, nil)
+		if tt.user != nil {
+			r = r.WithContext(auth.WithUser(r.Context(), tt.user))
+		}
+		got, err := fs.ResumableBackend().Target(r, tt.dir)
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("Target(%s) as %v: %v", tt.dir, tt.user, err)
+		case tt.want == nil && got != filepath.Join(team, filepath.FromSlash(strings.TrimPrefix(tt.dir, "/team"))):
+			t.Errorf("Target(%s) as %v = %s", tt.dir, tt.user, got)
+		case tt.want != nil && !errors.Is(err, tt.want):
+			t.Errorf("Target(%s) as %v: got %v, want %v", tt.dir, tt.user, err, tt.want)
+		}
+	}
+}
+
+func TestACLSearch(t *testing.T) {
+	fs, _, _ := aclServer(t)
+	accept := map[string]string{"Accept": "application/json"}
+	tests := []struct {
+		user   *auth.User
+		query  string
+		status int
+		want   []string
+	}{
+		{alice, "?q=.", http.StatusOK, []string{"/team/notes.txt", "/team/proj/a.txt"}},
+		{carol, "?q=s", http.StatusOK, []string{"/team/notes.txt", "/team/proj/secret", "/team/proj/secret/s.txt"}},
+		{alice, "?q=s&in=/team/proj/secret", http.StatusForbidden, nil},
+		{nil, "?q=s", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		w := serve(fs.HandleSearch, tt.user, http.MethodGet, SearchPath+tt.query, "", accept)
+		if w.Code != tt.status {
+			t.Errorf("search %s as %v = %d, want %d", tt.query, tt.user, w.Code, tt.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var res apiSearch
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, f := range res.Results {
+			paths = append(paths, f.Path)
+		}
+		slices.Sort(paths)
+		if !slices.Equal(paths, tt.want) {
+			t.Errorf("search %s as %v = %q, want %q", tt.query, tt.user, paths, tt.want)
+		}
+	}
+}
+
+func TestACLArchive(t *testing.T) {
+	fs, _, _ := aclServer(t)
+	tests := []struct {
+		user *auth.User
+		path string
+		want []string
+	}{
+		{alice, "/team/proj", []string{"proj/", "proj/a.txt"}},
+		{carol, "/team/proj", []string{"proj/", "proj/a.txt", "proj/secret/", "proj/secret/deep/", "proj/secret/deep/d.md", "proj/secret/s.txt"}},
+		{alice, "/team/proj/secret", nil},
+	}
+	for _, tt := range tests {
+		w := serve(fs.HandleRequest, tt.user, http.MethodGet, tt.path+"?archive=zip", "", nil)
+		if tt.want == nil {
+			if w.Code != http.StatusForbidden {
+				t.Errorf("archive of %s as %v = %d, want 403", tt.path, tt.user, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("archive of %s as %v = %d, want 200", tt.path, tt.user, w.Code)
+			continue
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("archive of %s as %v = %q, want %q", tt.path, tt.user, names, tt.want)
+		}
+	}
+}
+
+func TestACLShare(t *testing.T) {
+	fs, _, _ := aclServer(t)
+	create := func(u *auth.User, p string) (string, int) {
+		w := serve(fs.HandleShares, u, http.MethodPost, SharesPath, `{"path":"`+p+`"}`, map[string]string{"Content-Type": "application/json"})
+		var sh models.Share
+		json.Unmarshal(w.Body.Bytes(), &sh)
+		return sh.URL, w.Code
+	}
+
+	if _, status := create(alice, "/team/proj/secret"); status != http.StatusForbidden {
+		t.Errorf("sharing /team/proj/secret as alice = %d, want 403", status)
+	}
+	if _, status := create(nil, "/team/proj"); status != http.StatusForbidden {
+		t.Errorf("sharing /team/proj anonymously = %d, want 403", status)
+	}
+
+	// A share of a directory stops at subtrees with rules of their own,
+	// even for the user the rules let in
+	for _, u := range []*auth.User{alice, carol} {
+		link, status := create(u, "/team/proj")
+		if status != http.StatusCreated {
+			t.Fatalf("sharing /team/proj as %v = %d, want 201", u, status)
+		}
+		for sub, want := range map[string]int{
+			"/a.txt":        http.StatusOK,
+			"/secret/s.txt": http.StatusNotFound,
+			"/secret/":      http.StatusNotFound,
+			"/e.tmp":        http.StatusNotFound,
+		} {
+			if w := serve(fs.HandleShare, nil, http.MethodGet, link+sub, "", nil); w.Code != want {
+				t.Errorf("GET %s of a share by %v = %d, want %d", sub, u, w.Code, want)
+			}
+		}
+		w := serve(fs.HandleShare, nil, http.MethodGet, link+"/?archive=zip", "", nil)
+		if w.Code != http.StatusOK || bytes.Contains(w.Body.Bytes(), []byte("secret/")) {
+			t.Errorf("archive of a share by %v = %d, holds secret: %v", u, w.Code, bytes.Contains(w.Body.Bytes(), []byte("secret/")))
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/models"
+)
+
+// APIPath is where version 1 of the JSON API is served:
+//
+//	GET /api/v1/mounts         the visible mounts
+//	GET /api/v1/list/<path>    the entries of a directory, a page at a time
+//	GET /api/v1/stat/<path>    a single file or directory
+//	GET /api/v1/search?q=      file names matching a query, as for SearchPath
+//	GET /api/v1/index          the state of the file name indexes (admins only)
+//
+// Mount and directory URLs also answer with JSON when the request accepts
+// application/json.
+const APIPath = "/api/v1/"
+
+// apiMount describes a mount in the JSON API
+type apiMount struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Writable bool   `json:"writable"`
+	Listing  bool   `json:"listing"`
+}
+
+// apiListing is a page of a directory listing in the JSON API. Next is the
+// query string of the following page, empty on the last one.
+type apiListing struct {
+	Path     string            `json:"path"`
+	Writable bool              `json:"writable"`
+	Entries  []models.FileInfo `json:"entries"`
+	Total    int               `json:"total"`
+	Page     int               `json:"page"`
+	Pages    int               `json:"pages"`
+	Limit    int               `json:"limit"`
+	Next     string            `json:"next,omitempty"`
+}
+
+// mountURL maps the URL of an API, trash or WebDAV resource about a path
+// inside a mount to that path, and returns other URLs unchanged
+func mountURL(urlPath string) string {
+	for _, prefix := range []string{APIPath + "list", APIPath + "stat", strings.TrimSuffix(TrashPath, "/"), strings.TrimSuffix(DAVPath, "/")} {
+		if rest, ok := strings.CutPrefix(urlPath, prefix); ok && (rest == "" || rest[0] == '/') {
+			return rest
+		}
+	}
+	return urlPath
+}
+
+// mountList describes the mounts shown on the root page to the user of ctx
+func (fs *FileServer) mountList(ctx context.Context) []apiMount {
+	mounts := []apiMount{}
+	for _, dir := range fs.visibleDirectories(ctx) {
+		mounts = append(mounts, apiMount{
+			Name:     dir.Name,
+			URL:      (&url.URL{Path: "/" + dir.Name}).EscapedPath(),
+			Writable: !dir.ReadOnly && fs.access(ctx, dir, "/"+dir.Name) >= auth.AccessWrite,
+			Listing:  !dir.DisableListing,
+		})
+	}
+	return mounts
+}
+
+func newListing(dir models.Directory, relPath string, writable bool, entries []models.FileInfo, p models.Pagination) apiListing {
+	if entries == nil {
+		entries = []models.FileInfo{}
+	}
+	return apiListing{
+		Path:     path.Join("/"+dir.Name, relPath),
+		Writable: writable,
+		Entries:  entries,
+		Total:    p.Total,
+		Page:     p.Page,
+		Pages:    p.Pages,
+		Limit:    p.Limit,
+		Next:     p.NextURL,
+	}
+}
+
+// HandleAPI serves the JSON API
+func (fs *FileServer) HandleAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeOpError(w, opErrorf(http.StatusMethodNotAllowed, "method not allowed"))
+		return
+	}
+
+	endpoint, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+	switch endpoint {
+	case "mounts":
+		if rest != "" {
+			break
+		}
+		writeJSON(w, http.StatusOK, fs.mountList(r.Context()))
+		return
+	case "list":
+		fs.apiList(w, r, rest)
+		return
+	case "stat":
+		fs.apiStat(w, r, rest)
+		return
+	case "search":
+		if rest != "" {
+			break
+		}
+		fs.apiSearch(w, r)
+		return
+	case "index":
+		if rest != "" {
+			break
+		}
+		if !fs.opts.Admins.Contains(auth.UserFrom(r.Context())) {
+			writeOpError(w, opErrorf(http.StatusForbidden, "only admins may see the index status"))
+			return
+		}
+		writeJSON(w, http.StatusOK, fs.indexStatus())
+		return
+	}
+	writeOpError(w, opErrorf(http.StatusNotFound, "unknown endpoint %s", r.URL.Path))
+}
+
+func (fs *FileServer) apiList(w http.ResponseWriter, r *http.Request, urlPath string) {
+	t, err := fs.resolveTarget(r.Context(), urlPath, auth.AccessRead)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if t.dir.DisableListing {
+		writeOpError(w, opErrorf(http.StatusForbidden, "listing /%s is disabled", t.dir.Name))
+		return
+	}
+	if info, err := os.Stat(t.fsPath); err != nil {
+		writeOpError(w, err)
+		return
+	} else if !info.IsDir() {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "%s is not a directory", t.url))
+		return
+	}
+
+	view, err := parseListingView(w, r)
+	if err != nil {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+	entries, total, err := readDirectory(t.dir, t.fsPath, t.rel, view, fs.unreadable(r.Context(), t.dir))
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	writable := !t.dir.ReadOnly && fs.access(r.Context(), t.dir, t.url) >= auth.AccessWrite
+	writeJSON(w, http.StatusOK, newListing(t.dir, t.rel, writable, entries, view.pagination(total)))
+}
+
+func (fs *FileServer) apiStat(w http.ResponseWriter, r *http.Request, urlPath string) {
+	t, err := fs.resolveTarget(r.Context(), urlPath, auth.AccessRead)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	info, err := os.Stat(t.fsPath)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	fi := newFileInfo(t.dir, t.rel, info)
+	if !fi.IsDir && fi.MimeType == "" {
+		fi.MimeType = sniffType(t.fsPath)
+	}
+	writeJSON(w, http.StatusOK, fi)
+}
+
+// sniffType guesses a file's MIME type from its first bytes, as
+// http.ServeFile does for files without a known extension
+func sniffType(name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"fileserv/internal/models"
+)
+
+// archiveWriter adds files to an archive being streamed to the client
+type archiveWriter interface {
+	// add writes one entry; body is nil for directories
+	add(name string, info os.FileInfo, body io.Reader) error
+	Close() error
+}
+
+// serveArchive streams the directory at fsPath, or the entries of it named
+// with ?name=, as a zip or tar.gz archive, leaving out what hide reports.
+// Nothing is buffered on disk and the walk stops as soon as the client goes
+// away.
+func (fs *FileServer) serveArchive(w http.ResponseWriter, r *http.Request, dir models.Directory, fsPath, relPath string, hide func(relPath string) bool) {
+	format := r.URL.Query().Get("archive")
+	var ext, contentType string
+	switch format {
+	case "zip":
+		ext, contentType = ".zip", "application/zip"
+	case "tar.gz", "tgz":
+		ext, contentType = ".tar.gz", "application/gzip"
+	default:
+		http.Error(w, "Bad Request: archive must be zip or tar.gz", http.StatusBadRequest)
+		return
+	}
+
+	base := path.Base(relPath)
+	if relPath == "/" {
+		base = strings.ReplaceAll(dir.Name, "/", "-")
+	}
+
+	// The whole directory is archived under its own name; a selection of
+	// entries is archived as is
+	type root struct{ fsPath, relPath, name string }
+	var roots []root
+	if names := r.URL.Query()["name"]; len(names) > 0 {
+		for _, name := range names {
+			clean, err := cleanFileName(name)
+			rel := path.Join(relPath, clean)
+			if err != nil || clean != name || isExcluded(dir, rel) || hide(rel) {
+				http.Error(w, "Bad Request: invalid name "+name, http.StatusBadRequest)
+				return
+			}
+			if _, err := os.Lstat(filepath.Join(fsPath, clean)); err != nil {
+				http.Error(w, "Not Found: "+name, http.StatusNotFound)
+				return
+			}
+			roots = append(roots, root{filepath.Join(fsPath, clean), rel, clean})
+		}
+	} else {
+		roots = append(roots, root{fsPath, relPath, base})
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": base + ext}))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var aw archiveWriter
+	if format == "zip" {
+		aw = &zipArchive{w: zip.NewWriter(w)}
+	} else {
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, w: tar.NewWriter(gz)}
+	}
+
+	ctx := r.Context()
+	for _, rt := range roots {
+		err := filepath.WalkDir(rt.fsPath, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				if p == rt.fsPath {
+					return err
+				}
+				// Unreadable directories are left out rather than failing
+				// the download
+				log.Printf("Skipping %s in archive: %v", p, err)
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rel := filepath.ToSlash(strings.TrimPrefix(p, rt.fsPath))
+			if strings.HasPrefix(d.Name(), internalPrefix) || isExcluded(dir, path.Join(rt.relPath, rel)) || hide(path.Join(rt.relPath, rel)) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return addToArchive(aw, p, rt.name+rel, d)
+		})
+		if err == nil {
+			continue
+		}
+		// The response has started, so all that is left is to break the
+		// connection, letting the client know the archive is incomplete
+		log.Printf("Archive of %s aborted: %v", "/"+dir.Name+relPath, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if err := aw.Close(); err != nil {
+		log.Printf("Archive of %s aborted: %v", "/"+dir.Name+relPath, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// addToArchive writes the file at p to the archive. Symbolic links are
+// followed to regular files only, so links cannot make the walk loop.
+func addToArchive(aw archiveWriter, p, name string, d os.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if info, err = os.Stat(p); err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		return aw.add(name, info, nil)
+	case info.Mode().IsRegular():
+		f, err := os.Open(p)
+		if err != nil {
+			// Unreadable files are left out rather than failing the download
+			log.Printf("Skipping %s in archive: %v", p, err)
+			return nil
+		}
+		defer f.Close()
+		return aw.add(name, info, f)
+	default:
+		// Devices, sockets and pipes have no contents to archive
+		return nil
+	}
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(name string, info os.FileInfo, body io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	} else {
+		hdr.Method = zip.Deflate
+	}
+
+	fw, err := a.w.CreateHeader(hdr)
+	if err != nil || body == nil {
+		return err
+	}
+	_, err = io.Copy(fw, body)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func (a *tarArchive) add(name string, info os.FileInfo, body io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if err := a.w.WriteHeader(hdr); err != nil || body == nil {
+		return err
+	}
+	// A file that changed size since it was stat'ed must not corrupt the
+	// archive, so exactly the announced number of bytes is written
+	n, err := io.Copy(a.w, io.LimitReader(body, hdr.Size))
+	if err == nil && n < hdr.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
@@ -0,0 +1,482 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"fileserv/internal/auth"
+)
+
+// DAVPath is where the mounts are served over WebDAV. Its root is a
+// read-only collection holding one member per mount.
+const DAVPath = "/dav/"
+
+// DAVHandler returns the WebDAV (class 1 and 2) handler for the mounts
+func (fs *FileServer) DAVHandler() http.Handler {
+	h := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(DAVPath, "/"),
+		FileSystem: davFS{fs: fs, started: time.Now()},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := fs.davRefuse(r); status != 0 {
+			if status == http.StatusMethodNotAllowed {
+				w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if r.Method == http.MethodPut {
+			body := &davBody{ReadCloser: http.MaxBytesReader(w, r.Body, fs.maxUploadBody())}
+			r.Body = body
+			r = r.WithContext(context.WithValue(r.Context(), davBodyKey{}, body))
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			name := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(DAVPath, "/"))
+			if dir, _, ok := fs.resolve(path.Clean("/" + name)); ok {
+				sandboxUploads(w, dir)
+				w = fs.opts.Throttle.Writer(w, r, dir.Name)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// davRefuse answers write methods aimed at read-only mounts or at paths the
+// user may not write to, and deletes, moves and copies reaching into such
+// subtrees, with 403, and those aimed at the virtual collections above the
+// mounts or new members of them with 405, before the WebDAV handler turns
+// them into less helpful errors. It returns 0 for requests that may go
+// ahead.
+func (fs *FileServer) davRefuse(r *http.Request) int {
+	var paths []string
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete, "MKCOL", "PROPPATCH", "LOCK":
+		paths = append(paths, r.URL.Path)
+	case "MOVE":
+		paths = append(paths, r.URL.Path, davDestination(r))
+	case "COPY":
+		// Copying out of a read-only mount is fine
+		paths = append(paths, davDestination(r))
+	default:
+		return 0
+	}
+
+	for _, p := range paths {
+		name, ok := strings.CutPrefix(p, strings.TrimSuffix(DAVPath, "/"))
+		if !ok {
+			// A destination on another server is the handler's to refuse
+			continue
+		}
+		name = path.Clean("/" + name)
+		if dir, _, ok := fs.resolve(name); ok {
+			if dir.ReadOnly || fs.access(r.Context(), dir, name) < auth.AccessWrite {
+				return http.StatusForbidden
+			}
+			if r.Method == http.MethodDelete || r.Method == "MOVE" || r.Method == "COPY" {
+				if fs.checkSubtree(r.Context(), name, auth.AccessWrite) != nil {
+					return http.StatusForbidden
+				}
+			}
+			continue
+		}
+		// Neither the virtual collections nor new members of them can be
+		// written to
+		if name == "/" || len(fs.childMounts(r.Context(), name)) > 0 || path.Dir(name) == "/" ||
+			len(fs.childMounts(r.Context(), path.Dir(name))) > 0 {
+			return http.StatusMethodNotAllowed
+		}
+	}
+	return 0
+}
+
+// davDestination returns the path of a COPY or MOVE request's destination
+func davDestination(r *http.Request) string {
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// childMounts returns the names of the path segments directly below
+// urlPath that lead to mounts the user may see: "docs" for "/team" when
+// "team/docs" is mounted
+func (fs *FileServer) childMounts(ctx context.Context, urlPath string) []string {
+	prefix := strings.TrimSuffix(urlPath, "/") + "/"
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range fs.directories {
+		rest, ok := strings.CutPrefix("/"+dir.Name, prefix)
+		if !ok || dir.Hidden || fs.access(ctx, dir, "/"+dir.Name) < auth.AccessRead {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// davFS is a webdav.FileSystem over the mounts. Every path goes through
+// resolveTarget, so the checks are those of the other handlers.
+type davFS struct {
+	fs      *FileServer
+	started time.Time
+}
+
+// target resolves name and translates refusals into the errors the WebDAV
+// handler understands
+func (d davFS) target(ctx context.Context, name string, write bool) (target, error) {
+	need := auth.AccessRead
+	if write {
+		need = auth.AccessWrite
+	}
+	t, err := d.fs.resolveTarget(ctx, name, need)
+	var oe *opError
+	if errors.As(err, &oe) {
+		if oe.status == http.StatusNotFound {
+			return t, os.ErrNotExist
+		}
+		return t, os.ErrPermission
+	}
+	return t, err
+}
+
+// virtual reports whether name is one of the collections above the mounts
+func (d davFS) virtual(ctx context.Context, name string) bool {
+	if _, _, ok := d.fs.resolve(name); ok {
+		return false
+	}
+	return name == "/" || len(d.fs.childMounts(ctx, name)) > 0
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = path.Clean("/" + name)
+	if d.virtual(ctx, name) {
+		return os.ErrExist
+	}
+	t, err := d.target(ctx, name, true)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(t.fsPath, perm)
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = path.Clean("/" + name)
+	write := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+
+	if d.virtual(ctx, name) {
+		if write {
+			return nil, os.ErrPermission
+		}
+		return &davVirtual{url: name, info: d.virtualInfo(name), fs: d, ctx: ctx}, nil
+	}
+
+	t, err := d.target(ctx, name, write)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_TRUNC != 0 {
+		return d.upload(ctx, t, perm)
+	}
+	f, err := os.OpenFile(t.fsPath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &davFile{File: f, t: t, fs: d, ctx: ctx}, nil
+}
+
+// upload opens a temporary file that replaces the target once it is
+// written in full, as uploads through the web pages do
+func (d davFS) upload(ctx context.Context, t target, perm os.FileMode) (webdav.File, error) {
+	if info, err := os.Stat(t.fsPath); err == nil && info.IsDir() {
+		return nil, os.ErrExist
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.fsPath), internalPrefix+"upload-*")
+	if err != nil {
+		return nil, err
+	}
+	// Temporary files are private; the file gets the permissions asked
+	// for, as a usual umask leaves them
+	if err := tmp.Chmod(perm.Perm() &^ 0o022); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	body, _ := ctx.Value(davBodyKey{}).(*davBody)
+	return &davUpload{
+		davFile: &davFile{File: tmp, t: t, fs: d, ctx: ctx},
+		body:    body,
+		limit:   d.fs.opts.MaxUploadSize,
+	}, nil
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	name = path.Clean("/" + name)
+	if d.virtual(ctx, name) {
+		return os.ErrPermission
+	}
+	t, err := d.target(ctx, name, true)
+	if err != nil {
+		return err
+	}
+	if t.isRoot() || d.fs.checkSubtree(ctx, t.url, auth.AccessWrite) != nil {
+		return os.ErrPermission
+	}
+
+	if hasTrash(t.dir) {
+		var user string
+		if u := auth.UserFrom(ctx); u != nil {
+			user = u.Name
+		}
+		return moveToTrash(t, user)
+	}
+	return os.RemoveAll(t.fsPath)
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, err := d.target(ctx, path.Clean("/"+oldName), true)
+	if err != nil {
+		return err
+	}
+	dst, err := d.target(ctx, path.Clean("/"+newName), true)
+	if err != nil {
+		return err
+	}
+	if src.isRoot() || dst.isRoot() ||
+		d.fs.checkSubtree(ctx, src.url, auth.AccessWrite) != nil || d.fs.checkSubtree(ctx, dst.url, auth.AccessWrite) != nil {
+		return os.ErrPermission
+	}
+
+	err = moveNoReplace(src.fsPath, dst.fsPath)
+	if errors.Is(err, errConflict) {
+		return os.ErrExist
+	}
+	return err
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = path.Clean("/" + name)
+	if d.virtual(ctx, name) {
+		return d.virtualInfo(name), nil
+	}
+	t, err := d.target(ctx, name, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(t.fsPath)
+	if err != nil {
+		return nil, err
+	}
+	return davInfo(t, info), nil
+}
+
+func (d davFS) virtualInfo(name string) os.FileInfo {
+	return virtualInfo{name: path.Base(name), modTime: d.started}
+}
+
+// davInfo names a mount root after the mount rather than its directory
+func davInfo(t target, info os.FileInfo) os.FileInfo {
+	if t.isRoot() {
+		return renamedInfo{FileInfo: info, name: path.Base("/" + t.dir.Name)}
+	}
+	return info
+}
+
+// davFile is a file or directory inside a mount. Listings leave out the
+// same entries as the web pages and include mounts nested inside.
+type davFile struct {
+	*os.File
+	t   target
+	fs  davFS
+	ctx context.Context
+}
+
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.t.dir.DisableListing {
+		return nil, nil
+	}
+	infos, err := f.File.Readdir(count)
+
+	nested := make(map[string]bool)
+	for _, name := range f.fs.fs.childMounts(f.ctx, f.t.url) {
+		nested[name] = true
+	}
+
+	hide := f.fs.fs.unreadable(f.ctx, f.t.dir)
+	visible := infos[:0]
+	for _, info := range infos {
+		rel := path.Join(f.t.rel, info.Name())
+		if strings.HasPrefix(info.Name(), internalPrefix) || isExcluded(f.t.dir, rel) || nested[info.Name()] || hide(rel) {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(f.t.fsPath, info.Name())); err == nil {
+				info = target
+			}
+		}
+		visible = append(visible, info)
+	}
+	if count <= 0 {
+		for name := range nested {
+			if info, err := f.fs.Stat(f.ctx, path.Join(f.t.url, name)); err == nil {
+				visible = append(visible, info)
+			}
+		}
+	}
+	return visible, err
+}
+
+func (f *davFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return davInfo(f.t, info), nil
+}
+
+// davUpload is a file being written over WebDAV, by PUT or as the
+// destination of COPY. It is written to a temporary file that is moved over
+// the target on Close, so an upload that is cut off or too large leaves the
+// old file as it was.
+type davUpload struct {
+	*davFile
+	// body is the request body of a PUT, nil for a COPY
+	body    *davBody
+	limit   int64
+	written int64
+	err     error
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	if u.limit > 0 && u.written+int64(len(p)) > u.limit {
+		u.err = &http.MaxBytesError{Limit: u.limit}
+		return 0, u.err
+	}
+	n, err := u.File.Write(p)
+	u.written += int64(n)
+	u.err = err
+	return n, err
+}
+
+// ReadFrom hides that of the file, which would write past Write
+func (u *davUpload) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{u}, r)
+}
+
+func (u *davUpload) Close() error {
+	tmp := u.File.Name()
+	defer os.Remove(tmp)
+
+	err := u.File.Close()
+	if u.err != nil {
+		return u.err
+	}
+	if err != nil {
+		return err
+	}
+	if u.body != nil && !u.body.done {
+		return io.ErrUnexpectedEOF
+	}
+	_, err = placeFile(tmp, filepath.Dir(u.t.fsPath), filepath.Base(u.t.fsPath), ConflictOverwrite)
+	if errors.Is(err, errConflict) {
+		return os.ErrExist
+	}
+	return err
+}
+
+type davBodyKey struct{}
+
+// davBody notes whether a PUT body was read to its end, which the WebDAV
+// handler does not tell the file it copies the body into
+type davBody struct {
+	io.ReadCloser
+	done bool
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// davVirtual is one of the read-only collections above the mounts
+type davVirtual struct {
+	url  string
+	info os.FileInfo
+	fs   davFS
+	ctx  context.Context
+	read bool
+}
+
+func (v *davVirtual) Readdir(count int) ([]os.FileInfo, error) {
+	if v.read {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	v.read = true
+
+	var infos []os.FileInfo
+	for _, name := range v.fs.fs.childMounts(v.ctx, v.url) {
+		if info, err := v.fs.Stat(v.ctx, path.Join(v.url, name)); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+func (v *davVirtual) Stat() (os.FileInfo, error)     { return v.info, nil }
+func (v *davVirtual) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (v *davVirtual) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (v *davVirtual) Seek(int64, int) (int64, error) { return 0, nil }
+func (v *davVirtual) Close() error                   { return nil }
+
+type virtualInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (i virtualInfo) Name() string       { return i.name }
+func (i virtualInfo) Size() int64        { return 0 }
+func (i virtualInfo) Mode() os.FileMode  { return os.ModeDir | 0o555 }
+func (i virtualInfo) ModTime() time.Time { return i.modTime }
+func (i virtualInfo) IsDir() bool        { return true }
+func (i virtualInfo) Sys() any           { return nil }
+
+type renamedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string { return i.name }
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Drop boxes are share links of a directory that take files instead of
+// giving them out. Visitors see nothing of the directory: each submission
+// is stored in a folder of its own, named after the time and the
+// submitter, whose description says who sent it.
+
+// maxSubmitterField is how long the name and email of a submitter may be
+const maxSubmitterField = 200
+
+var (
+	// errTypeNotAccepted refuses files a drop box does not take
+	errTypeNotAccepted = errors.New("file type not accepted")
+	errInvalidField    = errors.New("invalid field")
+)
+
+// dropBoxLimit returns the size limit of uploads to a drop box, the
+// smaller of its own and the server's; 0 means no limit
+func (fs *FileServer) dropBoxLimit(sh share) int64 {
+	limit := fs.opts.MaxUploadSize
+	if sh.MaxSize > 0 && (limit <= 0 || sh.MaxSize < limit) {
+		limit = sh.MaxSize
+	}
+	return limit
+}
+
+// serveDropBox shows the upload form of a drop box and receives what is
+// sent with it
+func (fs *FileServer) serveDropBox(w http.ResponseWriter, r *http.Request, sh share, base, sub string) {
+	dir, rel, ok := fs.resolve(sh.Path)
+	if !ok || sub != "" || isInternal(rel) || isExcluded(dir, rel) {
+		http.NotFound(w, r)
+		return
+	}
+	if dir.ReadOnly {
+		http.Error(w, "Forbidden: mount is read-only", http.StatusForbidden)
+		return
+	}
+	if !reachable(r.Context(), dir) {
+		http.Error(w, "Forbidden: this drop box is not available from your network", http.StatusForbidden)
+		return
+	}
+	fsPath := filepath.Join(dir.Path, filepath.FromSlash(rel))
+	if info, err := os.Stat(fsPath); err != nil || !info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		data := newShareData(sh)
+		data.MaxSize = fs.dropBoxLimit(sh)
+		data.Types = sh.Types
+		data.Received, _ = strconv.Atoi(r.URL.Query().Get("sent"))
+		renderShare(w, http.StatusOK, data)
+	case http.MethodPost:
+		fs.receiveSubmission(w, r, sh, base, fsPath)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// receiveSubmission stores the files of a multipart POST to a drop box in
+// a new folder of the directory at fsPath. The name and email fields must
+// come before the files. Nothing is kept of a submission that fails.
+func (fs *FileServer) receiveSubmission(w http.ResponseWriter, r *http.Request, sh share, base, fsPath string) {
+	limit := fs.dropBoxLimit(sh)
+	r.Body = http.MaxBytesReader(w, r.Body, uploadBody(limit))
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Bad Request: expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	var name, email, folder string
+	var saved []string
+	fail := func(err error) {
+		if folder != "" {
+			os.RemoveAll(filepath.Join(fsPath, folder))
+		}
+		switch {
+		case errors.Is(err, errTypeNotAccepted):
+			http.Error(w, "Unsupported Media Type: "+err.Error(), http.StatusUnsupportedMediaType)
+		case errors.Is(err, errInvalidField):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		default:
+			uploadError(w, err)
+		}
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		switch {
+		case part.FormName() == "name" && part.FileName() == "":
+			name, err = readField(part)
+		case part.FormName() == "email" && part.FileName() == "":
+			if email, err = readField(part); err == nil && email != "" {
+				if _, perr := mail.ParseAddress(email); perr != nil {
+					err = fmt.Errorf("%w: email is not an email address", errInvalidField)
+				}
+			}
+		case part.FormName() == "file" && part.FileName() != "":
+			if !acceptsType(sh.Types, part.FileName()) {
+				err = fmt.Errorf("%w: %s", errTypeNotAccepted, part.FileName())
+				break
+			}
+			if folder == "" {
+				if folder, err = newSubmissionFolder(fsPath, name, time.Now()); err != nil {
+					break
+				}
+			}
+			var file string
+			if file, err = saveFile(filepath.Join(fsPath, folder), part.FileName(), part, limit, ConflictRename); err == nil {
+				saved = append(saved, path.Join(folder, file))
+			}
+		}
+		part.Close()
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	if len(saved) == 0 {
+		http.Error(w, "Bad Request: no files in upload", http.StatusBadRequest)
+		return
+	}
+	if err := writeSubmitter(filepath.Join(fsPath, folder), name, email, time.Now()); err != nil {
+		log.Printf("Error describing submission %s: %v", folder, err)
+	}
+	log.Printf("Received %d file(s) in %s through drop box %s", len(saved), path.Join(sh.Path, folder), sh.ID)
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusCreated, uploadResult{Files: saved})
+		return
+	}
+	http.Redirect(w, r, base+"?sent="+strconv.Itoa(len(saved)), http.StatusSeeOther)
+}
+
+// readField reads a short form field, with runs of white space, line
+// breaks included, turned into single spaces
+func readField(part io.Reader) (string, error) {
+	b, err := io.ReadAll(io.LimitReader(part, maxSubmitterField+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxSubmitterField {
+		return "", fmt.Errorf("%w: longer than %d bytes", errInvalidField, maxSubmitterField)
+	}
+	return strings.Join(strings.Fields(string(b)), " "), nil
+}
+
+// acceptsType reports whether a drop box taking types accepts the file
+// called name. Types are extensions such as ".pdf" or MIME types such as
+// "image/*"; no types means anything goes.
+func acceptsType(types []string, name string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	for _, t := range types {
+		t = strings.ToLower(t)
+		switch {
+		case strings.HasPrefix(t, "."):
+			if t == ext {
+				return true
+			}
+		case strings.HasSuffix(t, "/*"):
+			if mimeType != "" && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*")) {
+				return true
+			}
+		case t == mimeType:
+			return true
+		}
+	}
+	return false
+}
+
+// newSubmissionFolder creates the folder a submission is stored in, named
+// after when it arrived and who sent it, and returns its name
+func newSubmissionFolder(fsPath, submitter string, now time.Time) (string, error) {
+	if len([]rune(submitter)) > 60 {
+		submitter = string([]rune(submitter)[:60])
+	}
+	// Names are not paths
+	submitter = strings.NewReplacer("/", "-", `\`, "-").Replace(submitter)
+	who, err := cleanFileName(submitter)
+	if err != nil {
+		who = "anonymous"
+	}
+	name := now.Format("2006-01-02 150405") + " " + who
+	candidate := name
+	for i := 1; ; i++ {
+		err := os.Mkdir(filepath.Join(fsPath, candidate), 0o755)
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) || i > 9999 {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
+
+// writeSubmitter records who sent a submission as the description of its
+// folder, shown above its listing
+func writeSubmitter(folder, name, email string, now time.Time) error {
+	who := "an anonymous visitor"
+	if name != "" {
+		who = "**" + escapeMarkdown(name) + "**"
+	}
+	if email != "" {
+		who += " (" + escapeMarkdown(email) + ")"
+	}
+	text := fmt.Sprintf("📥 Sent through a drop box by %s on %s.\n", who, now.Format("2006-01-02 15:04"))
+	return os.WriteFile(filepath.Join(folder, descriptionFile), []byte(text), 0o644)
+}
+
+// escapeMarkdown keeps text supplied by visitors from being read as
+// Markdown, such as links
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune("\\`*_{}[]()<>#+-.!|~&", c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
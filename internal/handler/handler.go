@@ -1,27 +1,135 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"sort"
+	"runtime"
 	"strings"
+	"time"
 
+	"fileserv/internal/auth"
+	"fileserv/internal/index"
 	"fileserv/internal/models"
+	"fileserv/internal/netfilter"
+	"fileserv/internal/ratelimit"
 	"fileserv/internal/template"
 )
 
+// Options tune the behaviour of a FileServer
+type Options struct {
+	// MaxUploadSize limits the size of each uploaded file; 0 means no limit
+	MaxUploadSize int64
+	// OnConflict decides what happens when an upload's name is taken
+	OnConflict ConflictPolicy
+	// ResumablePath is where the tus endpoint is served; empty disables
+	// resumable uploads in the web UI
+	ResumablePath string
+	// TrashRetention is how long deleted files stay in a mount's trash;
+	// 0 means DefaultTrashRetention
+	TrashRetention time.Duration
+	// IndexDir is where the file name indexes of mounts with Index set
+	// are saved; empty keeps them in memory only
+	IndexDir string
+	// IndexRescan is how often indexed mounts are walked in full; 0 means
+	// index.DefaultRescan
+	IndexRescan time.Duration
+	// FullText selects the files indexed by content in mounts with
+	// FullText set
+	FullText index.TextOptions
+	// ThumbDir is where image thumbnails are cached; empty makes them
+	// again for every request
+	ThumbDir string
+	// ShareDir is where share links and the key they are signed with are
+	// kept; empty keeps them in memory, so links end with the process
+	ShareDir string
+	// AnonymousShares lets users who are not signed in create share links,
+	// for servers without accounts
+	AnonymousShares bool
+	// Admins may see the state of the indexes and everyone's share links
+	Admins auth.Admins
+	// ACL grants users and groups access to mounts and subtrees of them;
+	// nil leaves access to the mounts' users and groups
+	ACL *auth.ACL
+	// Throttle caps the bandwidth of downloads; nil leaves them uncapped
+	Throttle *ratelimit.Throttle
+}
+
 // FileServer handles file serving and directory listings
 type FileServer struct {
 	directories []models.Directory
+	opts        Options
+	// indexes holds the file name index of each mount with Index set, by
+	// mount name
+	indexes map[string]*index.Index
+	// thumbSem bounds how many thumbnails are made at once, and
+	// thumbPixels how many pixels they decode between them
+	thumbSem    chan struct{}
+	thumbPixels *pixelBudget
+	shares      *shareStore
 }
 
 // NewFileServer creates a new file server instance
-func NewFileServer(dirs []models.Directory) *FileServer {
-	return &FileServer{
+func NewFileServer(dirs []models.Directory, opts Options) *FileServer {
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictRename
+	}
+	if opts.TrashRetention <= 0 {
+		opts.TrashRetention = DefaultTrashRetention
+	}
+	fs := &FileServer{
 		directories: dirs,
+		opts:        opts,
+		indexes:     make(map[string]*index.Index),
+		thumbSem:    make(chan struct{}, runtime.GOMAXPROCS(0)),
+		thumbPixels: newPixelBudget(thumbBudget),
+	}
+	if opts.ThumbDir != "" {
+		go fs.thumbLoop()
+	}
+	shares, err := openShareStore(opts.ShareDir)
+	if err != nil {
+		log.Printf("Error opening share links, new links will not outlive the process: %v", err)
+		shares, _ = openShareStore("")
+	}
+	fs.shares = shares
+	for _, dir := range dirs {
+		if hasTrash(dir) {
+			go fs.trashLoop()
+			break
+		}
 	}
+	for _, dir := range dirs {
+		if !dir.Index {
+			continue
+		}
+		file := ""
+		if opts.IndexDir != "" {
+			file = filepath.Join(opts.IndexDir, url.PathEscape(dir.Name)+".idx")
+		}
+		var text *index.TextOptions
+		if dir.FullText {
+			text = &opts.FullText
+		}
+		fs.indexes[dir.Name] = index.Open(index.Options{
+			Root:   dir.Path,
+			File:   file,
+			Rescan: opts.IndexRescan,
+			Skip: func(rel string, isDir bool) bool {
+				return fs.unsearchable(dir, rel, isDir)
+			},
+			Text: text,
+		})
+	}
+	return fs
 }
 
 // HandleRequest handles incoming HTTP requests
@@ -34,33 +142,213 @@ func (fs *FileServer) HandleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Try to match the path to a directory
+	dir, relPath, ok := fs.resolve(path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Files fileserv keeps inside mounts are not part of them, and
+	// excluded files are not served
+	if isInternal(relPath) || isExcluded(dir, relPath) {
+		http.NotFound(w, r)
+		return
+	}
+	access := fs.access(r.Context(), dir, path)
+	if access < auth.AccessRead {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	fsPath := filepath.Join(dir.Path, filepath.Clean(relPath))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		fs.serveFromDirectory(w, r, dir, fsPath, relPath)
+	case http.MethodPost, http.MethodPut:
+		if dir.ReadOnly {
+			http.Error(w, "Forbidden: mount is read-only", http.StatusForbidden)
+			return
+		}
+		if access < auth.AccessWrite {
+			http.Error(w, "Forbidden: no write access", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPut {
+			fs.handlePut(w, r, fsPath, path)
+			return
+		}
+		if info, err := os.Stat(fsPath); err != nil || !info.IsDir() {
+			http.Error(w, "Not Found: uploads must target a directory", http.StatusNotFound)
+			return
+		}
+		fs.handleUpload(w, r, fsPath, path)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// isInternal reports whether a mount-relative path names one of the files
+// fileserv keeps inside mounts, or anything below one
+func isInternal(relPath string) bool {
+	for _, seg := range strings.Split(relPath, "/") {
+		if strings.HasPrefix(seg, internalPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether a mount-relative path matches one of the
+// mount's exclude patterns, or lies below a directory that does. Patterns
+// containing a slash match the whole path from the mount root; others match
+// any single name.
+func isExcluded(dir models.Directory, relPath string) bool {
+	if len(dir.Exclude) == 0 {
+		return false
+	}
+	rel := strings.Trim(relPath, "/")
+	for _, pattern := range dir.Exclude {
+		if strings.Contains(pattern, "/") {
+			pattern = strings.Trim(pattern, "/")
+			// Match the pattern against the path and each of its parents
+			for p := rel; p != "." && p != ""; p = path.Dir(p) {
+				if ok, _ := path.Match(pattern, p); ok {
+					return true
+				}
+			}
+			continue
+		}
+		for _, seg := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(pattern, seg); ok && seg != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Mount returns the mount serving a URL path, and the path of the file the
+// URL is about. API, trash and WebDAV resources inside a mount count as
+// served by that mount.
+func (fs *FileServer) Mount(path string) (models.Directory, string, bool) {
+	filePath := mountURL(path)
+	dir, _, ok := fs.resolve(filePath)
+	return dir, filePath, ok
+}
+
+// resolve finds the mount serving a URL path and returns the path relative
+// to the mount root. Nested mount names win over the mounts they live in,
+// so "/team/docs/x" goes to "team/docs" even when "team" is also served.
+func (fs *FileServer) resolve(path string) (models.Directory, string, bool) {
+	var match models.Directory
+	found := false
+
 	for _, dir := range fs.directories {
 		// Check if the path starts with the directory name
 		prefix := "/" + dir.Name
-		if path == prefix || strings.HasPrefix(path, prefix+"/") {
-			// Remove the prefix to get the relative path
-			relPath := strings.TrimPrefix(path, prefix)
-			if relPath == "" {
-				relPath = "/"
-			}
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if !found || len(dir.Name) > len(match.Name) {
+			match = dir
+			found = true
+		}
+	}
+	if !found {
+		return models.Directory{}, "", false
+	}
 
-			fsPath := filepath.Join(dir.Path, filepath.Clean(relPath))
-			fs.serveFromDirectory(w, r, dir, fsPath, relPath)
-			return
+	// Remove the prefix to get the relative path
+	relPath := strings.TrimPrefix(path, "/"+match.Name)
+	if relPath == "" {
+		relPath = "/"
+	}
+	return match, relPath, true
+}
+
+// access returns what the user of ctx may do with urlPath, which dir
+// serves: nothing if the mount is restricted to other users or addresses,
+// what the ACL grants where it has rules, and anything elsewhere
+func (fs *FileServer) access(ctx context.Context, dir models.Directory, urlPath string) auth.Access {
+	user := auth.UserFrom(ctx)
+	if !auth.CanAccess(user, dir) || !reachable(ctx, dir) {
+		return auth.AccessNone
+	}
+	if access, ok := fs.opts.ACL.Access(user, urlPath); ok {
+		return access
+	}
+	return auth.AccessAdmin
+}
+
+// checkSubtree refuses changes to everything below urlPath at once, such as
+// deleting or moving it, when the user of ctx has less than need somewhere
+// inside it
+func (fs *FileServer) checkSubtree(ctx context.Context, urlPath string, need auth.Access) error {
+	if p, ok := fs.opts.ACL.Restricted(auth.UserFrom(ctx), urlPath, need); ok {
+		return opErrorf(http.StatusForbidden, "%s access to %s denied", need, p)
+	}
+	return nil
+}
+
+// reachable reports whether the client of ctx connects from an address
+// dir's network restrictions let in
+func reachable(ctx context.Context, dir models.Directory) bool {
+	if len(dir.Allow) == 0 && len(dir.Deny) == 0 {
+		return true
+	}
+	addr, _ := netfilter.Client(ctx)
+	ok, _ := netfilter.Permits(dir.Allow, dir.Deny, addr)
+	return ok
+}
+
+// unreadable returns a test for the paths in dir, relative to its root,
+// that the user of ctx may not read, to leave them out of listings and
+// archives
+func (fs *FileServer) unreadable(ctx context.Context, dir models.Directory) func(relPath string) bool {
+	return func(relPath string) bool {
+		return fs.access(ctx, dir, path.Join("/"+dir.Name, relPath)) < auth.AccessRead
+	}
+}
+
+// visibleDirectories returns the mounts that are not hidden and that the
+// user of ctx may read
+func (fs *FileServer) visibleDirectories(ctx context.Context) []models.Directory {
+	var dirs []models.Directory
+	for _, dir := range fs.directories {
+		if !dir.Hidden && fs.access(ctx, dir, "/"+dir.Name) >= auth.AccessRead {
+			dirs = append(dirs, dir)
 		}
 	}
+	return dirs
+}
 
-	http.NotFound(w, r)
+// currentUser returns who is signed in and whether they can log out
+func currentUser(r *http.Request) (string, bool) {
+	if u := auth.UserFrom(r.Context()); u != nil {
+		return u.Name, auth.HasSession(r.Context())
+	}
+	return "", false
 }
 
 // showRootListing shows the root directory selector
 func (fs *FileServer) showRootListing(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, fs.mountList(r.Context()))
+		return
+	}
+
 	data := models.PageData{
 		CurrentPath: "/",
 		Files:       nil,
-		Directories: fs.directories,
+		Directories: fs.visibleDirectories(r.Context()),
 		IsRoot:      true,
+		Search:      fs.searchForm(""),
+	}
+	data.User, data.CanLogout = currentUser(r)
+	if fs.opts.Admins.Contains(auth.UserFrom(r.Context())) {
+		data.Indexes = fs.indexStatus()
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -70,6 +358,26 @@ func (fs *FileServer) showRootListing(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// flushEvery is how much of a page is written before it is sent on
+const flushEvery = 32 << 10
+
+// flushWriter sends a page to the client as it is rendered, so browsers can
+// show the start of long listings before the end has been written
+type flushWriter struct {
+	w       http.ResponseWriter
+	pending int
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.pending += n
+	if f.pending >= flushEvery {
+		http.NewResponseController(f.w).Flush()
+		f.pending = 0
+	}
+	return n, err
+}
+
 // serveFromDirectory serves files from a specific directory
 func (fs *FileServer) serveFromDirectory(w http.ResponseWriter, r *http.Request, dir models.Directory, fsPath, relPath string) {
 	info, err := os.Stat(fsPath)
@@ -84,60 +392,203 @@ func (fs *FileServer) serveFromDirectory(w http.ResponseWriter, r *http.Request,
 	}
 
 	if info.IsDir() {
+		if dir.DisableListing {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Has("archive") {
+			fs.serveArchive(fs.opts.Throttle.Writer(w, r, dir.Name), r, dir, fsPath, relPath, fs.unreadable(r.Context(), dir))
+			return
+		}
 		fs.showDirectoryListing(w, r, dir, fsPath, relPath)
 		return
 	}
 
-	http.ServeFile(w, r, fsPath)
+	if r.URL.Query().Has("view") {
+		fs.showPreview(w, r, dir, fsPath, relPath, info)
+		return
+	}
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	}
+	sandboxUploads(w, dir)
+	http.ServeFile(fs.opts.Throttle.Writer(w, r, dir.Name), r, fsPath)
+}
+
+// sandboxUploads keeps files in writable mounts from running scripts as
+// part of the server's origin. An uploaded HTML or SVG page could otherwise
+// use the session of whoever opens it to change or share their files.
+func sandboxUploads(w http.ResponseWriter, dir models.Directory) {
+	if dir.ReadOnly {
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// readBatch is how many entries are read from a directory at a time
+const readBatch = 1024
+
+// dirEntry is an entry of a directory being listed. Only its name and type
+// are known up front; its FileInfo is fetched when needed.
+type dirEntry struct {
+	d     os.DirEntry
+	name  string
+	isDir bool
+	info  os.FileInfo
+}
+
+// stat returns the entry's FileInfo, that of the target for symbolic links
+func (e *dirEntry) stat() (os.FileInfo, error) {
+	if e.info == nil {
+		info, err := e.d.Info()
+		if err != nil {
+			return nil, err
+		}
+		e.info = info
+	}
+	return e.info, nil
+}
+
+// readDirectory lists the page of the directory at fsPath that view asks
+// for, and the number of entries on all pages, leaving out those hide
+// reports. The directory is read in batches and only the entries shown are
+// stat'ed, unless the sort order needs them all.
+func readDirectory(dir models.Directory, fsPath, relPath string, view listingView, hide func(relPath string) bool) ([]models.FileInfo, int, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var entries []dirEntry
+	for {
+		batch, err := f.ReadDir(readBatch)
+		for _, d := range batch {
+			name := d.Name()
+			rel := path.Join(relPath, name)
+			if strings.HasPrefix(name, internalPrefix) || isExcluded(dir, rel) || !view.match(name) || hide(rel) {
+				continue
+			}
+			e := dirEntry{d: d, name: name, isDir: d.IsDir()}
+
+			// Links are described by what they point to
+			if d.Type()&os.ModeSymlink != 0 {
+				if target, err := os.Stat(filepath.Join(fsPath, name)); err == nil {
+					e.info, e.isDir = target, target.IsDir()
+				}
+			}
+			entries = append(entries, e)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	entries = view.sortEntries(entries)
+
+	start := min((view.Page-1)*view.Limit, len(entries))
+	end := min(start+view.Limit, len(entries))
+	files := make([]models.FileInfo, 0, end-start)
+	for i := start; i < end; i++ {
+		info, err := entries[i].stat()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		files = append(files, newFileInfo(dir, path.Join(relPath, entries[i].name), info))
+	}
+	return files, len(entries), nil
+}
+
+// newFileInfo describes the entry at relPath in dir
+func newFileInfo(dir models.Directory, relPath string, info os.FileInfo) models.FileInfo {
+	urlPath := path.Join("/"+dir.Name, relPath)
+	fi := models.FileInfo{
+		Name:    path.Base(relPath),
+		IsDir:   info.IsDir(),
+		Type:    "file",
+		Path:    urlPath,
+		URL:     (&url.URL{Path: urlPath}).EscapedPath(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+	}
+	if relPath == "/" {
+		fi.Name = dir.Name
+	}
+	if fi.IsDir {
+		fi.Type = "directory"
+		fi.Size = 0
+	} else {
+		fi.MimeType = mime.TypeByExtension(path.Ext(fi.Name))
+		if hasThumbnail(fi.Name) {
+			fi.Thumbnail = thumbURL(urlPath, fi.ModTime)
+		}
+	}
+	return fi
 }
 
 // showDirectoryListing shows the contents of a directory
 func (fs *FileServer) showDirectoryListing(w http.ResponseWriter, r *http.Request, dir models.Directory, fsPath, relPath string) {
-	f, err := os.Open(fsPath)
+	view, err := parseListingView(w, r)
 	if err != nil {
-		log.Printf("Error opening directory %s: %v", fsPath, err)
-		http.Error(w, "Forbidden", http.StatusForbidden)
+		if wantsJSON(r) {
+			writeOpError(w, opErrorf(http.StatusBadRequest, "%v", err))
+		} else {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
-	defer f.Close()
 
-	entries, err := f.Readdir(-1)
+	fileInfos, total, err := readDirectory(dir, fsPath, relPath, view, fs.unreadable(r.Context(), dir))
 	if err != nil {
 		log.Printf("Error reading directory %s: %v", fsPath, err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		if errors.Is(err, os.ErrPermission) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
 		return
 	}
+	pagination := view.pagination(total)
 
-	var fileInfos []models.FileInfo
-	for _, entry := range entries {
-		// Build the URL path
-		urlPath := "/" + dir.Name + filepath.ToSlash(filepath.Join(relPath, entry.Name()))
-
-		fileInfos = append(fileInfos, models.FileInfo{
-			Name:  entry.Name(),
-			IsDir: entry.IsDir(),
-			Path:  urlPath,
-			Size:  entry.Size(),
-		})
+	urlPath := path.Join("/"+dir.Name, relPath)
+	writable := !dir.ReadOnly && fs.access(r.Context(), dir, urlPath) >= auth.AccessWrite
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, newListing(dir, relPath, writable, fileInfos, pagination))
+		return
 	}
 
-	// Sort: directories first, then by name
-	sort.Slice(fileInfos, func(i, j int) bool {
-		if fileInfos[i].IsDir != fileInfos[j].IsDir {
-			return fileInfos[i].IsDir
-		}
-		return strings.ToLower(fileInfos[i].Name) < strings.ToLower(fileInfos[j].Name)
-	})
-
 	data := models.PageData{
 		CurrentPath: "/" + dir.Name + relPath,
 		Files:       fileInfos,
-		Directories: fs.directories,
+		Directories: fs.visibleDirectories(r.Context()),
 		IsRoot:      false,
+		Writable:    writable,
+		MaxUpload:   fs.opts.MaxUploadSize,
+		TusURL:      fs.opts.ResumablePath,
+		SortLinks:   view.links(),
+		Filter:      view.Filter,
+		Pagination:  pagination,
+		Grid:        view.Grid,
+		LayoutURL:   view.layoutURL(),
+		Search:      fs.searchForm(urlPath),
+		CanShare:    fs.canShare(r),
+		CanDropBox:  writable && fs.canCreateDropBox(r, urlPath),
+	}
+	if hasTrash(dir) && fs.access(r.Context(), dir, "/"+dir.Name) >= auth.AccessAdmin {
+		data.TrashURL = TrashPath + dir.Name
 	}
+	if view.Page == 1 {
+		data.Description, data.Readme = readmes(dir, fsPath, relPath)
+	}
+	data.User, data.CanLogout = currentUser(r)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := template.RenderListing(w, data); err != nil {
+	if err := template.RenderListing(&flushWriter{w: w}, data); err != nil {
 		log.Printf("Error rendering template: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fileserv/internal/models"
+)
+
+// BenchmarkReadDirectory lists the first page of synthetic directories.
+// Sorting by name only reads the directory; sorting by size stats every
+// entry as well.
+func BenchmarkReadDirectory(b *testing.B) {
+	for _, n := range []int{10_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			fsPath := b.TempDir()
+			for i := range n {
+				if err := os.WriteFile(filepath.Join(fsPath, fmt.Sprintf("file-%07d.txt", i)), nil, 0o644); err != nil {
+					b.Fatal(err)
+				}
+			}
+			dir := models.Directory{Name: "bench", Path: fsPath}
+			hide := func(string) bool { return false }
+
+			for _, sort := range []string{"name", "size"} {
+				b.Run(sort, func(b *testing.B) {
+					view := listingView{Sort: sort, Page: 1, Limit: DefaultPageSize}
+					for b.Loop() {
+						files, total, err := readDirectory(dir, fsPath, "/", view, hide)
+						if err != nil {
+							b.Fatal(err)
+						}
+						if total != n || len(files) != min(n, DefaultPageSize) {
+							b.Fatalf("got %d of %d entries, want %d of %d", len(files), total, min(n, DefaultPageSize), n)
+						}
+					}
+				})
+			}
+		})
+	}
+}
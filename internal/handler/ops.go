@@ -0,0 +1,445 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/models"
+)
+
+// OpsPath is where the file operation endpoints are served, one per
+// operation: mkdir, rename, move, copy, delete, and restore and purge for
+// items in a mount's trash
+const OpsPath = "/_fileserv/ops/"
+
+// opRequest is the JSON body of a file operation. Paths are URL paths such
+// as "/docs/report.pdf".
+type opRequest struct {
+	// Path is the entry to create or rename
+	Path string `json:"path"`
+	// Paths are the entries to move, copy or delete
+	Paths []string `json:"paths"`
+	// Name is the new name for rename
+	Name string `json:"name"`
+	// Dest is the directory to move or copy into
+	Dest string `json:"dest"`
+	// IDs are the trash items to restore or purge; Path names the mount
+	IDs []string `json:"ids"`
+}
+
+// opError is a failed operation with the status to answer it with
+type opError struct {
+	status int
+	msg    string
+}
+
+func (e *opError) Error() string { return e.msg }
+
+func opErrorf(status int, format string, args ...any) error {
+	return &opError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// target is a URL path resolved to a location inside a mount
+type target struct {
+	dir    models.Directory
+	rel    string
+	fsPath string
+	url    string
+}
+
+// isRoot reports whether the target is the mount root itself
+func (t target) isRoot() bool {
+	return t.rel == "/"
+}
+
+// resolveTarget resolves a URL path the same way HandleRequest does and
+// checks the user of the request context has the access to it that need
+// asks for
+func (fs *FileServer) resolveTarget(ctx context.Context, urlPath string, need auth.Access) (target, error) {
+	urlPath = path.Clean("/" + urlPath)
+	dir, rel, ok := fs.resolve(urlPath)
+	if !ok || isInternal(rel) || isExcluded(dir, rel) {
+		return target{}, opErrorf(http.StatusNotFound, "%s not found", urlPath)
+	}
+	if access := fs.access(ctx, dir, urlPath); access < need {
+		if access == auth.AccessNone {
+			return target{}, opErrorf(http.StatusForbidden, "access to %s denied", urlPath)
+		}
+		return target{}, opErrorf(http.StatusForbidden, "%s access to %s denied", need, urlPath)
+	}
+	if need >= auth.AccessWrite && dir.ReadOnly {
+		return target{}, opErrorf(http.StatusForbidden, "/%s is read-only", dir.Name)
+	}
+	return target{
+		dir:    dir,
+		rel:    rel,
+		fsPath: filepath.Join(dir.Path, filepath.Clean(rel)),
+		url:    urlPath,
+	}, nil
+}
+
+// HandleOperation serves the JSON file operation endpoints
+func (fs *FileServer) HandleOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Requiring JSON keeps plain cross-site forms from reaching here
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Unsupported Media Type: expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req opRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "invalid request: %v", err))
+		return
+	}
+
+	var err error
+	switch op := strings.TrimPrefix(r.URL.Path, OpsPath); op {
+	case "mkdir":
+		err = fs.opMkdir(r, req)
+	case "rename":
+		err = fs.opRename(r, req)
+	case "move", "copy":
+		err = fs.opTransfer(r, req, op == "copy")
+	case "delete":
+		err = fs.opDelete(r, req)
+	case "restore", "purge":
+		err = fs.opTrash(r, req, op == "restore")
+	default:
+		err = opErrorf(http.StatusNotFound, "unknown operation %q", op)
+	}
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (fs *FileServer) opMkdir(r *http.Request, req opRequest) error {
+	t, err := fs.resolveTarget(r.Context(), req.Path, auth.AccessWrite)
+	if err != nil {
+		return err
+	}
+	if t.isRoot() {
+		return opErrorf(http.StatusConflict, "%s already exists", t.url)
+	}
+	if _, err := cleanFileName(path.Base(t.url)); err != nil {
+		return opErrorf(http.StatusBadRequest, "%v", err)
+	}
+	if err := os.Mkdir(t.fsPath, 0o755); err != nil {
+		return err
+	}
+	log.Printf("Created directory %s", t.url)
+	return nil
+}
+
+func (fs *FileServer) opRename(r *http.Request, req opRequest) error {
+	t, err := fs.resolveTarget(r.Context(), req.Path, auth.AccessWrite)
+	if err != nil {
+		return err
+	}
+	if t.isRoot() {
+		return opErrorf(http.StatusBadRequest, "cannot rename a mount root")
+	}
+	name, err := cleanFileName(req.Name)
+	if err != nil || name != req.Name {
+		return opErrorf(http.StatusBadRequest, "invalid name %q", req.Name)
+	}
+	// The new name may lie in a subtree with access rules of its own, and
+	// both names may have rules below them
+	newURL := path.Join(path.Dir(t.url), name)
+	if _, err := fs.resolveTarget(r.Context(), newURL, auth.AccessWrite); err != nil {
+		return err
+	}
+	if err := fs.checkSubtree(r.Context(), t.url, auth.AccessWrite); err != nil {
+		return err
+	}
+	if err := fs.checkSubtree(r.Context(), newURL, auth.AccessWrite); err != nil {
+		return err
+	}
+
+	dst := filepath.Join(filepath.Dir(t.fsPath), name)
+	if err := moveNoReplace(t.fsPath, dst); err != nil {
+		return err
+	}
+	log.Printf("Renamed %s to %s", t.url, name)
+	return nil
+}
+
+// opTransfer moves or copies entries into a destination directory, which
+// may be in another mount
+func (fs *FileServer) opTransfer(r *http.Request, req opRequest, copying bool) error {
+	dest, err := fs.resolveTarget(r.Context(), req.Dest, auth.AccessWrite)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(dest.fsPath); err != nil || !info.IsDir() {
+		return opErrorf(http.StatusNotFound, "%s is not a directory", dest.url)
+	}
+	if len(req.Paths) == 0 {
+		return opErrorf(http.StatusBadRequest, "no paths given")
+	}
+
+	for _, p := range req.Paths {
+		// Moving needs write access to the source; copying only read access
+		need := auth.AccessWrite
+		if copying {
+			need = auth.AccessRead
+		}
+		src, err := fs.resolveTarget(r.Context(), p, need)
+		if err != nil {
+			return err
+		}
+		if src.isRoot() {
+			return opErrorf(http.StatusBadRequest, "cannot move or copy a mount root")
+		}
+		dst := filepath.Join(dest.fsPath, filepath.Base(src.fsPath))
+		if within(dst, src.fsPath) {
+			return opErrorf(http.StatusBadRequest, "cannot place %s inside itself", src.url)
+		}
+		dstURL := path.Join(dest.url, path.Base(src.url))
+		if _, err := fs.resolveTarget(r.Context(), dstURL, auth.AccessWrite); err != nil {
+			return err
+		}
+		if err := fs.checkSubtree(r.Context(), dstURL, auth.AccessWrite); err != nil {
+			return err
+		}
+		if !copying {
+			if err := fs.checkSubtree(r.Context(), src.url, auth.AccessWrite); err != nil {
+				return err
+			}
+		}
+
+		if copying {
+			// Only what the user could download is copied
+			hide := fs.unreadable(r.Context(), src.dir)
+			err = copyNoReplace(src.fsPath, dst, func(rel string) bool {
+				rel = path.Join(src.rel, rel)
+				return isInternal(rel) || isExcluded(src.dir, rel) || hide(rel)
+			})
+		} else {
+			err = moveNoReplace(src.fsPath, dst)
+		}
+		if err != nil {
+			return err
+		}
+		verb := "Moved"
+		if copying {
+			verb = "Copied"
+		}
+		log.Printf("%s %s to %s", verb, src.url, dest.url)
+	}
+	return nil
+}
+
+func (fs *FileServer) opDelete(r *http.Request, req opRequest) error {
+	if len(req.Paths) == 0 {
+		return opErrorf(http.StatusBadRequest, "no paths given")
+	}
+	for _, p := range req.Paths {
+		t, err := fs.resolveTarget(r.Context(), p, auth.AccessWrite)
+		if err != nil {
+			return err
+		}
+		if t.isRoot() {
+			return opErrorf(http.StatusBadRequest, "cannot delete a mount root")
+		}
+		if err := fs.checkSubtree(r.Context(), t.url, auth.AccessWrite); err != nil {
+			return err
+		}
+		if hasTrash(t.dir) {
+			user, _ := currentUser(r)
+			if err := moveToTrash(t, user); err != nil {
+				return err
+			}
+			log.Printf("Moved %s to trash", t.url)
+			continue
+		}
+
+		if _, err := os.Lstat(t.fsPath); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(t.fsPath); err != nil {
+			return err
+		}
+		log.Printf("Deleted %s", t.url)
+	}
+	return nil
+}
+
+// opTrash restores items from a mount's trash or purges them for good
+func (fs *FileServer) opTrash(r *http.Request, req opRequest, restore bool) error {
+	t, err := fs.resolveTarget(r.Context(), req.Path, auth.AccessAdmin)
+	if err != nil {
+		return err
+	}
+	if !hasTrash(t.dir) {
+		return opErrorf(http.StatusNotFound, "/%s has no trash", t.dir.Name)
+	}
+	if len(req.IDs) == 0 {
+		return opErrorf(http.StatusBadRequest, "no items given")
+	}
+
+	for _, id := range req.IDs {
+		if !validTrashID(id) {
+			return opErrorf(http.StatusBadRequest, "invalid trash item %q", id)
+		}
+		if restore {
+			restored, err := restoreFromTrash(t.dir, id)
+			if err != nil {
+				return err
+			}
+			log.Printf("Restored %s from trash", restored)
+			continue
+		}
+		if err := purgeTrash(t.dir, id); err != nil {
+			return err
+		}
+		log.Printf("Purged %s from trash of /%s", id, t.dir.Name)
+	}
+	return nil
+}
+
+// within reports whether name is root or lies below it
+func within(name, root string) bool {
+	rel, err := filepath.Rel(root, name)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveNoReplace renames src to dst, copying across filesystems when a
+// rename is impossible. It fails if dst exists.
+func moveNoReplace(src, dst string) error {
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", errConflict, filepath.Base(dst))
+	}
+
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) ||
+		errors.Is(err, os.ErrExist) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	// Most likely a different filesystem: copy, then remove the original
+	if err := copyNoReplace(src, dst, nil); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyNoReplace copies a file, symlink or directory tree to dst, keeping
+// permissions and modification times, and leaving out the entries skip
+// reports by their slash-separated path relative to src. It fails if dst
+// exists.
+func copyNoReplace(src, dst string, skip func(rel string) bool) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", errConflict, filepath.Base(dst))
+	}
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if skip != nil && rel != "." && skip(filepath.ToSlash(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()|0o700); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := copyFile(p, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			// Devices, sockets and pipes are not copied
+			return nil
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// writeOpError answers a failed operation with a JSON error
+func writeOpError(w http.ResponseWriter, err error) {
+	status, msg := opStatus(err)
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// opStatus returns the status code and message a failed operation is
+// answered with
+func opStatus(err error) (status int, msg string) {
+	status, msg = http.StatusInternalServerError, "internal error"
+
+	var oe *opError
+	switch {
+	case errors.As(err, &oe):
+		status, msg = oe.status, oe.msg
+	case errors.Is(err, errConflict), errors.Is(err, os.ErrExist):
+		status, msg = http.StatusConflict, "destination already exists"
+	case errors.Is(err, os.ErrNotExist):
+		status, msg = http.StatusNotFound, "not found"
+	case errors.Is(err, os.ErrPermission):
+		status, msg = http.StatusForbidden, "permission denied"
+	default:
+		log.Printf("Error in file operation: %v", err)
+	}
+	return status, msg
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"fileserv/internal/models"
+	"fileserv/internal/preview"
+	"fileserv/internal/template"
+)
+
+// showPreview shows the file at fsPath on a page of its own, for ?view:
+// Markdown rendered, source code highlighted, tables as tables, media in a
+// player and anything else as a hex dump. Only the start of long files is
+// read.
+func (fs *FileServer) showPreview(w http.ResponseWriter, r *http.Request, dir models.Directory, fsPath, relPath string, info os.FileInfo) {
+	name := path.Base(relPath)
+	data := models.PreviewData{
+		File: newFileInfo(dir, relPath, info),
+		Kind: preview.Kind(name),
+	}
+	data.User, data.CanLogout = currentUser(r)
+
+	switch data.Kind {
+	case preview.KindImage, preview.KindVideo, preview.KindAudio:
+		// Shown by the browser from the file's URL
+	default:
+		src, err := readHead(fsPath, preview.MaxSize)
+		if err != nil {
+			log.Printf("Error reading %s for preview: %v", fsPath, err)
+			if errors.Is(err, os.ErrPermission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			} else {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+		data.Truncated, data.Limit = info.Size() > int64(len(src)), preview.MaxSize
+		if preview.IsBinary(src) {
+			data.Kind = preview.KindBinary
+			src = src[:min(len(src), preview.MaxHexSize)]
+			data.Truncated, data.Limit = info.Size() > int64(len(src)), preview.MaxHexSize
+		}
+
+		switch data.Kind {
+		case preview.KindBinary:
+			data.Hex = hex.Dump(src)
+		case preview.KindMarkdown:
+			if data.HTML, err = preview.Markdown(src, path.Dir(data.File.Path)); err != nil {
+				log.Printf("Error rendering %s: %v", fsPath, err)
+				data.Kind = preview.KindText
+			}
+		case preview.KindTable:
+			rows, more, err := preview.Table(name, src, preview.MaxRows)
+			if err == nil {
+				if data.Truncated && !more && len(rows) > 1 {
+					// The last row may have been cut short
+					rows = rows[:len(rows)-1]
+				}
+				data.Rows = rows
+				data.Truncated = data.Truncated || more
+				data.Limit = int64(len(rows))
+				break
+			}
+			// Not valid CSV after all
+			data.Kind = preview.KindText
+		}
+		if data.Kind == preview.KindText {
+			data.Language, data.Lines = preview.Highlight(name, src)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := template.RenderPreview(&flushWriter{w: w}, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// descriptionFile describes a directory above its listing. Like every
+// file fileserv keeps inside mounts, it is neither listed nor served.
+const descriptionFile = internalPrefix + "description"
+
+// readmeFiles are shown below listings, the first one a directory has
+var readmeFiles = []string{"README.md", "readme.md", "README.markdown", "README.txt", "readme.txt", "README"}
+
+// maxReadmeSize is how much of a README or description is shown
+const maxReadmeSize = 256 << 10
+
+// readmes returns the description and README of the directory at fsPath,
+// either of which may be nil
+func readmes(dir models.Directory, fsPath, relPath string) (description, readme *models.Readme) {
+	if dir.DisableReadme {
+		return nil, nil
+	}
+	base := path.Join("/"+dir.Name, relPath)
+	description = loadReadme(filepath.Join(fsPath, descriptionFile), descriptionFile, base, true)
+	for _, name := range readmeFiles {
+		if isExcluded(dir, path.Join(relPath, name)) {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(name))
+		if readme = loadReadme(filepath.Join(fsPath, name), name, base, ext == ".md" || ext == ".markdown"); readme != nil {
+			break
+		}
+	}
+	return description, readme
+}
+
+// loadReadme reads the README at fsPath, rendering Markdown with links
+// relative to base. It returns nil if there is no such file or it is not
+// text.
+func loadReadme(fsPath, name, base string, markdown bool) *models.Readme {
+	info, err := os.Stat(fsPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	src, err := readHead(fsPath, maxReadmeSize)
+	if err != nil {
+		log.Printf("Error reading %s: %v", fsPath, err)
+		return nil
+	}
+	if preview.IsBinary(src) {
+		return nil
+	}
+
+	readme := &models.Readme{Name: name, Truncated: info.Size() > int64(len(src))}
+	if markdown {
+		if readme.HTML, err = preview.Markdown(src, base); err == nil {
+			return readme
+		}
+		log.Printf("Error rendering %s: %v", fsPath, err)
+	}
+	readme.Text = string(src)
+	return readme
+}
+
+// readHead reads up to limit bytes from the start of the file at fsPath
+func readHead(fsPath string, limit int64) ([]byte, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, limit))
+}
@@ -0,0 +1,495 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/index"
+	"fileserv/internal/models"
+	"fileserv/internal/template"
+)
+
+// SearchPath is where file names are searched:
+//
+//	GET /_fileserv/search?q=report&mode=glob&in=/docs
+//
+// mode is substring (the default), prefix, glob or regex, all
+// case-insensitive. in limits the search to a directory and the mounts
+// nested inside it; without it every mount on the root page is searched.
+// Mounts with a file name index are searched in it rather than on disk.
+//
+// mode=content finds the files containing every word of q instead, in the
+// mounts whose contents are indexed.
+const SearchPath = "/_fileserv/search"
+
+// DefaultSearchLimit is how many results a search returns unless ?limit=
+// asks for another number, up to MaxSearchLimit
+const (
+	DefaultSearchLimit = 200
+	MaxSearchLimit     = 5000
+)
+
+// matchedLines is how many matching lines are shown for each file a
+// content search finds, and lineWidth how much of each
+const (
+	matchedLines = 5
+	lineWidth    = 240
+)
+
+// SearchTimeout bounds how long a search walks the mounts or reads matched
+// files. Whatever was found by then is returned, marked as incomplete.
+const SearchTimeout = 10 * time.Second
+
+// errSearchLimit stops the walk once enough results are found
+var errSearchLimit = errors.New("search limit reached")
+
+// searchQuery is a parsed search request
+type searchQuery struct {
+	models.SearchForm
+	Limit int
+	match func(name string) bool
+	// words are what a content search looks for
+	words []string
+}
+
+// apiSearch is the result of a search in the JSON API
+type apiSearch struct {
+	Query   string            `json:"query"`
+	Mode    string            `json:"mode"`
+	In      string            `json:"in,omitempty"`
+	Results []models.FileInfo `json:"results"`
+	// Matches holds the matching lines of each result of a content search
+	Matches   []models.ContentMatch `json:"matches,omitempty"`
+	Truncated bool                  `json:"truncated"`
+	TimedOut  bool                  `json:"timed_out"`
+}
+
+func parseSearch(r *http.Request) (searchQuery, error) {
+	q := r.URL.Query()
+	s := searchQuery{
+		SearchForm: models.SearchForm{
+			Query: strings.TrimSpace(q.Get("q")),
+			Mode:  q.Get("mode"),
+			In:    q.Get("in"),
+		},
+		Limit: DefaultSearchLimit,
+	}
+	if q.Has("limit") {
+		n, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || n < 1 || n > MaxSearchLimit {
+			return s, fmt.Errorf("invalid limit %q (want 1 to %d)", q.Get("limit"), MaxSearchLimit)
+		}
+		s.Limit = n
+	}
+
+	query := strings.ToLower(s.Query)
+	switch s.Mode {
+	case "", "substring":
+		s.Mode = "substring"
+		s.match = func(name string) bool {
+			return strings.Contains(strings.ToLower(name), query)
+		}
+	case "prefix":
+		s.match = func(name string) bool {
+			return strings.HasPrefix(strings.ToLower(name), query)
+		}
+	case "glob":
+		if _, err := path.Match(query, ""); err != nil {
+			return s, fmt.Errorf("invalid glob %q", s.Query)
+		}
+		s.match = func(name string) bool {
+			ok, _ := path.Match(query, strings.ToLower(name))
+			return ok
+		}
+	case "regex":
+		re, err := regexp.Compile("(?i)" + s.Query)
+		if err != nil {
+			return s, fmt.Errorf("invalid regex %q", s.Query)
+		}
+		s.match = re.MatchString
+	case "content":
+		s.words = index.Words(s.Query)
+		if len(s.words) == 0 && s.Query != "" {
+			return s, fmt.Errorf("search for words of at least %d letters", index.MinWordLength)
+		}
+	default:
+		return s, fmt.Errorf("unknown mode %q (want substring, prefix, glob, regex or content)", s.Mode)
+	}
+	return s, nil
+}
+
+// searchRoots returns the directories a search walks: the one named by in
+// and the mounts nested inside it, or every mount on the root page. Mounts
+// the user cannot access or whose listing is disabled are left out.
+func (fs *FileServer) searchRoots(ctx context.Context, in string) ([]target, error) {
+	prefix := "/"
+	var roots []target
+
+	if in = path.Clean("/" + in); in != "/" {
+		t, err := fs.resolveTarget(ctx, in, auth.AccessRead)
+		if err != nil {
+			return nil, err
+		}
+		if t.dir.DisableListing {
+			return nil, opErrorf(http.StatusForbidden, "listing /%s is disabled", t.dir.Name)
+		}
+		if info, err := os.Stat(t.fsPath); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, opErrorf(http.StatusBadRequest, "%s is not a directory", t.url)
+		}
+		roots = append(roots, t)
+		prefix = t.url + "/"
+	}
+
+	for _, dir := range fs.directories {
+		if dir.Hidden || dir.DisableListing || fs.access(ctx, dir, "/"+dir.Name) < auth.AccessRead || !strings.HasPrefix("/"+dir.Name, prefix) {
+			continue
+		}
+		roots = append(roots, target{dir: dir, rel: "/", fsPath: dir.Path, url: "/" + dir.Name})
+	}
+	return roots, nil
+}
+
+// unsearchable reports whether the entry at rel in dir is kept out of
+// search results: internal and excluded files, and directories hidden by a
+// mount nested at their path, which is searched on its own
+func (fs *FileServer) unsearchable(dir models.Directory, rel string, isDir bool) bool {
+	if strings.HasPrefix(path.Base(rel), internalPrefix) || isExcluded(dir, rel) {
+		return true
+	}
+	if isDir {
+		if d, _, _ := fs.resolve(path.Join("/"+dir.Name, rel)); d.Name != dir.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// search looks for names matching the query in roots, using their mount's
+// index where there is one and walking the disk otherwise. Paths the user
+// of ctx may not read are left out. It stops at the limit, and when ctx is
+// done, returning what it found so far along with the context's error.
+func (fs *FileServer) search(ctx context.Context, roots []target, q searchQuery) ([]models.FileInfo, bool, error) {
+	var results []models.FileInfo
+	for _, root := range roots {
+		hide := fs.unreadable(ctx, root.dir)
+		if ix := fs.indexes[root.dir.Name]; ix != nil && ix.Ready() {
+			var rels []string
+			wanted := q.Limit - len(results)
+			if q.Mode == "prefix" {
+				rels = ix.Prefix(root.rel, q.Query, wanted)
+			} else {
+				rels = ix.Search(root.rel, wanted, q.match)
+			}
+			for _, rel := range rels {
+				if hide(rel) {
+					continue
+				}
+				// The index may be behind the disk
+				if info, err := statFollow(filepath.Join(root.dir.Path, filepath.FromSlash(rel))); err == nil {
+					results = append(results, newFileInfo(root.dir, rel, info))
+				}
+			}
+			if len(rels) == wanted {
+				return results, true, nil
+			}
+			continue
+		}
+
+		err := filepath.WalkDir(root.fsPath, func(p string, d os.DirEntry, err error) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err != nil {
+				// Unreadable directories are left out of the results
+				if p == root.fsPath {
+					log.Printf("Skipping %s in search: %v", p, err)
+				}
+				return nil
+			}
+			if p == root.fsPath {
+				return nil
+			}
+
+			rel := path.Join(root.rel, filepath.ToSlash(strings.TrimPrefix(p, root.fsPath)))
+			if fs.unsearchable(root.dir, rel, d.IsDir()) || hide(rel) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !q.match(d.Name()) {
+				return nil
+			}
+
+			info, err := statFollow(p)
+			if err != nil {
+				return nil
+			}
+			results = append(results, newFileInfo(root.dir, rel, info))
+			if len(results) >= q.Limit {
+				return errSearchLimit
+			}
+			return nil
+		})
+		if errors.Is(err, errSearchLimit) {
+			return results, true, nil
+		}
+		if err != nil {
+			return results, false, err
+		}
+	}
+	return results, false, nil
+}
+
+// searchContents finds the files in roots containing every word of the
+// query, with the lines that contain them. Mounts whose contents are not
+// indexed, and paths the user of ctx may not read, are left out. When ctx
+// is done, the matches so far are returned with its error.
+func (fs *FileServer) searchContents(ctx context.Context, roots []target, q searchQuery) ([]models.ContentMatch, bool, error) {
+	var matches []models.ContentMatch
+	for _, root := range roots {
+		ix := fs.indexes[root.dir.Name]
+		if ix == nil || !root.dir.FullText {
+			continue
+		}
+		hide := fs.unreadable(ctx, root.dir)
+		wanted := q.Limit - len(matches)
+		rels := ix.TextSearch(root.rel, q.words, wanted)
+		for _, rel := range rels {
+			if err := ctx.Err(); err != nil {
+				return matches, false, err
+			}
+			if hide(rel) {
+				continue
+			}
+			fsPath := filepath.Join(root.dir.Path, filepath.FromSlash(rel))
+			info, err := os.Stat(fsPath)
+			if err != nil {
+				continue
+			}
+			m := models.ContentMatch{File: newFileInfo(root.dir, rel, info)}
+			m.Path = m.File.Path
+			if f, err := os.Open(fsPath); err == nil {
+				for _, l := range index.MatchLines(f, q.words, matchedLines) {
+					m.Lines = append(m.Lines, matchedLine(l))
+				}
+				f.Close()
+			}
+			// The file may have changed since it was indexed
+			if len(m.Lines) > 0 {
+				matches = append(matches, m)
+			}
+		}
+		if len(rels) == wanted {
+			return matches, true, nil
+		}
+	}
+	return matches, false, nil
+}
+
+// matchedLine cuts long lines down to lineWidth around the first match,
+// marking the cuts with ellipses, and splits them for highlighting
+func matchedLine(l index.Line) models.MatchedLine {
+	start, end := 0, len(l.Text)
+	if end > lineWidth {
+		start = max(0, l.Matches[0][0]-lineWidth/3)
+		for start > 0 && !utf8.RuneStart(l.Text[start]) {
+			start--
+		}
+		end = min(len(l.Text), start+lineWidth)
+		for end < len(l.Text) && !utf8.RuneStart(l.Text[end]) {
+			end--
+		}
+	}
+
+	ml := models.MatchedLine{Number: l.Number, Text: l.Text[start:end]}
+	if start > 0 {
+		ml.Parts = append(ml.Parts, models.TextPart{Text: "…"})
+	}
+	pos := start
+	for _, m := range l.Matches {
+		if m[0] < pos || m[1] > end {
+			continue
+		}
+		if m[0] > pos {
+			ml.Parts = append(ml.Parts, models.TextPart{Text: l.Text[pos:m[0]]})
+		}
+		ml.Parts = append(ml.Parts, models.TextPart{Text: l.Text[m[0]:m[1]], Match: true})
+		pos = m[1]
+	}
+	if pos < end {
+		ml.Parts = append(ml.Parts, models.TextPart{Text: l.Text[pos:end]})
+	}
+	if end < len(l.Text) {
+		ml.Parts = append(ml.Parts, models.TextPart{Text: "…"})
+	}
+	return ml
+}
+
+// searchForm returns the search box for a page about the directory in, or
+// about everything when it is empty
+func (fs *FileServer) searchForm(in string) models.SearchForm {
+	form := models.SearchForm{In: in}
+	for _, dir := range fs.directories {
+		if dir.FullText {
+			form.FullText = true
+		}
+	}
+	return form
+}
+
+// indexStatus describes the file name indexes, in mount order
+func (fs *FileServer) indexStatus() []models.IndexStatus {
+	statuses := []models.IndexStatus{}
+	for _, dir := range fs.directories {
+		ix := fs.indexes[dir.Name]
+		if ix == nil {
+			continue
+		}
+		st := ix.Status()
+		statuses = append(statuses, models.IndexStatus{
+			Mount:     "/" + dir.Name,
+			Entries:   st.Entries,
+			LastScan:  st.LastScan,
+			Scanning:  st.Scanning,
+			Watching:  st.Watching,
+			TextFiles: st.TextFiles,
+		})
+	}
+	return statuses
+}
+
+// statFollow describes the file at p, or what it points to for symbolic
+// links that are not broken
+func statFollow(p string) (os.FileInfo, error) {
+	if info, err := os.Stat(p); err == nil {
+		return info, nil
+	}
+	return os.Lstat(p)
+}
+
+// HandleSearch searches file names, or contents, and shows the results
+// like a listing, or as JSON for clients that accept it
+func (fs *FileServer) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if wantsJSON(r) {
+		fs.apiSearch(w, r)
+		return
+	}
+
+	q, err := parseSearch(r)
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	data := models.SearchData{SearchForm: q.SearchForm, Limit: q.Limit}
+	data.FullText = fs.searchForm("").FullText
+	data.User, data.CanLogout = currentUser(r)
+
+	if q.Query != "" {
+		roots, err := fs.searchRoots(r.Context(), q.In)
+		if err != nil {
+			status, _ := opStatus(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		data.Searched = true
+		ctx, cancel := context.WithTimeout(r.Context(), SearchTimeout)
+		defer cancel()
+		if q.Mode == "content" {
+			data.Matches, data.Truncated, err = fs.searchContents(ctx, roots, q)
+			for _, m := range data.Matches {
+				data.Results = append(data.Results, m.File)
+			}
+		} else {
+			data.Results, data.Truncated, err = fs.search(ctx, roots, q)
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				// The client has gone
+				return
+			}
+			data.TimedOut = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := template.RenderSearch(&flushWriter{w: w}, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+func (fs *FileServer) apiSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearch(r)
+	if err != nil {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "%v", err))
+		return
+	}
+	if q.Query == "" {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "missing query"))
+		return
+	}
+	roots, err := fs.searchRoots(r.Context(), q.In)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), SearchTimeout)
+	defer cancel()
+	if q.Mode == "content" {
+		matches, truncated, err := fs.searchContents(ctx, roots, q)
+		if err != nil && r.Context().Err() != nil {
+			return
+		}
+		results := []models.FileInfo{}
+		for _, m := range matches {
+			results = append(results, m.File)
+		}
+		writeJSON(w, http.StatusOK, apiSearch{
+			Query:     q.Query,
+			Mode:      q.Mode,
+			In:        q.In,
+			Results:   results,
+			Matches:   matches,
+			Truncated: truncated || err != nil,
+			TimedOut:  err != nil,
+		})
+		return
+	}
+
+	results, truncated, err := fs.search(ctx, roots, q)
+	if err != nil && r.Context().Err() != nil {
+		return
+	}
+	if results == nil {
+		results = []models.FileInfo{}
+	}
+	writeJSON(w, http.StatusOK, apiSearch{
+		Query:     q.Query,
+		Mode:      q.Mode,
+		In:        q.In,
+		Results:   results,
+		Truncated: truncated || err != nil,
+		TimedOut:  err != nil,
+	})
+}
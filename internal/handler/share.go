@@ -0,0 +1,721 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/config"
+	"fileserv/internal/models"
+	"fileserv/internal/template"
+)
+
+// SharePath serves share links, at SharePath + token. It is not behind the
+// guard: the signed token is all a visitor needs.
+const SharePath = "/_fileserv/s/"
+
+// SharesPath manages share links:
+//
+//	GET    /_fileserv/shares       the active shares of the user, or everyone's for admins
+//	POST   /_fileserv/shares       creates a share from a JSON shareRequest
+//	DELETE /_fileserv/shares/<id>  revokes a share
+const SharesPath = "/_fileserv/shares"
+
+// DefaultShareLifetime is how long share links last when no expiry is
+// asked for, and MaxShareLifetime the longest they may last
+const (
+	DefaultShareLifetime = 7 * 24 * time.Hour
+	MaxShareLifetime     = 365 * 24 * time.Hour
+)
+
+// shareCookie remembers that a visitor entered a share's password. It is
+// scoped to the share's URL.
+const shareCookie = "fileserv_share"
+
+// shareRequest is the JSON body that creates a share
+type shareRequest struct {
+	// Path is the URL path of the file or directory to share
+	Path string `json:"path"`
+	// ExpiresIn is how long the link lasts, such as "24h" or "7d"
+	ExpiresIn    string `json:"expires_in"`
+	Password     string `json:"password"`
+	MaxDownloads int    `json:"max_downloads"`
+	// DropBox asks for an upload-only link to a directory, taking files
+	// of up to MaxSize, such as "100MB", and of the given Types
+	DropBox bool     `json:"drop_box"`
+	MaxSize string   `json:"max_size"`
+	Types   []string `json:"types"`
+}
+
+// share is a share link as it is stored
+type share struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	IsDir        bool      `json:"is_dir"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Expires      time.Time `json:"expires"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+	Downloads    int       `json:"downloads"`
+	// DropBox links take uploads to the directory instead of showing it
+	DropBox bool     `json:"drop_box,omitempty"`
+	MaxSize int64    `json:"max_size,omitempty"`
+	Types   []string `json:"types,omitempty"`
+}
+
+var (
+	errShareNotFound = opErrorf(http.StatusNotFound, "share link not found")
+	errShareExpired  = opErrorf(http.StatusGone, "share link has expired")
+	errShareUsedUp   = opErrorf(http.StatusGone, "share link has reached its download limit")
+)
+
+// shareStore keeps the share links that have been created. Links carry an
+// HMAC of the share made with the store's key, so they cannot be guessed
+// or pointed elsewhere.
+type shareStore struct {
+	key []byte
+	// file is where shares are saved; empty keeps them in memory only
+	file string
+
+	mu     sync.Mutex
+	shares map[string]*share
+}
+
+// openShareStore loads the shares saved in dir, creating the key links are
+// signed with on first use. An empty dir keeps shares in memory with a new
+// key, so links stop working when the server restarts.
+func openShareStore(dir string) (*shareStore, error) {
+	s := &shareStore{shares: make(map[string]*share)}
+	if dir == "" {
+		s.key = make([]byte, 32)
+		_, err := rand.Read(s.key)
+		return s, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	key, err := loadShareKey(filepath.Join(dir, "share.key"))
+	if err != nil {
+		return nil, err
+	}
+	s.key = key
+	s.file = filepath.Join(dir, "shares.json")
+
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*share
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.file, err)
+	}
+	for _, sh := range list {
+		s.shares[sh.ID] = sh
+	}
+	return s, nil
+}
+
+// loadShareKey reads the signing key, generating it if there is none yet
+func loadShareKey(name string) ([]byte, error) {
+	key, err := os.ReadFile(name)
+	if err == nil {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%s: key is too short", name)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(name, key, 0o600)
+}
+
+// save writes the shares to the store's file. The caller holds s.mu.
+func (s *shareStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	list := make([]*share, 0, len(s.shares))
+	for _, sh := range s.shares {
+		list = append(list, sh)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// prune forgets expired shares, reporting whether there were any. The
+// caller holds s.mu.
+func (s *shareStore) prune(now time.Time) bool {
+	pruned := false
+	for id, sh := range s.shares {
+		if now.After(sh.Expires) {
+			delete(s.shares, id)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// add stores a new share, giving it an ID
+func (s *shareStore) add(sh *share) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	sh.ID = hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	s.shares[sh.ID] = sh
+	return s.save()
+}
+
+// token returns the token of a share's link: its ID, its expiry and a
+// signature over both and the shared path
+func (s *shareStore) token(sh *share) string {
+	exp := strconv.FormatInt(sh.Expires.Unix(), 36)
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("share\x00" + sh.ID + "\x00" + exp + "\x00" + sh.Path))
+	return sh.ID + "." + exp + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the share a link's token names, if the signature is right
+// and the share is still usable
+func (s *shareStore) verify(token string) (share, error) {
+	id, _, _ := strings.Cut(token, ".")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok || !hmac.Equal([]byte(token), []byte(s.token(sh))) {
+		return share{}, errShareNotFound
+	}
+	if time.Now().After(sh.Expires) {
+		return share{}, errShareExpired
+	}
+	if sh.MaxDownloads > 0 && sh.Downloads >= sh.MaxDownloads {
+		return share{}, errShareUsedUp
+	}
+	return *sh, nil
+}
+
+// take counts a download through a share, failing when the share has been
+// revoked or used up since it was verified
+func (s *shareStore) take(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok {
+		return errShareNotFound
+	}
+	if sh.MaxDownloads > 0 && sh.Downloads >= sh.MaxDownloads {
+		return errShareUsedUp
+	}
+	sh.Downloads++
+	if err := s.save(); err != nil {
+		log.Printf("Error saving share links: %v", err)
+	}
+	return nil
+}
+
+// list returns the active shares created by owner, or all of them
+func (s *shareStore) list(owner string, all bool) []share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.prune(time.Now()) {
+		if err := s.save(); err != nil {
+			log.Printf("Error saving share links: %v", err)
+		}
+	}
+
+	var list []share
+	for _, sh := range s.shares {
+		if all || sh.CreatedBy == owner {
+			list = append(list, *sh)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// revoke deletes a share created by owner, or anyone's if all is set
+func (s *shareStore) revoke(id, owner string, all bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shares[id]
+	if !ok || !all && sh.CreatedBy != owner {
+		return errShareNotFound
+	}
+	delete(s.shares, id)
+	return s.save()
+}
+
+// unlockValue is the value of the cookie showing a share's password was
+// entered. It changes with the password.
+func (s *shareStore) unlockValue(sh share) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte("unlock\x00" + sh.ID + "\x00" + sh.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unlocked reports whether the request carries the cookie of a share whose
+// password was entered
+func (s *shareStore) unlocked(r *http.Request, sh share) bool {
+	c, err := r.Cookie(shareCookie)
+	return err == nil && hmac.Equal([]byte(c.Value), []byte(s.unlockValue(sh)))
+}
+
+// canShare reports whether the request's user may create share links
+func (fs *FileServer) canShare(r *http.Request) bool {
+	return auth.UserFrom(r.Context()) != nil || fs.opts.AnonymousShares
+}
+
+// canCreateDropBox reports whether the request's user may create drop
+// boxes at urlPath: admins, users the ACL grants admin access to the path,
+// or anyone on servers without accounts
+func (fs *FileServer) canCreateDropBox(r *http.Request, urlPath string) bool {
+	u := auth.UserFrom(r.Context())
+	if fs.opts.Admins.Contains(u) || fs.opts.AnonymousShares {
+		return true
+	}
+	access, ok := fs.opts.ACL.Access(u, urlPath)
+	return ok && access >= auth.AccessAdmin
+}
+
+// outsideShare returns a test for the paths in dir, relative to its root,
+// that a share of sharedPath does not reach: subtrees the ACL has rules of
+// their own for, which the user who shared the path may not have been
+// allowed into
+func (fs *FileServer) outsideShare(sharedPath string, dir models.Directory) func(relPath string) bool {
+	scope, _ := fs.opts.ACL.Scope(sharedPath)
+	return func(relPath string) bool {
+		s, _ := fs.opts.ACL.Scope(path.Join("/"+dir.Name, relPath))
+		return s != scope
+	}
+}
+
+// shareOwner returns whose shares the request sees, and whether it sees
+// everyone's
+func (fs *FileServer) shareOwner(r *http.Request) (string, bool) {
+	u := auth.UserFrom(r.Context())
+	if u == nil {
+		return "", false
+	}
+	return u.Name, fs.opts.Admins.Contains(u)
+}
+
+// shareInfo describes a share to the user who created it
+func (fs *FileServer) shareInfo(sh share) models.Share {
+	info := models.Share{
+		ID:           sh.ID,
+		URL:          SharePath + fs.shares.token(&sh),
+		Path:         sh.Path,
+		IsDir:        sh.IsDir,
+		Type:         "file",
+		CreatedBy:    sh.CreatedBy,
+		CreatedAt:    sh.CreatedAt,
+		Expires:      sh.Expires,
+		Password:     sh.PasswordHash != "",
+		MaxDownloads: sh.MaxDownloads,
+		Downloads:    sh.Downloads,
+		DropBox:      sh.DropBox,
+		MaxSize:      sh.MaxSize,
+		Types:        sh.Types,
+	}
+	if sh.IsDir {
+		info.Type = "directory"
+	}
+	return info
+}
+
+// HandleShares lists, creates and revokes share links
+func (fs *FileServer) HandleShares(w http.ResponseWriter, r *http.Request) {
+	if !fs.canShare(r) {
+		http.Error(w, "Forbidden: sign in to share files", http.StatusForbidden)
+		return
+	}
+
+	id, hasID := strings.CutPrefix(r.URL.Path, SharesPath+"/")
+	switch {
+	case hasID && r.Method == http.MethodDelete:
+		fs.revokeShare(w, r, id)
+	case hasID:
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	case r.URL.Path != SharesPath:
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		fs.listShares(w, r)
+	case r.Method == http.MethodPost:
+		fs.createShare(w, r)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (fs *FileServer) listShares(w http.ResponseWriter, r *http.Request) {
+	owner, all := fs.shareOwner(r)
+	shares := []models.Share{}
+	for _, sh := range fs.shares.list(owner, all) {
+		shares = append(shares, fs.shareInfo(sh))
+	}
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, shares)
+		return
+	}
+
+	data := models.SharesData{Shares: shares, All: all}
+	data.User, data.CanLogout = currentUser(r)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := template.RenderShares(w, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+func (fs *FileServer) createShare(w http.ResponseWriter, r *http.Request) {
+	// Requiring JSON keeps plain cross-site forms from reaching here
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "Unsupported Media Type: expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req shareRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeOpError(w, opErrorf(http.StatusBadRequest, "invalid request: %v", err))
+		return
+	}
+
+	sh, err := fs.newShare(r, req)
+	if err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if err := fs.shares.add(sh); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	if sh.DropBox {
+		log.Printf("Opened a drop box for %s until %s", sh.Path, sh.Expires.Format(time.RFC3339))
+	} else {
+		log.Printf("Shared %s until %s", sh.Path, sh.Expires.Format(time.RFC3339))
+	}
+	writeJSON(w, http.StatusCreated, fs.shareInfo(*sh))
+}
+
+// newShare checks a share request and returns the share it asks for
+func (fs *FileServer) newShare(r *http.Request, req shareRequest) (*share, error) {
+	need := auth.AccessRead
+	if req.DropBox {
+		need = auth.AccessWrite
+	}
+	t, err := fs.resolveTarget(r.Context(), req.Path, need)
+	if err != nil {
+		return nil, err
+	}
+	if req.DropBox && !fs.canCreateDropBox(r, t.url) {
+		return nil, opErrorf(http.StatusForbidden, "only admins can create drop boxes")
+	}
+	info, err := os.Stat(t.fsPath)
+	if err != nil {
+		return nil, err
+	}
+	var maxSize int64
+	switch {
+	case req.DropBox:
+		if !info.IsDir() {
+			return nil, opErrorf(http.StatusBadRequest, "drop boxes take uploads to a directory")
+		}
+		if req.MaxDownloads != 0 {
+			return nil, opErrorf(http.StatusBadRequest, "drop boxes have no downloads to limit")
+		}
+		if req.MaxSize != "" {
+			if maxSize, err = config.ParseSize(req.MaxSize); err != nil {
+				return nil, opErrorf(http.StatusBadRequest, "%v", err)
+			}
+		}
+		for _, t := range req.Types {
+			if !strings.HasPrefix(t, ".") && !strings.Contains(t, "/") {
+				return nil, opErrorf(http.StatusBadRequest, "invalid type %q (want an extension such as .pdf or a MIME type such as image/*)", t)
+			}
+		}
+	case req.MaxSize != "" || len(req.Types) > 0:
+		return nil, opErrorf(http.StatusBadRequest, "only drop boxes limit the size and type of uploads")
+	case info.IsDir() && t.dir.DisableListing:
+		return nil, opErrorf(http.StatusForbidden, "directories in /%s cannot be listed", t.dir.Name)
+	}
+
+	lifetime := DefaultShareLifetime
+	if req.ExpiresIn != "" {
+		if lifetime, err = config.ParseDuration(req.ExpiresIn); err != nil {
+			return nil, opErrorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	if lifetime <= 0 || lifetime > MaxShareLifetime {
+		return nil, opErrorf(http.StatusBadRequest, "share links must expire within %d days", MaxShareLifetime/(24*time.Hour))
+	}
+	if req.MaxDownloads < 0 {
+		return nil, opErrorf(http.StatusBadRequest, "max_downloads must not be negative")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sh := &share{
+		Path:         t.url,
+		IsDir:        info.IsDir(),
+		CreatedAt:    now,
+		Expires:      now.Add(lifetime),
+		MaxDownloads: req.MaxDownloads,
+		DropBox:      req.DropBox,
+		MaxSize:      maxSize,
+		Types:        req.Types,
+	}
+	sh.CreatedBy, _ = currentUser(r)
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, opErrorf(http.StatusBadRequest, "invalid password: %v", err)
+		}
+		sh.PasswordHash = string(hash)
+	}
+	return sh, nil
+}
+
+func (fs *FileServer) revokeShare(w http.ResponseWriter, r *http.Request, id string) {
+	owner, all := fs.shareOwner(r)
+	if err := fs.shares.revoke(id, owner, all); err != nil {
+		writeOpError(w, err)
+		return
+	}
+	log.Printf("Revoked share link %s", id)
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// HandleShare serves what a share link gives access to. The token is
+// verified before the shared path is resolved, so visitors without a valid
+// link learn nothing about the mounts.
+func (fs *FileServer) HandleShare(w http.ResponseWriter, r *http.Request) {
+	token, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, SharePath), "/")
+	sh, err := fs.shares.verify(token)
+	if err != nil {
+		status, msg := opStatus(err)
+		http.Error(w, msg, status)
+		return
+	}
+	// Keep the token out of the Referer of links followed from the page
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	base := SharePath + token
+	if sh.PasswordHash != "" && !fs.shares.unlocked(r, sh) {
+		fs.sharePassword(w, r, sh, base)
+		return
+	}
+	if sh.DropBox {
+		fs.serveDropBox(w, r, sh, base, sub)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Paths below a shared directory stay inside the mount it is in
+	sub = path.Clean("/" + sub)
+	mount, _, ok := fs.resolve(sh.Path)
+	dir, rel, ok2 := fs.resolve(path.Join(sh.Path, sub))
+	hide := fs.outsideShare(sh.Path, dir)
+	if !ok || !ok2 || dir.Name != mount.Name || !sh.IsDir && sub != "/" ||
+		isInternal(rel) || isExcluded(dir, rel) || hide(rel) {
+		http.NotFound(w, r)
+		return
+	}
+	if !reachable(r.Context(), dir) {
+		http.Error(w, "Forbidden: the shared files are not available from your network", http.StatusForbidden)
+		return
+	}
+	fsPath := filepath.Join(dir.Path, filepath.FromSlash(rel))
+	info, err := os.Stat(fsPath)
+	if err != nil || info.IsDir() && !sh.IsDir {
+		http.NotFound(w, r)
+		return
+	}
+
+	if info.IsDir() {
+		if dir.DisableListing {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Query().Has("archive") {
+			if err := fs.shares.take(sh.ID); err != nil {
+				status, msg := opStatus(err)
+				http.Error(w, msg, status)
+				return
+			}
+			fs.serveArchive(fs.opts.Throttle.Writer(w, r, dir.Name), r, dir, fsPath, rel, hide)
+			return
+		}
+		fs.showSharedDirectory(w, r, sh, base, sub, dir, fsPath, rel, hide)
+		return
+	}
+
+	q := r.URL.Query()
+	if !sh.IsDir && !q.Has("download") {
+		data := newShareData(sh)
+		data.File = newFileInfo(dir, rel, info)
+		data.DownloadURL = base + "?download"
+		renderShare(w, http.StatusOK, data)
+		return
+	}
+	sandboxUploads(w, dir)
+	fs.serveShared(fs.opts.Throttle.Writer(w, r, dir.Name), r, sh, fsPath, info)
+}
+
+// serveShared sends a file through a share, counting the download.
+// Every GET counts, including those for part of the file, since a Range
+// can ask for nearly all of it.
+func (fs *FileServer) serveShared(w http.ResponseWriter, r *http.Request, sh share, fsPath string, info os.FileInfo) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	if r.Method == http.MethodGet {
+		if err := fs.shares.take(sh.ID); err != nil {
+			status, msg := opStatus(err)
+			http.Error(w, msg, status)
+			return
+		}
+	}
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// showSharedDirectory lists a directory inside a shared one, with links
+// that stay inside the share, leaving out what hide reports
+func (fs *FileServer) showSharedDirectory(w http.ResponseWriter, r *http.Request, sh share, base, sub string, dir models.Directory, fsPath, rel string, hide func(relPath string) bool) {
+	view, err := parseListingView(w, r)
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	files, total, err := readDirectory(dir, fsPath, rel, view, hide)
+	if err != nil {
+		log.Printf("Error reading directory %s: %v", fsPath, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for i := range files {
+		p := path.Join(base, sub, files[i].Name)
+		files[i].Path = p
+		files[i].URL = (&url.URL{Path: p}).EscapedPath()
+		files[i].Thumbnail = ""
+	}
+
+	data := newShareData(sh)
+	data.CurrentPath = path.Join(data.Name, sub)
+	data.Files = files
+	data.SortLinks = view.links()
+	data.Pagination = view.pagination(total)
+	if sub != "/" {
+		data.ParentURL = (&url.URL{Path: path.Join(base, path.Dir(sub))}).EscapedPath()
+	}
+	renderShare(w, http.StatusOK, data)
+}
+
+// sharePassword asks for a share's password, and sets the cookie that
+// lets the visitor in once it is entered correctly
+func (fs *FileServer) sharePassword(w http.ResponseWriter, r *http.Request, sh share, base string) {
+	data := newShareData(sh)
+	data.NeedPassword = true
+
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		password := r.PostFormValue("password")
+		if bcrypt.CompareHashAndPassword([]byte(sh.PasswordHash), []byte(password)) == nil {
+			http.SetCookie(w, &http.Cookie{
+				Name:     shareCookie,
+				Value:    fs.shares.unlockValue(sh),
+				Path:     base,
+				Expires:  sh.Expires,
+				Secure:   r.TLS != nil,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		data.Error = "Wrong password"
+		renderShare(w, http.StatusUnauthorized, data)
+		return
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderShare(w, http.StatusOK, data)
+}
+
+// newShareData returns the page data every share page starts from
+func newShareData(sh share) models.ShareData {
+	return models.ShareData{
+		Name:    path.Base(sh.Path),
+		IsDir:   sh.IsDir,
+		Expires: sh.Expires,
+		DropBox: sh.DropBox,
+	}
+}
+
+func renderShare(w http.ResponseWriter, status int, data models.ShareData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := template.RenderShare(&flushWriter{w: w}, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fileserv/internal/models"
+)
+
+// sortCookie remembers the last sort order a browser asked for, and
+// layoutCookie whether it last asked for a list or a grid
+const (
+	sortCookie   = "fileserv_sort"
+	layoutCookie = "fileserv_layout"
+)
+
+// sortKeys are the orders a listing can be sorted in, with their labels
+var sortKeys = []struct{ key, label string }{
+	{"name", "Name"},
+	{"type", "Type"},
+	{"mtime", "Modified"},
+	{"size", "Size"},
+}
+
+// DefaultPageSize is how many entries a listing page shows unless ?limit=
+// asks for another number, up to MaxPageSize
+const (
+	DefaultPageSize = 1000
+	MaxPageSize     = 10000
+)
+
+// listingView is how a listing is sorted, filtered, paged and laid out
+type listingView struct {
+	Sort   string
+	Desc   bool
+	Filter string
+	// Page counts from 1
+	Page  int
+	Limit int
+	// Grid shows the entries as a gallery of thumbnails
+	Grid bool
+}
+
+// parseListingView reads ?sort=, ?order=, ?filter=, ?page=, ?limit= and
+// ?layout=. Without ?sort= or ?layout= the order and layout remembered in
+// cookies are used; explicit ones are remembered for next time.
+func parseListingView(w http.ResponseWriter, r *http.Request) (listingView, error) {
+	v := listingView{Sort: "name", Page: 1, Limit: DefaultPageSize}
+	q := r.URL.Query()
+
+	if q.Has("sort") {
+		if !validSortKey(q.Get("sort")) {
+			return v, fmt.Errorf("unknown sort %q (want name, size, mtime or type)", q.Get("sort"))
+		}
+		v.Sort = q.Get("sort")
+		switch q.Get("order") {
+		case "", "asc":
+		case "desc":
+			v.Desc = true
+		default:
+			return v, fmt.Errorf("unknown order %q (want asc or desc)", q.Get("order"))
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sortCookie,
+			Value:    v.Sort + "." + orderName(v.Desc),
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+	} else if c, err := r.Cookie(sortCookie); err == nil {
+		key, order, _ := strings.Cut(c.Value, ".")
+		if validSortKey(key) {
+			v.Sort, v.Desc = key, order == "desc"
+		}
+	}
+
+	switch layout := q.Get("layout"); layout {
+	case "list", "grid":
+		v.Grid = layout == "grid"
+		http.SetCookie(w, &http.Cookie{
+			Name:     layoutCookie,
+			Value:    layout,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+	case "":
+		if c, err := r.Cookie(layoutCookie); err == nil {
+			v.Grid = c.Value == "grid"
+		}
+	default:
+		return v, fmt.Errorf("unknown layout %q (want list or grid)", layout)
+	}
+
+	v.Filter = strings.TrimSpace(q.Get("filter"))
+	if _, err := path.Match(filterPattern(v.Filter), ""); err != nil {
+		return v, fmt.Errorf("invalid filter %q", v.Filter)
+	}
+
+	if q.Has("page") {
+		n, err := strconv.Atoi(q.Get("page"))
+		if err != nil || n < 1 {
+			return v, fmt.Errorf("invalid page %q", q.Get("page"))
+		}
+		v.Page = n
+	}
+	if q.Has("limit") {
+		n, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || n < 1 || n > MaxPageSize {
+			return v, fmt.Errorf("invalid limit %q (want 1 to %d)", q.Get("limit"), MaxPageSize)
+		}
+		v.Limit = n
+	}
+	return v, nil
+}
+
+func validSortKey(key string) bool {
+	for _, k := range sortKeys {
+		if k.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPattern turns a filter into a case-insensitive glob; a filter
+// without wildcards matches names containing it
+func filterPattern(filter string) string {
+	filter = strings.ToLower(filter)
+	if !strings.ContainsAny(filter, "*?[") {
+		filter = "*" + filter + "*"
+	}
+	return filter
+}
+
+// match reports whether a name passes the filter
+func (v listingView) match(name string) bool {
+	if v.Filter == "" {
+		return true
+	}
+	ok, _ := path.Match(filterPattern(v.Filter), strings.ToLower(name))
+	return ok
+}
+
+// sortEntries sorts entries, directories first. Sorting by name or type
+// needs nothing but the names; sorting by size or time stats every entry,
+// leaving out those that have disappeared since the directory was read.
+func (v listingView) sortEntries(entries []dirEntry) []dirEntry {
+	if v.Sort == "size" || v.Sort == "mtime" {
+		kept := entries[:0]
+		for _, e := range entries {
+			if _, err := e.stat(); err == nil {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if a.isDir != b.isDir {
+			return a.isDir
+		}
+		if v.Desc {
+			a, b = b, a
+		}
+		switch v.Sort {
+		case "size":
+			// Directories are listed without a size
+			if !a.isDir && a.info.Size() != b.info.Size() {
+				return a.info.Size() < b.info.Size()
+			}
+		case "mtime":
+			if !a.info.ModTime().Equal(b.info.ModTime()) {
+				return a.info.ModTime().Before(b.info.ModTime())
+			}
+		case "type":
+			ea, eb := strings.ToLower(path.Ext(a.name)), strings.ToLower(path.Ext(b.name))
+			if ea != eb {
+				return ea < eb
+			}
+		}
+		return naturalLess(a.name, b.name)
+	})
+	return entries
+}
+
+// query returns the query string that shows the view, on the given page
+func (v listingView) query(sortKey string, desc bool, page int) string {
+	q := url.Values{"sort": {sortKey}, "order": {orderName(desc)}}
+	if v.Filter != "" {
+		q.Set("filter", v.Filter)
+	}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	if v.Limit != DefaultPageSize {
+		q.Set("limit", strconv.Itoa(v.Limit))
+	}
+	return "?" + q.Encode()
+}
+
+// layoutURL returns the URL of the view's page in the other layout
+func (v listingView) layoutURL() string {
+	layout := "grid"
+	if v.Grid {
+		layout = "list"
+	}
+	return v.query(v.Sort, v.Desc, v.Page) + "&layout=" + layout
+}
+
+// pagination describes the view's page of a listing of total entries
+func (v listingView) pagination(total int) models.Pagination {
+	p := models.Pagination{
+		Page:  v.Page,
+		Pages: max(1, (total+v.Limit-1)/v.Limit),
+		Limit: v.Limit,
+		Total: total,
+	}
+	if p.Page > 1 {
+		p.PrevURL = v.query(v.Sort, v.Desc, min(p.Page-1, p.Pages))
+	}
+	if p.Page < p.Pages {
+		p.NextURL = v.query(v.Sort, v.Desc, p.Page+1)
+	}
+	return p
+}
+
+// links returns the column headers of the listing, each linking to the
+// listing sorted by that column. The current column toggles its order.
+func (v listingView) links() []models.SortLink {
+	var links []models.SortLink
+	for _, k := range sortKeys {
+		link := models.SortLink{Label: k.label, Active: k.key == v.Sort}
+		if link.Active {
+			link.Arrow = "▲"
+			if v.Desc {
+				link.Arrow = "▼"
+			}
+		}
+
+		// A new order starts over at the first page
+		link.URL = v.query(k.key, link.Active && !v.Desc, 1)
+		links = append(links, link)
+	}
+	return links
+}
+
+func orderName(desc bool) string {
+	if desc {
+		return "desc"
+	}
+	return "asc"
+}
+
+// naturalLess compares names case-insensitively, ordering runs of digits
+// by their numeric value so that "file2" sorts before "file10"
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if isDigit(ra[i]) && isDigit(rb[j]) {
+			si, sj := i, j
+			for i < len(ra) && isDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && isDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			// Equal values: fewer leading zeros first
+			if i-si != j-sj {
+				return i-si < j-sj
+			}
+			continue
+		}
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	// Names equal but for case: keep the order stable and deterministic
+	return a < b
+}
+
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Decoders for the formats thumbnails are made of
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"fileserv/internal/auth"
+)
+
+// ThumbPath serves thumbnails of images, at ThumbPath + the image's URL
+// path
+const ThumbPath = "/_fileserv/thumb/"
+
+// ThumbSize is the largest width or height of a thumbnail
+const ThumbSize = 320
+
+// maxThumbPixels bounds the images thumbnails are made of, so one huge
+// image cannot take all the memory. Decoded, it takes up to 160 MB.
+const maxThumbPixels = 40_000_000
+
+// thumbBudget bounds the pixels of all the images being decoded at once,
+// however many thumbnails are made in parallel
+const thumbBudget = 2 * maxThumbPixels
+
+// thumbUnused is how long a cached thumbnail is kept without being shown
+const thumbUnused = 30 * 24 * time.Hour
+
+// thumbExtensions are the files thumbnails are made of
+var thumbExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// errNoThumbnail is returned for images that cannot be decoded. It is
+// cached as an empty file so they are not decoded again.
+var errNoThumbnail = errors.New("no thumbnail")
+
+// hasThumbnail reports whether a thumbnail is made of the file called name
+func hasThumbnail(name string) bool {
+	return thumbExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// thumbURL returns where the thumbnail of the file at urlPath is served.
+// The URL changes with the file's modification time, so browsers may keep
+// thumbnails for good.
+func thumbURL(urlPath string, mtime time.Time) string {
+	u := url.URL{
+		Path:     ThumbPath + strings.TrimPrefix(urlPath, "/"),
+		RawQuery: "v=" + strconv.FormatInt(mtime.Unix(), 36),
+	}
+	return u.String()
+}
+
+// HandleThumbnail serves a JPEG thumbnail of an image, making it on first
+// use and keeping it in the thumbnail cache
+func (fs *FileServer) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := fs.resolveTarget(r.Context(), strings.TrimPrefix(r.URL.Path, ThumbPath), auth.AccessRead)
+	if err != nil {
+		status, _ := opStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	info, err := os.Stat(t.fsPath)
+	if err != nil || !info.Mode().IsRegular() || !hasThumbnail(t.fsPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := fs.thumbnail(t.fsPath, info)
+	if errors.Is(err, errNoThumbnail) {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		log.Printf("Error making thumbnail of %s: %v", t.fsPath, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	if r.URL.Query().Has("v") {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// thumbnail returns the thumbnail of the image at fsPath, from the cache if
+// it has one for this version of the file. Cache entries are keyed by the
+// path, size and modification time, so changed files get new thumbnails.
+func (fs *FileServer) thumbnail(fsPath string, info os.FileInfo) ([]byte, error) {
+	cache := ""
+	if fs.opts.ThumbDir != "" {
+		sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%d", fsPath, info.Size(), info.ModTime().UnixNano(), ThumbSize))
+		key := hex.EncodeToString(sum[:])
+		cache = filepath.Join(fs.opts.ThumbDir, key[:2], key+".jpg")
+
+		if data, err := os.ReadFile(cache); err == nil {
+			// Keep thumbnails that are still shown from being purged
+			if st, err := os.Stat(cache); err == nil && time.Since(st.ModTime()) > 24*time.Hour {
+				now := time.Now()
+				os.Chtimes(cache, now, now)
+			}
+			if len(data) == 0 {
+				return nil, errNoThumbnail
+			}
+			return data, nil
+		}
+	}
+
+	fs.thumbSem <- struct{}{}
+	data, err := fs.makeThumbnail(fsPath)
+	<-fs.thumbSem
+	if err != nil && !errors.Is(err, errNoThumbnail) {
+		return nil, err
+	}
+
+	if cache != "" {
+		if err := writeThumbnail(cache, data); err != nil {
+			log.Printf("Error caching thumbnail: %v", err)
+		}
+	}
+	return data, err
+}
+
+// makeThumbnail decodes the image at fsPath and scales it down to fit in
+// ThumbSize. Transparent images are shown on white.
+func (fs *FileServer) makeThumbnail(fsPath string) ([]byte, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Check the size before decoding the whole image
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+	pixels := int64(cfg.Width) * int64(cfg.Height)
+	if pixels > maxThumbPixels {
+		return nil, fmt.Errorf("%w: %dx%d is too large", errNoThumbnail, cfg.Width, cfg.Height)
+	}
+	fs.thumbPixels.acquire(pixels)
+	defer fs.thumbPixels.release(pixels)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoThumbnail, err)
+	}
+
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > ThumbSize || height > ThumbSize {
+		if width >= height {
+			width, height = ThumbSize, max(1, height*ThumbSize/width)
+		} else {
+			width, height = max(1, width*ThumbSize/height), ThumbSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pixelBudget is a semaphore weighted by image size. Waiting images are
+// let in as pixels come free, in no particular order.
+type pixelBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	free int64
+}
+
+func newPixelBudget(n int64) *pixelBudget {
+	b := &pixelBudget{free: n}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits until n pixels are free and takes them. n must not exceed
+// the budget.
+func (b *pixelBudget) acquire(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.free < n {
+		b.cond.Wait()
+	}
+	b.free -= n
+}
+
+func (b *pixelBudget) release(n int64) {
+	b.mu.Lock()
+	b.free += n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// writeThumbnail stores a thumbnail in the cache. It is written to a
+// temporary file first so concurrent requests never read half of it.
+func writeThumbnail(cache string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cache), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(cache), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cache)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// thumbLoop purges thumbnails that have not been shown for a while
+func (fs *FileServer) thumbLoop() {
+	for {
+		cutoff := time.Now().Add(-thumbUnused)
+		purged := 0
+		filepath.WalkDir(fs.opts.ThumbDir, func(p string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+				if os.Remove(p) == nil {
+					purged++
+				}
+			}
+			return nil
+		})
+		if purged > 0 {
+			log.Printf("Purged %d unused thumbnails", purged)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/models"
+	"fileserv/internal/template"
+)
+
+// TrashPath serves each mount's trash page, at TrashPath + mount name
+const TrashPath = "/_fileserv/trash/"
+
+// DefaultTrashRetention is how long deleted files are kept when no
+// retention is configured
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashDir holds a mount's deleted files. Each item is stored under a
+// generated ID, with its metadata next to it in <id>.json.
+const trashDir = internalPrefix + "trash"
+
+// trashEntry is the metadata of a deleted item
+type trashEntry struct {
+	// Path is the item's original path relative to the mount root
+	Path      string    `json:"path"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// hasTrash reports whether deletes in dir go to its trash
+func hasTrash(dir models.Directory) bool {
+	return !dir.ReadOnly && !dir.DisableTrash
+}
+
+// moveToTrash moves the target into its mount's trash, recording where it
+// came from and who deleted it
+func moveToTrash(t target, user string) error {
+	if _, err := os.Lstat(t.fsPath); err != nil {
+		return err
+	}
+
+	trash := filepath.Join(t.dir.Path, trashDir)
+	if err := os.MkdirAll(trash, 0o700); err != nil {
+		return err
+	}
+	id, err := newTrashID()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(trashEntry{
+		Path:      path.Clean(t.rel),
+		DeletedBy: user,
+		DeletedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	meta := filepath.Join(trash, id+".json")
+	if err := os.WriteFile(meta, data, 0o600); err != nil {
+		return err
+	}
+
+	if err := moveNoReplace(t.fsPath, filepath.Join(trash, id)); err != nil {
+		os.Remove(meta)
+		return err
+	}
+	return nil
+}
+
+// newTrashID returns an ID that sorts by deletion time
+func newTrashID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b), nil
+}
+
+// validTrashID keeps client-supplied IDs from naming anything but an item
+// in the trash directory
+func validTrashID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && c != 'T' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func readTrashEntry(dir models.Directory, id string) (trashEntry, error) {
+	var entry trashEntry
+	data, err := os.ReadFile(filepath.Join(dir.Path, trashDir, id+".json"))
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, fmt.Errorf("trash item %s: %w", id, err)
+	}
+	return entry, nil
+}
+
+// listTrash returns the items in dir's trash, most recently deleted first
+func listTrash(dir models.Directory) ([]models.TrashItem, error) {
+	trash := filepath.Join(dir.Path, trashDir)
+	entries, err := os.ReadDir(trash)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var items []models.TrashItem
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validTrashID(id) {
+			continue
+		}
+		entry, err := readTrashEntry(dir, id)
+		if err != nil {
+			log.Printf("Error reading trash of /%s: %v", dir.Name, err)
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(trash, id))
+		if err != nil {
+			continue
+		}
+
+		item := models.TrashItem{
+			ID:        id,
+			Name:      path.Base(entry.Path),
+			IsDir:     info.IsDir(),
+			Path:      "/" + dir.Name + entry.Path,
+			DeletedBy: entry.DeletedBy,
+			DeletedAt: entry.DeletedAt,
+		}
+		if !info.IsDir() {
+			item.Size = info.Size()
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// restoreFromTrash moves an item back to where it was deleted from,
+// recreating missing parent directories. When the name has been taken
+// since, the item is restored as "name (1).ext". It returns the URL path
+// the item was restored to.
+func restoreFromTrash(dir models.Directory, id string) (string, error) {
+	entry, err := readTrashEntry(dir, id)
+	if err != nil {
+		return "", err
+	}
+	rel := path.Clean("/" + entry.Path)
+	if rel == "/" || isInternal(rel) {
+		return "", fmt.Errorf("trash item %s has invalid path %q", id, entry.Path)
+	}
+
+	parent := filepath.Join(dir.Path, filepath.FromSlash(path.Dir(rel)))
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	trash := filepath.Join(dir.Path, trashDir)
+	name, err := placeFile(filepath.Join(trash, id), parent, path.Base(rel), ConflictRename)
+	if err != nil {
+		return "", err
+	}
+	os.Remove(filepath.Join(trash, id+".json"))
+
+	return path.Join("/"+dir.Name, path.Dir(rel), name), nil
+}
+
+// purgeTrash deletes an item from dir's trash for good
+func purgeTrash(dir models.Directory, id string) error {
+	trash := filepath.Join(dir.Path, trashDir)
+	if _, err := os.Lstat(filepath.Join(trash, id+".json")); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(trash, id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(trash, id+".json"))
+}
+
+// trashLoop purges items older than the retention period from every trash
+func (fs *FileServer) trashLoop() {
+	for {
+		now := time.Now()
+		for _, dir := range fs.directories {
+			if !hasTrash(dir) {
+				continue
+			}
+			items, err := listTrash(dir)
+			if err != nil {
+				log.Printf("Error reading trash of /%s: %v", dir.Name, err)
+				continue
+			}
+			for _, item := range items {
+				if now.Sub(item.DeletedAt) < fs.opts.TrashRetention {
+					continue
+				}
+				if err := purgeTrash(dir, item.ID); err != nil {
+					log.Printf("Error purging %s from trash: %v", item.Path, err)
+					continue
+				}
+				log.Printf("Purged %s from trash", item.Path)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// HandleTrash shows a mount's trash page
+func (fs *FileServer) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := fs.resolveTarget(r.Context(), strings.TrimPrefix(r.URL.Path, TrashPath), auth.AccessAdmin)
+	var oe *opError
+	if errors.As(err, &oe) {
+		http.Error(w, http.StatusText(oe.status), oe.status)
+		return
+	}
+	if !t.isRoot() || !hasTrash(t.dir) {
+		http.NotFound(w, r)
+		return
+	}
+
+	items, err := listTrash(t.dir)
+	if err != nil {
+		log.Printf("Error reading trash of /%s: %v", t.dir.Name, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := models.TrashData{
+		Mount:         "/" + t.dir.Name,
+		Items:         items,
+		RetentionDays: int(fs.opts.TrashRetention.Hours() / 24),
+	}
+	data.User, data.CanLogout = currentUser(r)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := template.RenderTrash(w, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/tus"
+)
+
+// ConflictPolicy decides what happens when an upload's name is taken
+type ConflictPolicy string
+
+const (
+	// ConflictReject refuses the upload with 409 Conflict
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictOverwrite replaces the existing file
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename stores the upload as "name (1).ext", "name (2).ext", ...
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// ParseConflictPolicy validates a policy name
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictReject, ConflictOverwrite, ConflictRename:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (want reject, overwrite or rename)", s)
+}
+
+// ResumablePath is where the tus endpoint for resumable uploads is served
+const ResumablePath = "/_fileserv/tus/"
+
+// internalPrefix starts the names of files fileserv keeps inside mounts,
+// such as uploads in progress. They are never listed or served.
+const internalPrefix = ".fileserv-"
+
+var (
+	errConflict    = errors.New("file already exists")
+	errInvalidName = errors.New("invalid file name")
+)
+
+// uploadResult is the JSON answer to an upload
+type uploadResult struct {
+	Files []string `json:"files"`
+}
+
+// handleUpload stores the files of a multipart POST in the directory fsPath
+func (fs *FileServer) handleUpload(w http.ResponseWriter, r *http.Request, fsPath, urlPath string) {
+	policy, ok := fs.conflictPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	// The limit is on each file, which saveUpload enforces; other parts are
+	// skipped without being read into memory
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Bad Request: expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	var saved []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		name, err := fs.saveUpload(fsPath, part.FileName(), part, policy)
+		part.Close()
+		if err != nil {
+			uploadError(w, err)
+			return
+		}
+		saved = append(saved, name)
+	}
+
+	if len(saved) == 0 {
+		http.Error(w, "Bad Request: no files in upload", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Uploaded %d file(s) to %s", len(saved), urlPath)
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusCreated, uploadResult{Files: saved})
+		return
+	}
+	http.Redirect(w, r, urlPath, http.StatusSeeOther)
+}
+
+// handlePut stores the request body as the file at fsPath
+func (fs *FileServer) handlePut(w http.ResponseWriter, r *http.Request, fsPath, urlPath string) {
+	// PUT names the file it stores, so it replaces an existing one unless
+	// ?conflict= says otherwise. If-None-Match: * only creates new files.
+	policy := ConflictOverwrite
+	if r.URL.Query().Has("conflict") {
+		var ok bool
+		if policy, ok = fs.conflictPolicy(w, r); !ok {
+			return
+		}
+	}
+	createOnly := r.Header.Get("If-None-Match") == "*"
+	if createOnly {
+		policy = ConflictReject
+	}
+
+	parent := filepath.Dir(fsPath)
+	if info, err := os.Stat(parent); err != nil || !info.IsDir() {
+		http.Error(w, "Conflict: parent directory does not exist", http.StatusConflict)
+		return
+	}
+	info, err := os.Stat(fsPath)
+	if err == nil && info.IsDir() {
+		http.Error(w, "Conflict: a directory exists at this path", http.StatusConflict)
+		return
+	}
+	existed := err == nil
+	if existed && createOnly {
+		http.Error(w, "Precondition Failed: file already exists", http.StatusPreconditionFailed)
+		return
+	}
+
+	name, err := fs.saveUpload(parent, filepath.Base(fsPath), r.Body, policy)
+	if errors.Is(err, errConflict) && createOnly {
+		http.Error(w, "Precondition Failed: file already exists", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	log.Printf("Uploaded %s", path.Join(path.Dir(urlPath), name))
+
+	status := http.StatusCreated
+	if existed && policy == ConflictOverwrite {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, uploadResult{Files: []string{name}})
+}
+
+// conflictPolicy returns the policy requested with ?conflict=, falling back
+// to the configured one
+func (fs *FileServer) conflictPolicy(w http.ResponseWriter, r *http.Request) (ConflictPolicy, bool) {
+	q := r.URL.Query().Get("conflict")
+	if q == "" {
+		return fs.opts.OnConflict, true
+	}
+	policy, err := ParseConflictPolicy(q)
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return policy, true
+}
+
+// maxUploadBody caps the body of a request carrying a single file, leaving
+// the file's own limit to answer first
+func (fs *FileServer) maxUploadBody() int64 {
+	return uploadBody(fs.opts.MaxUploadSize)
+}
+
+// uploadBody returns how large a multipart body carrying files of up to
+// limit bytes may be; a limit of 0 means no limit
+func uploadBody(limit int64) int64 {
+	if limit <= 0 {
+		return 1<<63 - 1
+	}
+	return limit + 64<<10
+}
+
+// saveUpload streams src into a temporary file in dir and moves it to its
+// final name according to policy. It returns the name the file was saved as.
+func (fs *FileServer) saveUpload(dir, name string, src io.Reader, policy ConflictPolicy) (string, error) {
+	return saveFile(dir, name, src, fs.opts.MaxUploadSize, policy)
+}
+
+// saveFile is saveUpload with a size limit of its own; 0 means no limit
+func saveFile(dir, name string, src io.Reader, limit int64, policy ConflictPolicy) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, internalPrefix+"upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if limit <= 0 {
+		limit = 1<<63 - 1
+	}
+	n, err := io.Copy(tmp, io.LimitReader(src, limit+1))
+	if err == nil && n > limit {
+		err = &http.MaxBytesError{Limit: limit}
+	}
+	if err == nil {
+		// Temporary files are private; uploads get the usual permissions
+		err = tmp.Chmod(0o644)
+	}
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return placeFile(tmp.Name(), dir, name, policy)
+}
+
+// placeFile atomically moves the file at src into dir under name, resolving
+// an existing file with the same name according to policy
+func placeFile(src, dir, name string, policy ConflictPolicy) (string, error) {
+	if policy == ConflictOverwrite {
+		if info, err := os.Lstat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+			return "", errConflict
+		}
+		return name, os.Rename(src, filepath.Join(dir, name))
+	}
+
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		err := renameNoReplace(src, filepath.Join(dir, candidate))
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+		if policy == ConflictReject || i > 9999 {
+			return "", errConflict
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+}
+
+// renameNoReplace moves src to dst unless dst exists. A hard link makes the
+// check and the move a single step; filesystems without hard links fall back
+// to checking first.
+func renameNoReplace(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	if _, err := os.Lstat(dst); err == nil {
+		return os.ErrExist
+	}
+	return os.Rename(src, dst)
+}
+
+// cleanFileName reduces a client-supplied name to a single safe path element
+func cleanFileName(name string) (string, error) {
+	// Browsers may send a full path; keep only the last element
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || strings.HasPrefix(name, internalPrefix) {
+		return "", fmt.Errorf("%w %q", errInvalidName, name)
+	}
+	if strings.ContainsFunc(name, func(c rune) bool { return c < 0x20 || c == 0x7f }) {
+		return "", fmt.Errorf("%w %q", errInvalidName, name)
+	}
+	return name, nil
+}
+
+// uploadError answers a failed upload with a matching status
+func uploadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("Request Entity Too Large: limit is %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errConflict):
+		http.Error(w, "Conflict: file already exists", http.StatusConflict)
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case errors.Is(err, errInvalidName):
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Error saving upload: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// wantsJSON reports whether the client asked for a JSON response
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// ResumableBackend returns the tus.Backend that stores resumable uploads in
+// the server's mounts
+func (fs *FileServer) ResumableBackend() tus.Backend {
+	return resumableBackend{fs: fs}
+}
+
+type resumableBackend struct {
+	fs *FileServer
+}
+
+// Target implements tus.Backend with the same checks as a regular upload
+func (b resumableBackend) Target(r *http.Request, target string) (string, error) {
+	target = path.Clean("/" + target)
+	dir, relPath, ok := b.fs.resolve(target)
+	if !ok || isInternal(relPath) || isExcluded(dir, relPath) {
+		return "", fmt.Errorf("%w: no mount serves %s", os.ErrNotExist, target)
+	}
+	if dir.ReadOnly {
+		return "", fmt.Errorf("%w: mount is read-only", os.ErrPermission)
+	}
+	if b.fs.access(r.Context(), dir, target) < auth.AccessWrite {
+		return "", fmt.Errorf("%w: access to %s denied", os.ErrPermission, target)
+	}
+
+	fsPath := filepath.Join(dir.Path, filepath.Clean(relPath))
+	if info, err := os.Stat(fsPath); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: %s is not a directory", os.ErrNotExist, target)
+	}
+	return fsPath, nil
+}
+
+// Finish implements tus.Backend
+func (b resumableBackend) Finish(src, dir, name string) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", os.ErrPermission, err)
+	}
+	if err := os.Chmod(src, 0o644); err != nil {
+		return "", err
+	}
+
+	name, err = placeFile(src, dir, name, b.fs.opts.OnConflict)
+	if errors.Is(err, errConflict) {
+		return "", fmt.Errorf("%w: %s already exists", os.ErrExist, name)
+	}
+	return name, err
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fileserv/internal/models"
+)
+
+// The upload limit applies to each file of a multipart POST, not to the
+// request as a whole
+func TestUploadLimitPerFile(t *testing.T) {
+	const limit = 1 << 20
+	tests := []struct {
+		name   string
+		sizes  []int
+		status int
+	}{
+		{"one file", []int{limit}, http.StatusCreated},
+		{"files adding up to more than the limit", []int{limit - 1, limit / 2, limit}, http.StatusCreated},
+		{"a file over the limit", []int{limit / 2, limit + 1}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			fs := NewFileServer([]models.Directory{{Name: "up", Path: root}}, Options{MaxUploadSize: limit})
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for i, n := range tt.sizes {
+				fw, err := mw.CreateFormFile("file", fmt.Sprintf("f%d.bin", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fw.Write(bytes.Repeat([]byte{'x'}, n))
+			}
+			mw.Close()
+
+			r := httptest.NewRequest(http.MethodPost, "/up/", &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			r.Header.Set("Accept", "application/json")
+			w := httptest.NewRecorder()
+			fs.HandleRequest(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("upload of %v bytes = %d, want %d: %s", tt.sizes, w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			for i, n := range tt.sizes {
+				info, err := os.Stat(filepath.Join(root, fmt.Sprintf("f%d.bin", i)))
+				if err != nil || info.Size() != int64(n) {
+					t.Errorf("f%d.bin: %v, %v", i, info, err)
+				}
+			}
+			entries, _ := os.ReadDir(root)
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), internalPrefix) {
+					t.Errorf("left %s behind", e.Name())
+				}
+			}
+		})
+	}
+}
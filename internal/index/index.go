@@ -0,0 +1,653 @@
+// Package index keeps an in-memory index of the file names below a
+// directory, for searches that would take too long walking the disk. The
+// index is rebuilt by walking the directory periodically, kept current in
+// between by change notifications where the system offers them, and saved
+// to disk so a restart does not need a walk before searches are fast.
+package index
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRescan is how often the directory is walked in full unless
+// Options.Rescan says otherwise
+const DefaultRescan = 24 * time.Hour
+
+// saveEvery is how often changes picked up between walks are saved
+const saveEvery = 5 * time.Minute
+
+// fileMagic starts every saved index; the number is the format version
+const fileMagic = "fileserv-index 1\n"
+
+// Options configure an index
+type Options struct {
+	// Root is the directory indexed
+	Root string
+	// File is where the index is saved; empty keeps it in memory only
+	File string
+	// Rescan is how often Root is walked in full
+	Rescan time.Duration
+	// Skip leaves a path out of the index, and directories with everything
+	// below them. Paths are slash-separated and start with "/".
+	Skip func(rel string, isDir bool) bool
+	// Text, when set, also indexes the contents of text files
+	Text *TextOptions
+}
+
+// Status describes the state of an index
+type Status struct {
+	Entries  int
+	LastScan time.Time
+	Scanning bool
+	Watching bool
+	// TextFiles counts the files indexed by content
+	TextFiles int
+}
+
+// node is a file or directory in the index. Directories have a non-nil
+// children map, even when empty.
+type node struct {
+	name     string
+	parent   *node
+	children map[string]*node
+}
+
+func (n *node) isDir() bool {
+	return n.children != nil
+}
+
+// size counts n and everything below it
+func (n *node) size() int {
+	count := 1
+	for _, c := range n.children {
+		count += c.size()
+	}
+	return count
+}
+
+// sortedName is an entry of the name-sorted view used by prefix searches
+type sortedName struct {
+	lower string
+	n     *node
+}
+
+// Index is the file name index of one directory. It is safe for
+// concurrent use.
+type Index struct {
+	opts Options
+
+	mu       sync.RWMutex
+	root     *node // nil until loaded or walked
+	entries  int
+	scanned  time.Time
+	scanning bool
+	changed  bool // since the last save
+
+	// sorted is rebuilt on the first prefix search after a change
+	sortedMu sync.Mutex
+	sorted   []sortedName
+	stale    bool
+
+	rescan chan struct{}
+	w      *watcher
+	text   *textIndex // nil unless contents are indexed
+}
+
+// Open loads the saved index, if any, and starts keeping it current in the
+// background
+func Open(opts Options) *Index {
+	if opts.Rescan <= 0 {
+		opts.Rescan = DefaultRescan
+	}
+	if opts.Skip == nil {
+		opts.Skip = func(string, bool) bool { return false }
+	}
+	ix := &Index{opts: opts, rescan: make(chan struct{}, 1), stale: true}
+	if opts.Text != nil {
+		ix.text = newTextIndex(*opts.Text)
+	}
+
+	if opts.File != "" {
+		root, scanned, err := load(opts.File, opts.Root)
+		switch {
+		case err == nil:
+			ix.root, ix.entries, ix.scanned = root, root.size()-1, scanned
+		case !errors.Is(err, os.ErrNotExist):
+			log.Printf("Ignoring saved index of %s: %v", opts.Root, err)
+		}
+	}
+
+	ix.w = newWatcher(ix)
+	go ix.run()
+	return ix
+}
+
+// Ready reports whether the index holds a complete list of the directory,
+// from disk or a finished walk
+func (ix *Index) Ready() bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.root != nil
+}
+
+// Status returns the current state of the index
+func (ix *Index) Status() Status {
+	ix.mu.RLock()
+	st := Status{
+		Entries:  ix.entries,
+		LastScan: ix.scanned,
+		Scanning: ix.scanning,
+		Watching: ix.w != nil && ix.w.active(),
+	}
+	ix.mu.RUnlock()
+	if ix.text != nil {
+		ix.text.mu.RLock()
+		st.TextFiles = len(ix.text.docs)
+		ix.text.mu.RUnlock()
+	}
+	return st
+}
+
+// Rescan asks for a full walk of the directory as soon as possible
+func (ix *Index) Rescan() {
+	select {
+	case ix.rescan <- struct{}{}:
+	default:
+	}
+}
+
+// run walks the directory when the saved index is missing or due for a
+// rescan, and then every Rescan period, saving changes in between
+func (ix *Index) run() {
+	if ix.Ready() {
+		ix.w.addTree("/")
+		ix.syncText()
+	}
+	for {
+		ix.mu.RLock()
+		due := ix.root == nil || time.Since(ix.scanned) >= ix.opts.Rescan
+		wait := time.Until(ix.scanned.Add(ix.opts.Rescan))
+		ix.mu.RUnlock()
+
+		if due {
+			ix.scan()
+			continue
+		}
+		select {
+		case <-time.After(min(wait, saveEvery)):
+			ix.save()
+		case <-ix.rescan:
+			ix.scan()
+		}
+	}
+}
+
+// scan walks the directory into a new tree and swaps it in. Changes
+// reported while the walk runs may be lost; the next walk picks them up.
+func (ix *Index) scan() {
+	ix.mu.Lock()
+	ix.scanning = true
+	ix.mu.Unlock()
+
+	start := time.Now()
+	root := &node{children: make(map[string]*node)}
+	err := ix.walk(root, ix.opts.Root, "/", nil)
+
+	ix.mu.Lock()
+	ix.scanning = false
+	if err != nil {
+		ix.mu.Unlock()
+		log.Printf("Error indexing %s: %v", ix.opts.Root, err)
+		// Try again later rather than in a tight loop
+		time.Sleep(time.Minute)
+		return
+	}
+	ix.root, ix.entries, ix.scanned = root, root.size()-1, start
+	ix.changed = true
+	ix.mu.Unlock()
+	ix.markStale()
+
+	log.Printf("Indexed %d entries in %s in %s", ix.Status().Entries, ix.opts.Root, time.Since(start).Round(time.Millisecond))
+	ix.w.addTree("/")
+	ix.save()
+	ix.syncText()
+}
+
+// syncText reads the text files that changed since they were last indexed
+// by content
+func (ix *Index) syncText() {
+	if ix.text == nil {
+		return
+	}
+	ix.mu.Lock()
+	ix.scanning = true
+	ix.mu.Unlock()
+
+	start := time.Now()
+	ix.text.sync(ix.opts.Root, ix.paths("/", false))
+
+	ix.mu.Lock()
+	ix.scanning = false
+	ix.mu.Unlock()
+	log.Printf("Indexed the contents of %d files in %s in %s", ix.Status().TextFiles, ix.opts.Root, time.Since(start).Round(time.Millisecond))
+}
+
+// walk adds everything below fsPath, which is rel in the index, to n.
+// added is called for every directory added, before it is read.
+func (ix *Index) walk(n *node, fsPath, rel string, added func(rel string)) error {
+	return filepath.WalkDir(fsPath, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if p == fsPath {
+				return err
+			}
+			// Unreadable directories are indexed without their contents
+			return nil
+		}
+		if p == fsPath {
+			return nil
+		}
+
+		r := path.Join(rel, filepath.ToSlash(strings.TrimPrefix(p, fsPath)))
+		if ix.opts.Skip(r, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		parent := lookup(n, strings.TrimPrefix(path.Dir(r), rel))
+		if parent == nil || !parent.isDir() {
+			return nil
+		}
+		child := &node{name: d.Name(), parent: parent}
+		if d.IsDir() {
+			child.children = make(map[string]*node)
+			if added != nil {
+				added(r)
+			}
+		}
+		parent.children[child.name] = child
+		return nil
+	})
+}
+
+// lookup finds the node at rel below n
+func lookup(n *node, rel string) *node {
+	for _, name := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if n = n.children[name]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// add records a new entry at rel, indexing the contents of directories.
+// added is called for every directory added.
+func (ix *Index) add(rel string, isDir bool, added func(rel string)) {
+	if ix.opts.Skip(rel, isDir) {
+		return
+	}
+	ix.mu.RLock()
+	ready := ix.root != nil
+	ix.mu.RUnlock()
+	if !ready {
+		return
+	}
+
+	// Walk a new directory into a detached node first, so the lock is not
+	// held while reading the disk
+	n := &node{name: path.Base(rel)}
+	if isDir {
+		n.children = make(map[string]*node)
+		if added != nil {
+			added(rel)
+		}
+		if err := ix.walk(n, filepath.Join(ix.opts.Root, filepath.FromSlash(rel)), rel, added); err != nil {
+			return
+		}
+	}
+
+	ix.mu.Lock()
+	parent := lookup(ix.root, path.Dir(rel))
+	if parent != nil && parent.isDir() {
+		if old := parent.children[n.name]; old != nil {
+			ix.entries -= old.size()
+		}
+		n.parent = parent
+		parent.children[n.name] = n
+		ix.entries += n.size()
+		ix.changed = true
+	}
+	ix.mu.Unlock()
+	ix.markStale()
+
+	if ix.text != nil {
+		for _, file := range ix.paths(rel, false) {
+			ix.text.update(ix.opts.Root, file)
+		}
+	}
+}
+
+// modified indexes the contents of the file at rel again after it was
+// written to
+func (ix *Index) modified(rel string) {
+	if ix.text == nil {
+		return
+	}
+	ix.mu.RLock()
+	known := ix.root != nil && lookup(ix.root, rel) != nil
+	ix.mu.RUnlock()
+	if known {
+		ix.text.update(ix.opts.Root, rel)
+	}
+}
+
+// remove drops the entry at rel and everything below it
+func (ix *Index) remove(rel string) {
+	ix.mu.Lock()
+	if ix.root == nil {
+		ix.mu.Unlock()
+		return
+	}
+	if n := lookup(ix.root, rel); n != nil && n.parent != nil {
+		delete(n.parent.children, n.name)
+		ix.entries -= n.size()
+		ix.changed = true
+	}
+	ix.mu.Unlock()
+	ix.markStale()
+
+	if ix.text != nil {
+		ix.text.removeTree(rel)
+	}
+}
+
+// paths returns the directories at and below rel, or the files below it
+func (ix *Index) paths(rel string, dirs bool) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.root == nil {
+		return nil
+	}
+	n := lookup(ix.root, rel)
+	if n == nil {
+		return nil
+	}
+
+	var found []string
+	var visit func(n *node, rel string)
+	visit = func(n *node, rel string) {
+		if n.isDir() == dirs {
+			found = append(found, rel)
+		}
+		for name, c := range n.children {
+			visit(c, path.Join(rel, name))
+		}
+	}
+	visit(n, path.Clean("/"+rel))
+	return found
+}
+
+func (ix *Index) markStale() {
+	ix.sortedMu.Lock()
+	ix.stale = true
+	ix.sortedMu.Unlock()
+}
+
+// TextSearch returns the paths of up to limit files below under containing
+// every one of words, as split by Words, in path order. It finds nothing
+// unless contents are indexed.
+func (ix *Index) TextSearch(under string, words []string, limit int) []string {
+	if ix.text == nil {
+		return nil
+	}
+	return ix.text.search(path.Clean("/"+under), words, limit)
+}
+
+// Search returns the paths of up to limit entries below under whose names
+// match, in the order a walk of the directory would find them
+func (ix *Index) Search(under string, limit int, match func(name string) bool) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if ix.root == nil {
+		return nil
+	}
+	n := lookup(ix.root, under)
+	if n == nil {
+		return nil
+	}
+
+	var found []string
+	var visit func(n *node, rel string)
+	visit = func(n *node, rel string) {
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if len(found) >= limit {
+				return
+			}
+			c := n.children[name]
+			if match(name) {
+				found = append(found, path.Join(rel, name))
+			}
+			if c.isDir() {
+				visit(c, path.Join(rel, name))
+			}
+		}
+	}
+	visit(n, path.Clean("/"+under))
+	return found
+}
+
+// Prefix returns the paths of up to limit entries below under whose names
+// start with prefix, ignoring case, in name order. It uses a name-sorted
+// view of the index, so it does not need to look at every entry.
+func (ix *Index) Prefix(under, prefix string, limit int) []string {
+	prefix = strings.ToLower(prefix)
+	sorted := ix.sortedNames()
+
+	under = path.Clean("/" + under)
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var found []string
+	i := sort.Search(len(sorted), func(i int) bool { return sorted[i].lower >= prefix })
+	for ; i < len(sorted) && len(found) < limit && strings.HasPrefix(sorted[i].lower, prefix); i++ {
+		if rel, ok := current(sorted[i].n); ok && (under == "/" || strings.HasPrefix(rel, under+"/")) {
+			found = append(found, rel)
+		}
+	}
+	return found
+}
+
+// current returns the path of n, unless it has been removed from the index
+// since the sorted view was built
+func current(n *node) (string, bool) {
+	rel := ""
+	for ; n.parent != nil; n = n.parent {
+		if n.parent.children[n.name] != n {
+			return "", false
+		}
+		rel = "/" + n.name + rel
+	}
+	return rel, rel != ""
+}
+
+// sortedNames returns the name-sorted view of the index, rebuilding it
+// after changes
+func (ix *Index) sortedNames() []sortedName {
+	ix.sortedMu.Lock()
+	defer ix.sortedMu.Unlock()
+	if !ix.stale {
+		return ix.sorted
+	}
+
+	ix.mu.RLock()
+	sorted := make([]sortedName, 0, ix.entries)
+	var visit func(n *node)
+	visit = func(n *node) {
+		for _, c := range n.children {
+			sorted = append(sorted, sortedName{lower: strings.ToLower(c.name), n: c})
+			visit(c)
+		}
+	}
+	if ix.root != nil {
+		visit(ix.root)
+	}
+	ix.mu.RUnlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].lower < sorted[j].lower })
+	ix.sorted, ix.stale = sorted, false
+	return sorted
+}
+
+// save writes the index to its file if it changed since the last save
+func (ix *Index) save() {
+	if ix.opts.File == "" {
+		return
+	}
+	ix.mu.Lock()
+	if !ix.changed || ix.root == nil {
+		ix.mu.Unlock()
+		return
+	}
+	ix.changed = false
+	ix.mu.Unlock()
+
+	if err := ix.write(); err != nil {
+		log.Printf("Error saving index of %s: %v", ix.opts.Root, err)
+		ix.mu.Lock()
+		ix.changed = true
+		ix.mu.Unlock()
+	}
+}
+
+// write saves the index as the root path, the time of the last walk, and
+// then every entry in depth-first order as its depth, a 'd' or 'f' and its
+// name, NUL-terminated
+func (ix *Index) write() error {
+	if err := os.MkdirAll(filepath.Dir(ix.opts.File), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ix.opts.File), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	bw := bufio.NewWriter(gz)
+	ix.mu.RLock()
+	fmt.Fprintf(bw, "%s%s\n%s\n", fileMagic, ix.opts.Root, ix.scanned.UTC().Format(time.RFC3339Nano))
+	var visit func(n *node, depth uint64)
+	visit = func(n *node, depth uint64) {
+		for _, c := range n.children {
+			bw.Write(binary.AppendUvarint(nil, depth))
+			if c.isDir() {
+				bw.WriteByte('d')
+			} else {
+				bw.WriteByte('f')
+			}
+			bw.WriteString(c.name)
+			bw.WriteByte(0)
+			if c.isDir() {
+				visit(c, depth+1)
+			}
+		}
+	}
+	visit(ix.root, 0)
+	ix.mu.RUnlock()
+
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ix.opts.File)
+}
+
+// load reads an index saved by write for the directory root
+func load(file, root string) (*node, time.Time, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	br := bufio.NewReader(gz)
+
+	magic, _ := br.ReadString('\n')
+	savedRoot, _ := br.ReadString('\n')
+	stamp, err := br.ReadString('\n')
+	if err != nil || magic != fileMagic {
+		return nil, time.Time{}, errors.New("not an index file")
+	}
+	if strings.TrimSuffix(savedRoot, "\n") != root {
+		return nil, time.Time{}, fmt.Errorf("index is of %s", strings.TrimSuffix(savedRoot, "\n"))
+	}
+	scanned, err := time.Parse(time.RFC3339Nano, strings.TrimSuffix(stamp, "\n"))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	top := &node{children: make(map[string]*node)}
+	stack := []*node{top}
+	for {
+		depth, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		name, err := br.ReadString(0)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if depth >= uint64(len(stack)) || (kind != 'd' && kind != 'f') {
+			return nil, time.Time{}, errors.New("corrupt index file")
+		}
+
+		stack = stack[:depth+1]
+		parent := stack[depth]
+		n := &node{name: strings.TrimSuffix(name, "\x00"), parent: parent}
+		parent.children[n.name] = n
+		if kind == 'd' {
+			n.children = make(map[string]*node)
+			stack = append(stack, n)
+		}
+	}
+	return top, scanned, nil
+}
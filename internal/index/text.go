@@ -0,0 +1,301 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTextMaxSize is the largest file indexed by content unless
+// TextOptions.MaxSize says otherwise
+const DefaultTextMaxSize = 1 << 20
+
+// DefaultTextExtensions are the extensions of files indexed by content
+// when TextOptions lists neither extensions nor MIME types
+var DefaultTextExtensions = []string{
+	".txt", ".md", ".markdown", ".rst", ".log", ".csv", ".tsv",
+	".json", ".yaml", ".yml", ".toml", ".ini", ".conf", ".cfg", ".xml",
+	".html", ".htm", ".css", ".js", ".ts", ".go", ".py", ".rb", ".rs",
+	".c", ".h", ".cc", ".cpp", ".hpp", ".java", ".kt", ".swift", ".php",
+	".sh", ".bash", ".sql", ".tex",
+}
+
+// DefaultTextMimeTypes are the MIME type prefixes of files indexed by
+// content when TextOptions lists neither extensions nor MIME types
+var DefaultTextMimeTypes = []string{"text/"}
+
+// TextOptions select the files whose contents are indexed. A file is
+// indexed when it is no larger than MaxSize, has one of the Extensions or
+// a MIME type starting with one of the MimeTypes, and does not look like
+// binary data.
+type TextOptions struct {
+	MaxSize    int64
+	Extensions []string
+	MimeTypes  []string
+}
+
+// MinWordLength and MaxWordLength bound the words that are indexed;
+// shorter and longer runs of letters and digits are left out
+const (
+	MinWordLength = 2
+	MaxWordLength = 64
+)
+
+// textDoc is a file whose contents are indexed
+type textDoc struct {
+	size  int64
+	mtime time.Time
+	words []string
+}
+
+// textIndex maps the words in the indexed files to the files containing
+// them
+type textIndex struct {
+	opts TextOptions
+
+	mu       sync.RWMutex
+	docs     map[string]*textDoc // by path
+	postings map[string]map[string]struct{}
+}
+
+func newTextIndex(opts TextOptions) *textIndex {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultTextMaxSize
+	}
+	if len(opts.Extensions) == 0 && len(opts.MimeTypes) == 0 {
+		opts.Extensions, opts.MimeTypes = DefaultTextExtensions, DefaultTextMimeTypes
+	}
+	return &textIndex{
+		opts:     opts,
+		docs:     make(map[string]*textDoc),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// eligible reports whether the file called name is indexed by content,
+// going by its name
+func (t *textIndex) eligible(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return false
+	}
+	if slices.Contains(t.opts.Extensions, ext) {
+		return true
+	}
+	mimeType := mime.TypeByExtension(ext)
+	for _, prefix := range t.opts.MimeTypes {
+		if mimeType != "" && strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// update indexes the file at rel below root again if it changed since it
+// was last indexed
+func (t *textIndex) update(root, rel string) {
+	if !t.eligible(path.Base(rel)) {
+		return
+	}
+	fsPath := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Stat(fsPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > t.opts.MaxSize {
+		t.remove(rel)
+		return
+	}
+
+	t.mu.RLock()
+	old := t.docs[rel]
+	t.mu.RUnlock()
+	if old != nil && old.size == info.Size() && old.mtime.Equal(info.ModTime()) {
+		return
+	}
+
+	data, err := os.ReadFile(fsPath)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		t.remove(rel)
+		return
+	}
+	doc := &textDoc{size: info.Size(), mtime: info.ModTime(), words: Words(string(data))}
+
+	t.mu.Lock()
+	t.drop(rel)
+	t.docs[rel] = doc
+	for _, w := range doc.words {
+		files := t.postings[w]
+		if files == nil {
+			files = make(map[string]struct{})
+			t.postings[w] = files
+		}
+		files[rel] = struct{}{}
+	}
+	t.mu.Unlock()
+}
+
+// remove drops the file at rel from the index
+func (t *textIndex) remove(rel string) {
+	t.mu.Lock()
+	t.drop(rel)
+	t.mu.Unlock()
+}
+
+// removeTree drops rel and every file below it
+func (t *textIndex) removeTree(rel string) {
+	t.mu.Lock()
+	for p := range t.docs {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			t.drop(p)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// drop removes rel from the index; t.mu must be held
+func (t *textIndex) drop(rel string) {
+	doc := t.docs[rel]
+	if doc == nil {
+		return
+	}
+	for _, w := range doc.words {
+		delete(t.postings[w], rel)
+		if len(t.postings[w]) == 0 {
+			delete(t.postings, w)
+		}
+	}
+	delete(t.docs, rel)
+}
+
+// sync brings the index in line with files, the paths of every file in
+// the name index, reading only those that changed
+func (t *textIndex) sync(root string, files []string) {
+	present := make(map[string]bool, len(files))
+	for _, rel := range files {
+		present[rel] = true
+		t.update(root, rel)
+	}
+
+	t.mu.Lock()
+	for rel := range t.docs {
+		if !present[rel] {
+			t.drop(rel)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// search returns the paths of the files below under containing every
+// word, in path order
+func (t *textIndex) search(under string, words []string, limit int) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// Start from the rarest word so the candidate set is small
+	sets := make([]map[string]struct{}, len(words))
+	for i, w := range words {
+		if sets[i] = t.postings[w]; len(sets[i]) == 0 {
+			return nil
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+
+	var found []string
+	for rel := range sets[0] {
+		if under != "/" && !strings.HasPrefix(rel, under+"/") {
+			continue
+		}
+		all := true
+		for _, set := range sets[1:] {
+			if _, ok := set[rel]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			found = append(found, rel)
+		}
+	}
+	sort.Strings(found)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found
+}
+
+// Words splits text into the distinct lower-case words that are indexed:
+// runs of letters, digits and underscores between MinWordLength and
+// MaxWordLength characters long
+func Words(text string) []string {
+	seen := make(map[string]struct{})
+	var words []string
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+		if n := utf8.RuneCountInString(w); n < MinWordLength || n > MaxWordLength {
+			continue
+		}
+		w = strings.ToLower(w)
+		if _, ok := seen[w]; !ok {
+			seen[w] = struct{}{}
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Line is a line of a file containing words searched for
+type Line struct {
+	// Number counts from 1
+	Number int
+	Text   string
+	// Matches are the byte ranges of Text holding the words
+	Matches [][2]int
+}
+
+// MatchLines returns up to limit lines of r containing any of words as a
+// whole word, ignoring case
+func MatchLines(r io.Reader, words []string, limit int) []Line {
+	var lines []Line
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), DefaultTextMaxSize)
+	for n := 1; sc.Scan() && len(lines) < limit; n++ {
+		text := sc.Text()
+		if matches := wordMatches(text, words); len(matches) > 0 {
+			lines = append(lines, Line{Number: n, Text: text, Matches: matches})
+		}
+	}
+	return lines
+}
+
+// wordMatches finds the whole-word occurrences of words in text
+func wordMatches(text string, words []string) [][2]int {
+	var matches [][2]int
+	start := -1
+	for i, r := range text + " " {
+		if i < len(text) && isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if slices.Contains(words, strings.ToLower(text[start:i])) {
+				matches = append(matches, [2]int{start, i})
+			}
+			start = -1
+		}
+	}
+	return matches
+}
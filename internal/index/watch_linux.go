@@ -0,0 +1,167 @@
+//go:build linux
+
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// watchMask is what the watcher is told about: entries appearing and
+// disappearing, and files written to for the contents index
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_CLOSE_WRITE | syscall.IN_ONLYDIR
+
+// watcher keeps an index current with inotify. Every directory needs a
+// watch of its own; when the system limit on watches is reached, the rest
+// of the tree is only picked up by rescans.
+type watcher struct {
+	ix *Index
+	fd int
+	f  *os.File
+
+	mu    sync.Mutex
+	paths map[int32]string // watch descriptor to path
+	wds   map[string]int32
+	full  bool
+}
+
+// newWatcher starts watching for changes, or returns nil when inotify is
+// not available
+func newWatcher(ix *Index) *watcher {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		log.Printf("Not watching %s for changes: %v", ix.opts.Root, err)
+		return nil
+	}
+	w := &watcher{
+		ix:    ix,
+		fd:    fd,
+		f:     os.NewFile(uintptr(fd), "inotify"),
+		paths: make(map[int32]string),
+		wds:   make(map[string]int32),
+	}
+	go w.run()
+	return w
+}
+
+// active reports whether every directory is being watched
+func (w *watcher) active() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.full && len(w.wds) > 0
+}
+
+// addTree watches rel and every directory below it
+func (w *watcher) addTree(rel string) {
+	if w == nil {
+		return
+	}
+	for _, dir := range w.ix.paths(rel, true) {
+		w.add(dir)
+	}
+}
+
+// add watches the directory at rel. Watching a directory again updates
+// the path its events are reported for, as after a rename.
+func (w *watcher) add(rel string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.full {
+		return
+	}
+
+	wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.ix.opts.Root, filepath.FromSlash(rel)), watchMask)
+	if errors.Is(err, syscall.ENOSPC) {
+		w.full = true
+		log.Printf("Reached the inotify watch limit indexing %s; changes below %s are picked up by rescans only (raise fs.inotify.max_user_watches)", w.ix.opts.Root, rel)
+		return
+	}
+	if err != nil {
+		return
+	}
+	if old, ok := w.paths[int32(wd)]; ok {
+		delete(w.wds, old)
+	}
+	w.paths[int32(wd)] = rel
+	w.wds[rel] = int32(wd)
+}
+
+// removeTree stops watching rel and the directories below it
+func (w *watcher) removeTree(rel string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for p, wd := range w.wds {
+		if p == rel || strings.HasPrefix(p, rel+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, p)
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// run reads events until the process ends
+func (w *watcher) run() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			log.Printf("Stopped watching %s for changes: %v", w.ix.opts.Root, err)
+			w.mu.Lock()
+			w.full = true
+			w.mu.Unlock()
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+size]), "\x00")
+			off += syscall.SizeofInotifyEvent + size
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+func (w *watcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were dropped, so only a walk can tell what changed
+		w.ix.Rescan()
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.paths[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		if w.wds[dir] == wd {
+			delete(w.wds, dir)
+		}
+		ok = false
+	}
+	w.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	rel := path.Join(dir, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		w.ix.add(rel, isDir, w.add)
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		w.ix.modified(rel)
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.ix.remove(rel)
+		if isDir {
+			w.removeTree(rel)
+		}
+	}
+}
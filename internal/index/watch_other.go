@@ -0,0 +1,13 @@
+//go:build !linux
+
+package index
+
+// watcher would keep the index current between walks; without inotify,
+// changes are picked up by the periodic rescans only
+type watcher struct{}
+
+func newWatcher(*Index) *watcher { return nil }
+
+func (w *watcher) active() bool { return false }
+
+func (w *watcher) addTree(string) {}
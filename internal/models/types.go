@@ -1,17 +1,69 @@
 package models
 
-// FileInfo represents a file or directory in the listing
+import (
+	"html/template"
+	"net/netip"
+	"time"
+)
+
+// FileInfo represents a file or directory in the listing. The same data
+// is rendered as HTML and returned by the JSON API.
 type FileInfo struct {
-	Name  string
-	IsDir bool
-	Path  string
-	Size  int64
+	Name  string `json:"name"`
+	IsDir bool   `json:"-"`
+	// Type is "directory" or "file"
+	Type string `json:"type"`
+	// Path is the URL path of the entry; URL is the same path escaped
+	Path    string    `json:"path"`
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// Mode holds the permission bits in octal, such as "0644"
+	Mode     string `json:"mode"`
+	MimeType string `json:"mime_type,omitempty"`
+	// Thumbnail is the URL of a small version of images, empty for other
+	// files
+	Thumbnail string `json:"thumbnail,omitempty"`
 }
 
 // Directory represents a root directory being served
 type Directory struct {
 	Name string
 	Path string
+
+	// ReadOnly marks the mount as not accepting any modification
+	ReadOnly bool
+	// Hidden keeps the mount out of the root page and directory switcher;
+	// it stays reachable by its URL
+	Hidden bool
+	// DisableListing refuses directory listings while still serving files
+	DisableListing bool
+	// DisableTrash deletes files for good instead of moving them to the
+	// mount's trash
+	DisableTrash bool
+	// DisableReadme leaves README files and directory descriptions out of
+	// listings
+	DisableReadme bool
+	// Exclude holds glob patterns of files that are never listed, served
+	// or archived
+	Exclude []string
+	// Index keeps a file name index of the mount, used by searches
+	Index bool
+	// FullText also indexes the contents of the mount's text files
+	FullText bool
+	// Credentials maps user names to passwords declared inline for the mount
+	Credentials map[string]string
+	// Users and Groups restrict the mount to the listed users and members
+	// of the listed groups; both empty means unrestricted
+	Users  []string
+	Groups []string
+	// Allow and Deny restrict the mount to clients whose address is in an
+	// allowed range, if there are any, and in no denied one
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	// Bandwidth caps the bytes per second sent from the mount in all; 0
+	// leaves it uncapped
+	Bandwidth int64
 }
 
 // PageData represents the data passed to the directory listing template
@@ -20,4 +72,266 @@ type PageData struct {
 	Files       []FileInfo
 	Directories []Directory
 	IsRoot      bool
+	// User is the signed-in user's name, empty for anonymous visitors
+	User string
+	// CanLogout is set when the user signed in through the login form
+	CanLogout bool
+	// Writable enables uploading into the current directory
+	Writable bool
+	// MaxUpload is the largest file size accepted, 0 for no limit
+	MaxUpload int64
+	// TusURL is the resumable upload endpoint, empty when unavailable
+	TusURL string
+	// TrashURL is the mount's trash page, empty when it has none
+	TrashURL string
+	// SortLinks are the column headers, linking to the listing sorted by
+	// each column
+	SortLinks []SortLink
+	// Filter is the glob the listing is filtered by, if any
+	Filter string
+	// Pagination says which part of the listing Files is
+	Pagination Pagination
+	// Grid shows Files as a gallery of thumbnails rather than a list;
+	// LayoutURL switches to the other layout
+	Grid      bool
+	LayoutURL string
+	// Search fills in the search box in the header
+	Search SearchForm
+	// Indexes is the state of the file name indexes, shown to admins on
+	// the root page
+	Indexes []IndexStatus
+	// Description is shown above the listing and Readme below it, when the
+	// directory has them
+	Description *Readme
+	Readme      *Readme
+	// CanShare offers creating share links for the entries, and
+	// CanDropBox drop boxes taking uploads to the directory
+	CanShare   bool
+	CanDropBox bool
+}
+
+// Readme is a file describing a directory
+type Readme struct {
+	Name string
+	// HTML is the file rendered from Markdown; plain text files are shown
+	// as Text instead
+	HTML template.HTML
+	Text string
+	// Truncated is set when the file was too long to show all of it
+	Truncated bool
+}
+
+// IndexStatus describes the file name index of a mount
+type IndexStatus struct {
+	Mount string `json:"mount"`
+	// Entries counts the files and directories indexed
+	Entries int `json:"entries"`
+	// LastScan is when the mount was last walked in full, zero before the
+	// first walk has finished
+	LastScan time.Time `json:"last_scan"`
+	Scanning bool      `json:"scanning"`
+	// Watching is set while changes are picked up as they happen
+	Watching bool `json:"watching"`
+	// TextFiles counts the files indexed by content, if any are
+	TextFiles int `json:"text_files,omitempty"`
+}
+
+// SearchForm is what the search box searches for
+type SearchForm struct {
+	Query string
+	// Mode is substring, prefix, glob or regex for names, or content
+	Mode string
+	// In is the directory searched, empty for all mounts
+	In string
+	// FullText offers searching file contents
+	FullText bool
+}
+
+// ContentMatch is a file whose contents match a search
+type ContentMatch struct {
+	File  FileInfo      `json:"-"`
+	Path  string        `json:"path"`
+	Lines []MatchedLine `json:"lines"`
+}
+
+// MatchedLine is a line of a file containing the words searched for
+type MatchedLine struct {
+	Number int    `json:"line"`
+	Text   string `json:"text"`
+	// Parts is Text split into the words searched for and what is
+	// between them, for highlighting
+	Parts []TextPart `json:"-"`
+}
+
+// TextPart is a piece of a matched line
+type TextPart struct {
+	Text  string
+	Match bool
+}
+
+// SearchData holds data for the search results page
+type SearchData struct {
+	SearchForm
+	// Searched is set once a query has been run
+	Searched bool
+	Results  []FileInfo
+	// Matches holds the matching lines of each result of a content search
+	Matches []ContentMatch
+	Limit   int
+	// Truncated is set when there were more results than Limit; TimedOut
+	// when the search stopped before it had looked everywhere
+	Truncated bool
+	TimedOut  bool
+	User      string
+	CanLogout bool
+}
+
+// PreviewData holds data for the preview page of a file
+type PreviewData struct {
+	File FileInfo
+	// Kind is how the file is shown: markdown, table, text, image, video,
+	// audio or binary
+	Kind string
+	// HTML is the rendered Markdown
+	HTML template.HTML
+	// Language is the language Lines are highlighted as, empty for plain
+	// text
+	Language string
+	Lines    [][]Token
+	// Rows holds the fields of a table, the first row being the header
+	Rows [][]string
+	// Hex is the hex dump of a binary file
+	Hex string
+	// Truncated is set when only part of the file is shown: the first
+	// Limit bytes, or the first Limit rows of a table
+	Truncated bool
+	Limit     int64
+	User      string
+	CanLogout bool
+}
+
+// Token is a piece of highlighted source code. Class is keyword, string,
+// comment, number or empty for anything else.
+type Token struct {
+	Text  string
+	Class string
+}
+
+// Pagination describes one page of a directory listing
+type Pagination struct {
+	// Page counts from 1; Pages is at least 1, even for an empty listing
+	Page  int
+	Pages int
+	Limit int
+	// Total is the number of entries on all pages together
+	Total int
+	// PrevURL and NextURL link to the neighbouring pages, if any
+	PrevURL string
+	NextURL string
+}
+
+// SortLink is a column header of the directory listing
+type SortLink struct {
+	Label string
+	URL   string
+	// Active marks the column the listing is sorted by; Arrow shows in
+	// which direction
+	Active bool
+	Arrow  string
+}
+
+// Share is a link giving anyone who has it access to one file or
+// directory, until it expires or is revoked
+type Share struct {
+	ID string `json:"id"`
+	// URL is the share link's path; it is only shown to whoever created it
+	// and to admins
+	URL string `json:"url"`
+	// Path is the URL path of what is shared
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"-"`
+	Type      string    `json:"type"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Expires   time.Time `json:"expires"`
+	// Password is set when the link asks for a password
+	Password bool `json:"password"`
+	// MaxDownloads limits how often files may be downloaded through the
+	// link, 0 for no limit
+	MaxDownloads int `json:"max_downloads,omitempty"`
+	Downloads    int `json:"downloads"`
+	// DropBox links take uploads of up to MaxSize bytes and of the given
+	// Types instead of giving access
+	DropBox bool     `json:"drop_box,omitempty"`
+	MaxSize int64    `json:"max_size,omitempty"`
+	Types   []string `json:"types,omitempty"`
+}
+
+// SharesData represents the data passed to the page listing share links
+type SharesData struct {
+	Shares []Share
+	// All is set for admins, who see everyone's shares
+	All       bool
+	User      string
+	CanLogout bool
+}
+
+// ShareData represents the data passed to the page a share link opens
+type ShareData struct {
+	// Name is the name of what is shared
+	Name    string
+	IsDir   bool
+	Expires time.Time
+	// NeedPassword asks for the link's password, with Error saying why the
+	// last attempt failed
+	NeedPassword bool
+	Error        string
+	// File is the shared file, and DownloadURL where it is downloaded
+	File        FileInfo
+	DownloadURL string
+	// CurrentPath is the directory shown, relative to the shared one;
+	// ParentURL links to its parent within the share
+	CurrentPath string
+	ParentURL   string
+	Files       []FileInfo
+	SortLinks   []SortLink
+	Pagination  Pagination
+	// DropBox shows an upload form taking files of up to MaxSize bytes,
+	// 0 for any size, and of the given Types; Received is how many files
+	// the last submission had
+	DropBox  bool
+	MaxSize  int64
+	Types    []string
+	Received int
+}
+
+// TrashItem is a deleted file or directory waiting in a mount's trash
+type TrashItem struct {
+	ID    string
+	Name  string
+	IsDir bool
+	Size  int64
+	// Path is the URL path the item was deleted from
+	Path      string
+	DeletedBy string
+	DeletedAt time.Time
+}
+
+// TrashData represents the data passed to the trash template
+type TrashData struct {
+	// Mount is the URL path of the mount whose trash is shown
+	Mount string
+	Items []TrashItem
+	// RetentionDays is how long items are kept before being purged
+	RetentionDays int
+	User          string
+	CanLogout     bool
+}
+
+// LoginData represents the data passed to the login template
+type LoginData struct {
+	Realm    string
+	Next     string
+	Username string
+	Error    string
 }
@@ -0,0 +1,83 @@
+package netfilter
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies are the address ranges of the reverse proxies whose forwarding
+// headers are believed. Headers from anyone else are ignored, since
+// clients can send whatever they like.
+type Proxies []netip.Prefix
+
+func (p Proxies) contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddr returns the address of the client that made a request. When
+// the request comes from a trusted proxy, the addresses the proxies
+// recorded in the Forwarded header, or failing that X-Forwarded-For, are
+// followed back from the nearest one to the first that is not a trusted
+// proxy itself. Hops hidden as "unknown" or by an obfuscated name give the
+// invalid address, which no range contains.
+func (p Proxies) ClientAddr(r *http.Request) netip.Addr {
+	addr := parseHost(r.RemoteAddr)
+	if !p.contains(addr) {
+		return addr
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr = parseHost(hops[i])
+		if !p.contains(addr) {
+			return addr
+		}
+	}
+	return addr
+}
+
+// forwardedFor returns the client and proxy addresses a request passed
+// through, the client first
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		for _, v := range values {
+			for _, elem := range strings.Split(v, ",") {
+				hop := "unknown"
+				for _, pair := range strings.Split(elem, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						hop = strings.Trim(value, `"`)
+					}
+				}
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHost reads an address with or without a port, IPv6 addresses
+// possibly in brackets
+func parseHost(s string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap().WithZone("")
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap().WithZone("")
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,127 @@
+package netfilter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"fileserv/internal/models"
+)
+
+// ParsePrefix reads an address range in CIDR notation, such as
+// "10.0.0.0/8" or "fd00::/8". A single address stands for a range holding
+// only itself.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address range %q", s)
+		}
+		if p.Addr().Is4In6() {
+			// ::ffff:10.0.0.0/104 is 10.0.0.0/8
+			if p.Bits() < 96 {
+				return netip.Prefix{}, fmt.Errorf("invalid address range %q", s)
+			}
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ParsePrefixes reads a list of address ranges
+func ParsePrefixes(entries []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range entries {
+		p, err := ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Permits reports whether addr may pass lists of allowed and denied
+// ranges, and if not, why. Denied ranges win over allowed ones; an empty
+// allow list lets in every address that is not denied.
+func Permits(allow, deny []netip.Prefix, addr netip.Addr) (bool, string) {
+	for _, p := range deny {
+		if p.Contains(addr) {
+			return false, "denied by " + p.String()
+		}
+	}
+	if len(allow) == 0 {
+		return true, ""
+	}
+	for _, p := range allow {
+		if p.Contains(addr) {
+			return true, ""
+		}
+	}
+	return false, "not in the allowed ranges"
+}
+
+type contextKey struct{}
+
+// Client returns the client address the filter found for a request, and
+// false for requests that did not pass through one
+func Client(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(contextKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// Filter refuses requests from addresses outside the server's allowed
+// ranges, and requests to mounts from addresses outside the mount's
+type Filter struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	proxies Proxies
+	// mounts finds the mount serving a URL path
+	mounts func(path string) (models.Directory, string, bool)
+}
+
+// New creates a filter applying the server-wide ranges allow and deny to
+// every request, and the ranges of the mount that mounts finds to requests
+// for it. Clients are told apart from the proxies they come through.
+func New(allow, deny []netip.Prefix, proxies Proxies, mounts func(path string) (models.Directory, string, bool)) *Filter {
+	return &Filter{allow: allow, deny: deny, proxies: proxies, mounts: mounts}
+}
+
+// Wrap returns a handler that only passes requests from permitted
+// addresses to next, with the client address in their context
+func (f *Filter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := f.proxies.ClientAddr(r)
+		if ok, reason := Permits(f.allow, f.deny, addr); !ok {
+			refuse(w, r, addr, "this server", reason)
+			return
+		}
+		if dir, _, ok := f.mounts(r.URL.Path); ok {
+			if ok, reason := Permits(dir.Allow, dir.Deny, addr); !ok {
+				refuse(w, r, addr, "/"+dir.Name, reason)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, addr)))
+	})
+}
+
+// refuse answers a request from an address that may not reach what, and
+// logs why
+func refuse(w http.ResponseWriter, r *http.Request, addr netip.Addr, what, reason string) {
+	client := "unknown"
+	if addr.IsValid() {
+		client = addr.String()
+	}
+	log.Printf("Refused %s %s from %s: %s", r.Method, r.URL.Path, client, reason)
+	http.Error(w, fmt.Sprintf("Forbidden: your address %s may not access %s", client, what), http.StatusForbidden)
+}
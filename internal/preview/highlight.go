@@ -0,0 +1,354 @@
+package preview
+
+import (
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fileserv/internal/models"
+)
+
+// Token classes, used as CSS classes by the preview page
+const (
+	ClassKeyword = "keyword"
+	ClassString  = "string"
+	ClassComment = "comment"
+	ClassNumber  = "number"
+)
+
+// language describes just enough of a language's syntax to highlight its
+// comments, strings, numbers and keywords
+type language struct {
+	name          string
+	lineComments  []string
+	blockComments [][2]string
+	quotes        []quote
+	keywords      map[string]bool
+	// caseless languages match keywords whatever their case
+	caseless bool
+}
+
+// quote delimits string literals. Only multiline strings continue past
+// the end of a line, and raw strings have no backslash escapes.
+type quote struct {
+	delim     string
+	multiline bool
+	raw       bool
+}
+
+var (
+	doubleQuote = quote{delim: `"`}
+	singleQuote = quote{delim: `'`}
+	backquote   = quote{delim: "`", multiline: true}
+)
+
+func keywords(list string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(list) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	langGo = &language{
+		name:          "Go",
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote, {delim: "`", multiline: true, raw: true}},
+		keywords: keywords(`break case chan const continue default defer else fallthrough
+			for func go goto if import interface map package range return select struct
+			switch type var true false nil iota`),
+	}
+	langC = &language{
+		name:          "C",
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote},
+		keywords: keywords(`auto break case char const continue default do double else enum
+			extern float for goto if inline int long register return short signed sizeof
+			static struct switch typedef union unsigned void volatile while bool true false
+			NULL nullptr class namespace template typename public private protected virtual
+			new delete this using try catch throw`),
+	}
+	langJava = &language{
+		name:          "Java",
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote},
+		keywords: keywords(`abstract assert boolean break byte case catch char class const
+			continue default do double else enum extends final finally float for if
+			implements import instanceof int interface long native new null package private
+			protected public record return short static super switch synchronized this throw
+			throws try var void volatile while true false`),
+	}
+	langJS = &language{
+		name:          "JavaScript",
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote, backquote},
+		keywords: keywords(`async await break case catch class const continue debugger default
+			delete do else enum export extends false finally for from function if implements
+			import in instanceof interface let new null of private public readonly return
+			static super switch this throw true try type typeof undefined var void while
+			with yield`),
+	}
+	langRust = &language{
+		name:          "Rust",
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		// Single quotes also start lifetimes, so only double quotes count
+		quotes: []quote{{delim: `"`, multiline: true}},
+		keywords: keywords(`as async await break const continue crate dyn else enum extern false
+			fn for if impl in let loop match mod move mut pub ref return self Self static
+			struct super trait true type unsafe use where while`),
+	}
+	langPython = &language{
+		name:         "Python",
+		lineComments: []string{"#"},
+		quotes: []quote{{delim: `"""`, multiline: true}, {delim: `'''`, multiline: true},
+			doubleQuote, singleQuote},
+		keywords: keywords(`False None True and as assert async await break class continue def
+			del elif else except finally for from global if import in is lambda nonlocal not
+			or pass raise return try while with yield self`),
+	}
+	langRuby = &language{
+		name:         "Ruby",
+		lineComments: []string{"#"},
+		quotes:       []quote{doubleQuote, singleQuote},
+		keywords: keywords(`BEGIN END alias and begin break case class def do else elsif end
+			ensure false for if in module next nil not or redo require rescue retry return
+			self super then true undef unless until when while yield`),
+	}
+	langPHP = &language{
+		name:          "PHP",
+		lineComments:  []string{"//", "#"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote},
+		keywords: keywords(`abstract array as break case catch class const continue default do
+			echo else elseif extends false final finally fn for foreach function if
+			implements include interface namespace new null private protected public
+			require return static switch throw true try use var while`),
+	}
+	langShell = &language{
+		name:         "Shell",
+		lineComments: []string{"#"},
+		quotes:       []quote{doubleQuote, {delim: `'`, raw: true}},
+		keywords: keywords(`if then else elif fi for while until do done case esac function in
+			return exit local export echo set`),
+	}
+	langSQL = &language{
+		name:          "SQL",
+		lineComments:  []string{"--"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{singleQuote, doubleQuote},
+		keywords: keywords(`select from where insert into values update set delete create table
+			drop alter index view join left right inner outer full cross on using group by
+			order having limit offset and or not null is in like between as distinct union
+			all primary key foreign references default unique check case when then else end
+			begin commit rollback`),
+		caseless: true,
+	}
+	langCSS = &language{
+		name:          "CSS",
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        []quote{doubleQuote, singleQuote},
+	}
+	langMarkup = &language{
+		name:          "HTML",
+		blockComments: [][2]string{{"<!--", "-->"}},
+	}
+	langConfig = &language{
+		name:         "Config",
+		lineComments: []string{"#", ";"},
+		quotes:       []quote{doubleQuote, singleQuote},
+		keywords:     keywords(`true false null yes no on off`),
+	}
+	langJSON = &language{
+		name:     "JSON",
+		quotes:   []quote{doubleQuote},
+		keywords: keywords(`true false null`),
+	}
+)
+
+// languages maps file extensions, and the names of files without one, to
+// their language
+var languages = map[string]*language{
+	".go":   langGo,
+	".c":    langC,
+	".h":    langC,
+	".cc":   langC,
+	".cpp":  langC,
+	".hpp":  langC,
+	".java": langJava,
+	".kt":   langJava,
+	".js":   langJS,
+	".mjs":  langJS,
+	".jsx":  langJS,
+	".ts":   langJS,
+	".tsx":  langJS,
+	".rs":   langRust,
+	".py":   langPython,
+	".rb":   langRuby,
+	".php":  langPHP,
+	".sh":   langShell,
+	".bash": langShell,
+	".zsh":  langShell,
+	".sql":  langSQL,
+	".css":  langCSS,
+	".html": langMarkup,
+	".htm":  langMarkup,
+	".xml":  langMarkup,
+	".svg":  langMarkup,
+	".yaml": langConfig,
+	".yml":  langConfig,
+	".toml": langConfig,
+	".ini":  langConfig,
+	".conf": langConfig,
+	".cfg":  langConfig,
+	".json": langJSON,
+
+	"Makefile":   langShell,
+	"Dockerfile": langShell,
+}
+
+// Highlight splits src into lines of tokens, highlighted according to the
+// language the file called name is written in. It returns the language's
+// name, empty for plain text, which is returned as one token per line.
+func Highlight(name string, src []byte) (string, [][]models.Token) {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	h := highlighter{lines: [][]models.Token{nil}}
+
+	lang := languages[strings.ToLower(path.Ext(name))]
+	if lang == nil {
+		lang = languages[name]
+	}
+	if lang == nil {
+		h.emit(text, "")
+	} else {
+		h.run(lang, text)
+	}
+
+	// A final newline ends the last line rather than starting another
+	if len(h.lines) > 1 && len(h.lines[len(h.lines)-1]) == 0 {
+		h.lines = h.lines[:len(h.lines)-1]
+	}
+	if lang == nil {
+		return "", h.lines
+	}
+	return lang.name, h.lines
+}
+
+// highlighter collects the tokens of a file line by line
+type highlighter struct {
+	lines [][]models.Token
+}
+
+// emit adds text to the current line, starting new lines at newlines
+func (h *highlighter) emit(text, class string) {
+	for {
+		part, rest, more := strings.Cut(text, "\n")
+		if part != "" {
+			line := h.lines[len(h.lines)-1]
+			if n := len(line); n > 0 && line[n-1].Class == class {
+				line[n-1].Text += part
+			} else {
+				h.lines[len(h.lines)-1] = append(line, models.Token{Text: part, Class: class})
+			}
+		}
+		if !more {
+			return
+		}
+		h.lines = append(h.lines, nil)
+		text = rest
+	}
+}
+
+func (h *highlighter) run(lang *language, text string) {
+	i := 0
+next:
+	for i < len(text) {
+		rest := text[i:]
+
+		for _, prefix := range lang.lineComments {
+			if strings.HasPrefix(rest, prefix) {
+				end := strings.IndexByte(rest, '\n')
+				if end < 0 {
+					end = len(rest)
+				}
+				h.emit(rest[:end], ClassComment)
+				i += end
+				continue next
+			}
+		}
+		for _, bc := range lang.blockComments {
+			if strings.HasPrefix(rest, bc[0]) {
+				end := strings.Index(rest[len(bc[0]):], bc[1])
+				if end < 0 {
+					end = len(rest)
+				} else {
+					end += len(bc[0]) + len(bc[1])
+				}
+				h.emit(rest[:end], ClassComment)
+				i += end
+				continue next
+			}
+		}
+		for _, q := range lang.quotes {
+			if strings.HasPrefix(rest, q.delim) {
+				end := q.end(rest)
+				h.emit(rest[:end], ClassString)
+				i += end
+				continue next
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		switch {
+		case unicode.IsDigit(r):
+			end := strings.IndexFunc(rest, func(r rune) bool {
+				return !isWordRune(r) && r != '.'
+			})
+			if end < 0 {
+				end = len(rest)
+			}
+			h.emit(rest[:end], ClassNumber)
+			i += end
+		case isWordRune(r):
+			end := strings.IndexFunc(rest, func(r rune) bool { return !isWordRune(r) })
+			if end < 0 {
+				end = len(rest)
+			}
+			word, class := rest[:end], ""
+			if lang.keywords[word] || lang.caseless && lang.keywords[strings.ToLower(word)] {
+				class = ClassKeyword
+			}
+			h.emit(word, class)
+			i += end
+		default:
+			h.emit(rest[:size], "")
+			i += size
+		}
+	}
+}
+
+// end returns the length of the string literal at the start of s, up to
+// the end of s if it is not closed
+func (q quote) end(s string) int {
+	for i := len(q.delim); i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && !q.raw:
+			i++
+		case s[i] == '\n' && !q.multiline:
+			return i
+		case strings.HasPrefix(s[i:], q.delim):
+			return i + len(q.delim)
+		}
+	}
+	return len(s)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
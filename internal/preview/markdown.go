@@ -0,0 +1,79 @@
+package preview
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// markdown renders GitHub Flavored Markdown. Without the unsafe option
+// raw HTML in documents is dropped and links with scripting schemes such as
+// javascript: are emptied, so the output is safe to put on a page.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(
+		parser.WithAutoHeadingID(),
+		parser.WithASTTransformers(util.Prioritized(linkResolver{}, 100)),
+	),
+)
+
+// baseKey holds the URL relative links are resolved against while a
+// document is rendered
+var baseKey = parser.NewContextKey()
+
+// Markdown renders a Markdown document to HTML. Relative links and images
+// are resolved against base, the URL of the directory the document is in,
+// so they work wherever the document is shown.
+func Markdown(src []byte, base string) (template.HTML, error) {
+	ctx := parser.NewContext()
+	ctx.Set(baseKey, &url.URL{Path: strings.TrimSuffix(base, "/") + "/"})
+
+	var buf bytes.Buffer
+	if err := markdown.Convert(src, &buf, parser.WithContext(ctx)); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// linkResolver rewrites the destinations of relative links and images
+type linkResolver struct{}
+
+func (linkResolver) Transform(doc *ast.Document, _ text.Reader, pc parser.Context) {
+	base, _ := pc.Get(baseKey).(*url.URL)
+	if base == nil {
+		return
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = resolveLink(base, n.Destination)
+		case *ast.Image:
+			n.Destination = resolveLink(base, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolveLink resolves dest against base unless it is absolute, rooted or
+// only a fragment
+func resolveLink(base *url.URL, dest []byte) []byte {
+	if len(dest) == 0 || dest[0] == '/' || dest[0] == '#' {
+		return dest
+	}
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return dest
+	}
+	return []byte(base.ResolveReference(u).String())
+}
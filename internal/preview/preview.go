@@ -0,0 +1,89 @@
+// Package preview turns file contents into something a browser can show
+// on a page: Markdown rendered to HTML, source code split into highlighted
+// lines, and CSV or TSV files split into table rows.
+package preview
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"path"
+	"strings"
+)
+
+// MaxSize is how much of a file is read for a preview; longer files are
+// shown cut short. Binary files are shown as a hex dump of their first
+// MaxHexSize bytes, and tables up to MaxRows rows.
+const (
+	MaxSize    = 1 << 20
+	MaxHexSize = 64 << 10
+	MaxRows    = 5000
+)
+
+// Kinds of previews. KindText covers source code and plain text; files
+// previewed as text turn out to be KindBinary when they contain NUL bytes.
+const (
+	KindMarkdown = "markdown"
+	KindTable    = "table"
+	KindText     = "text"
+	KindImage    = "image"
+	KindVideo    = "video"
+	KindAudio    = "audio"
+	KindBinary   = "binary"
+)
+
+// Kind returns how the file called name is previewed, going by its name
+func Kind(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch ext {
+	case ".md", ".markdown":
+		return KindMarkdown
+	case ".csv", ".tsv":
+		return KindTable
+	}
+	mimeType := mime.TypeByExtension(ext)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return KindImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return KindVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return KindAudio
+	}
+	return KindText
+}
+
+// IsBinary reports whether data looks like the start of a binary file
+// rather than text: whether it has a NUL byte early on
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0
+}
+
+// Table splits a CSV file, or a TSV file going by name, into rows of up to
+// maxRows rows, reporting whether there were more. Rows may have different
+// numbers of fields.
+func Table(name string, src []byte, maxRows int) ([][]string, bool, error) {
+	r := csv.NewReader(bytes.NewReader(src))
+	if strings.EqualFold(path.Ext(name), ".tsv") {
+		r.Comma = '\t'
+	}
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, false, nil
+		}
+		if err != nil {
+			return rows, false, err
+		}
+		if len(rows) == maxRows {
+			return rows, true, nil
+		}
+		rows = append(rows, row)
+	}
+}
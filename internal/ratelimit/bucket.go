@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket: it fills at a steady rate up to its burst, and
+// every event takes tokens out of it
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket filling with rate tokens per second and
+// holding up to burst; a burst below 1 holds one second's worth
+func NewBucket(rate float64, burst int64) *Bucket {
+	b := float64(burst)
+	if burst < 1 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	return &Bucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// refill adds the tokens that came in since the last call. The caller
+// holds b.mu.
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// Take takes n tokens if the bucket holds them. Otherwise it takes none
+// and returns how long until it will.
+func (b *Bucket) Take(n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, b.delay(n - b.tokens)
+}
+
+// Wait takes n tokens, waiting until they have come in or ctx is done.
+// Tokens are taken on credit, so waiters are served in turn and n may
+// exceed the burst.
+func (b *Bucket) Wait(ctx context.Context, n float64) error {
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens -= n
+	wait := b.delay(-b.tokens)
+	b.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// full reports whether the bucket has filled up again, so forgetting it
+// changes nothing
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// delay is how long missing tokens take to come in
+func (b *Bucket) delay(missing float64) time.Duration {
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often buckets that have filled up again are forgotten
+const sweepEvery = time.Minute
+
+// Limiter limits the rate of requests per key, such as a client address or
+// a user name, with a bucket for each key. A nil Limiter limits nothing.
+type Limiter struct {
+	rate  float64
+	burst int64
+
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	swept   time.Time
+}
+
+// NewLimiter returns a limiter allowing each key rate requests per second,
+// and bursts of up to burst
+func NewLimiter(rate float64, burst int64) *Limiter {
+	return &Limiter{rate: rate, burst: burst, buckets: make(map[string]*Bucket), swept: time.Now()}
+}
+
+// Allow counts a request for key, and reports whether it may go ahead. If
+// not, it returns how long until the next one may.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.swept) >= sweepEvery {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		b = NewBucket(l.rate, l.burst)
+		l.buckets[key] = b
+	}
+	l.mu.Unlock()
+
+	return b.Take(1)
+}
+
+// Wrap returns a handler that answers requests over the limit with 429 Too
+// Many Requests and a Retry-After header, and passes the others to next.
+// key names what a request is counted against; requests it returns "" for
+// are not counted.
+func (l *Limiter) Wrap(next http.Handler, key func(r *http.Request) string) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := key(r)
+		if k == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.Allow(k); !ok {
+			retry := int(math.Ceil(wait.Seconds()))
+			log.Printf("Rate limited %s %s for %s, retry in %ds", r.Method, r.URL.Path, k, retry)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, retry)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
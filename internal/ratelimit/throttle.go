@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// maxChunk is the most a throttled writer writes at once, so that slow
+// rates still send a steady stream rather than occasional bursts
+const maxChunk = 32 << 10
+
+// Throttle caps the bandwidth of downloads, across the whole server, per
+// mount and per connection. A nil Throttle caps nothing.
+type Throttle struct {
+	global  *Bucket
+	perConn int64
+	burst   int64
+	mounts  map[string]*Bucket
+}
+
+// NewThrottle returns a throttle sending at most global bytes per second
+// in all, perConn bytes per second on each connection, and mounts[name]
+// bytes per second from each mount. Zero rates are not capped. Each cap
+// may be exceeded by burst bytes after a quiet spell; a burst of 0 means
+// one second's worth. With nothing to cap it returns nil.
+func NewThrottle(global, perConn, burst int64, mounts map[string]int64) *Throttle {
+	t := &Throttle{perConn: perConn, burst: burst, mounts: make(map[string]*Bucket)}
+	if global > 0 {
+		t.global = NewBucket(float64(global), burst)
+	}
+	for name, rate := range mounts {
+		if rate > 0 {
+			t.mounts[name] = NewBucket(float64(rate), burst)
+		}
+	}
+	if t.global == nil && perConn <= 0 && len(t.mounts) == 0 {
+		return nil
+	}
+	return t
+}
+
+type connKey struct{}
+
+// ConnContext gives each connection its own bucket. It is meant for
+// http.Server's ConnContext.
+func (t *Throttle) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	if t == nil || t.perConn <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connKey{}, NewBucket(float64(t.perConn), t.burst))
+}
+
+// Writer returns a writer that sends the response to r no faster than the
+// caps for the mount it is served from and the connection it came in on
+// allow
+func (t *Throttle) Writer(w http.ResponseWriter, r *http.Request, mount string) http.ResponseWriter {
+	if t == nil {
+		return w
+	}
+	var buckets []*Bucket
+	for _, b := range []*Bucket{t.global, t.mounts[mount]} {
+		if b != nil {
+			buckets = append(buckets, b)
+		}
+	}
+	if b, ok := r.Context().Value(connKey{}).(*Bucket); ok {
+		buckets = append(buckets, b)
+	}
+	if len(buckets) == 0 {
+		return w
+	}
+
+	chunk := maxChunk
+	for _, b := range buckets {
+		chunk = min(chunk, int(b.burst))
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: r.Context(), buckets: buckets, chunk: chunk}
+}
+
+// throttledWriter waits for every bucket before writing each chunk. It
+// deliberately has no ReadFrom method, which would let io.Copy hand the
+// file to the connection in one go.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*Bucket
+	chunk   int
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), tw.chunk)
+		for _, b := range tw.buckets {
+			if err := b.Wait(tw.ctx, float64(n)); err != nil {
+				return written, err
+			}
+		}
+		n, err := tw.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
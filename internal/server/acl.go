@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"fileserv/internal/auth"
+	"fileserv/internal/config"
+	"fileserv/internal/models"
+)
+
+// BuildACL checks the access rules of a configuration file against the
+// mounts and returns the ACL they make up. Every rule must be about a path
+// some mount serves, or the root of them all.
+func BuildACL(rules []config.ACLRule, dirs []models.Directory) (*auth.ACL, error) {
+	var out []auth.Rule
+	for _, r := range rules {
+		if !served(r.Path, dirs) {
+			return nil, fmt.Errorf("%s: no mount serves %s", r.Source, r.Path)
+		}
+		access, err := auth.ParseAccess(r.Access)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Source, err)
+		}
+		out = append(out, auth.Rule{Path: r.Path, Users: r.Users, Groups: r.Groups, Access: access})
+	}
+	return auth.NewACL(out), nil
+}
+
+// served reports whether urlPath is the root or lies in one of dirs
+func served(urlPath string, dirs []models.Directory) bool {
+	if urlPath == "/" {
+		return true
+	}
+	for _, dir := range dirs {
+		prefix := "/" + dir.Name
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
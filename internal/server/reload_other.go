@@ -0,0 +1,6 @@
+//go:build plan9 || windows
+
+package server
+
+// notifyReload is a no-op on platforms without SIGHUP
+func notifyReload(reload func()) {}
@@ -0,0 +1,20 @@
+//go:build !plan9 && !windows
+
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyReload calls reload every time the process receives SIGHUP
+func notifyReload(reload func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			reload()
+		}
+	}()
+}
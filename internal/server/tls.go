@@ -0,0 +1,265 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// CertStore holds the certificate presented to TLS clients. The certificate
+// can be replaced at any time with Reload; handshakes already in progress and
+// established connections keep the certificate they started with.
+type CertStore struct {
+	load func() (*tls.Certificate, error)
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// NewCertStore loads a certificate and key from PEM files
+func NewCertStore(certFile, keyFile string) (*CertStore, error) {
+	return newCertStore(func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate %s: %w", certFile, err)
+		}
+		return &cert, nil
+	})
+}
+
+// NewSelfSignedCertStore serves a self-signed certificate cached in dir,
+// generating a new one when none exists, it expired, or it does not cover
+// every address the machine currently has
+func NewSelfSignedCertStore(dir string) (*CertStore, error) {
+	return newCertStore(func() (*tls.Certificate, error) {
+		certFile, keyFile, err := selfSignedCertificate(dir)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate %s: %w", certFile, err)
+		}
+		return &cert, nil
+	})
+}
+
+func newCertStore(load func() (*tls.Certificate, error)) (*CertStore, error) {
+	cs := &CertStore{load: load}
+	if err := cs.Reload(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// Reload loads the certificate again. On failure the previous certificate
+// stays in use.
+func (cs *CertStore) Reload() error {
+	cert, err := cs.load()
+	if err != nil {
+		return err
+	}
+	cs.cert.Store(cert)
+	return nil
+}
+
+// TLSConfig returns a TLS configuration serving the store's certificate
+func (cs *CertStore) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cs.cert.Load(), nil
+		},
+	}
+}
+
+// ReloadOnSignal reloads the certificate whenever the process receives
+// SIGHUP, on platforms that have it
+func (cs *CertStore) ReloadOnSignal() {
+	notifyReload(func() {
+		if err := cs.Reload(); err != nil {
+			log.Printf("Error reloading TLS certificate: %v", err)
+			return
+		}
+		log.Printf("Reloaded TLS certificate")
+	})
+}
+
+// RedirectHandler redirects every request to the same URL over HTTPS. The
+// port of httpsAddr is used unless it is the default 443.
+func RedirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+		if port != "" && port != "443" {
+			host += ":" + port
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
+
+const (
+	selfSignedCertName = "selfsigned-cert.pem"
+	selfSignedKeyName  = "selfsigned-key.pem"
+	selfSignedLifetime = 365 * 24 * time.Hour
+)
+
+// selfSignedCertificate returns the cached self-signed certificate files in
+// dir, regenerating them when they are unusable
+func selfSignedCertificate(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, selfSignedCertName)
+	keyFile = filepath.Join(dir, selfSignedKeyName)
+
+	hosts := localHosts()
+	if cachedCertificateValid(certFile, keyFile, hosts) {
+		return certFile, keyFile, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", fmt.Errorf("creating certificate directory: %w", err)
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return "", "", fmt.Errorf("generating self-signed certificate: %w", err)
+	}
+	if err := writeFileAtomic(keyFile, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+	if err := writeFileAtomic(certFile, certPEM, 0o644); err != nil {
+		return "", "", err
+	}
+
+	log.Printf("Generated self-signed certificate %s for %v", certFile, hosts)
+	return certFile, keyFile, nil
+}
+
+// cachedCertificateValid reports whether the cached certificate can still be
+// used: it parses, has not expired and names every host in hosts
+func cachedCertificateValid(certFile, keyFile string, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().Add(24 * time.Hour).After(cert.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// localHosts lists the names and addresses the certificate should cover
+func localHosts() []string {
+	hosts := []string{"localhost"}
+	if name, err := os.Hostname(); err == nil && name != "" && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return append(hosts, "127.0.0.1", "::1")
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		hosts = append(hosts, ipNet.IP.String())
+	}
+	return hosts
+}
+
+func generateSelfSigned(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"fileserv self-signed"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to name and renames
+// it into place, so readers never see a partially written file
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -4,41 +4,250 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"fileserv/internal/config"
 	"fileserv/internal/models"
+	"fileserv/internal/netfilter"
 )
 
-// ValidateDirectories validates and converts directory paths to Directory structs
+// candidate is a validated mount whose URL name may not be settled yet
+type candidate struct {
+	dir      models.Directory
+	explicit bool
+	source   string
+}
+
+// ValidateDirectories validates and converts directory paths to Directory structs.
+// A path may be written as name=path to choose the mount's URL name.
 func ValidateDirectories(paths []string) ([]models.Directory, error) {
-	var dirs []models.Directory
-	seen := make(map[string]bool)
+	return BuildMounts(nil, paths, false)
+}
 
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
+// BuildMounts validates the mounts declared in a configuration file together
+// with the directories given on the command line and assigns every mount a
+// unique URL name. Command line directories are read-only unless writable
+// is set.
+func BuildMounts(mounts []config.Mount, paths []string, writable bool) ([]models.Directory, error) {
+	var candidates []candidate
+
+	for _, m := range mounts {
+		absPath, err := validateDir(m.Path)
 		if err != nil {
-			return nil, fmt.Errorf("invalid path %s: %w", path, err)
+			return nil, fmt.Errorf("%s: %w", m.Source, err)
 		}
 
-		// Check for duplicates
-		if seen[absPath] {
-			continue
+		// Users with inline credentials are implicitly allowed in
+		var creds map[string]string
+		users := slices.Clone(m.Users)
+		if len(m.Auth) > 0 {
+			creds = make(map[string]string, len(m.Auth))
+			for _, entry := range m.Auth {
+				user, pass, _ := strings.Cut(entry, ":")
+				creds[user] = pass
+				if !slices.Contains(users, user) {
+					users = append(users, user)
+				}
+			}
 		}
-		seen[absPath] = true
 
-		info, err := os.Stat(absPath)
+		// The configuration was checked when it was loaded
+		allow, _ := netfilter.ParsePrefixes(m.Allow)
+		deny, _ := netfilter.ParsePrefixes(m.Deny)
+
+		candidates = append(candidates, candidate{
+			dir: models.Directory{
+				Name:           m.Name,
+				Path:           absPath,
+				ReadOnly:       m.ReadOnly,
+				Hidden:         m.Hidden,
+				DisableListing: !m.Listing,
+				DisableTrash:   !m.Trash,
+				DisableReadme:  !m.Readme,
+				Exclude:        m.Exclude,
+				Index:          m.Index || m.FullText,
+				FullText:       m.FullText,
+				Credentials:    creds,
+				Users:          users,
+				Groups:         m.Groups,
+				Allow:          allow,
+				Deny:           deny,
+				Bandwidth:      m.Bandwidth,
+			},
+			explicit: m.Name != "",
+			source:   m.Source,
+		})
+	}
+
+	seen := make(map[string]bool)
+	for _, arg := range paths {
+		name, path := splitAlias(arg)
+
+		absPath, err := validateDir(config.ExpandTilde(path))
 		if err != nil {
-			return nil, fmt.Errorf("cannot access %s: %w", path, err)
+			return nil, err
 		}
 
-		if !info.IsDir() {
-			return nil, fmt.Errorf("%s is not a directory", path)
+		// Check for duplicates
+		key := name + "=" + absPath
+		if seen[key] {
+			continue
 		}
+		seen[key] = true
 
-		dirs = append(dirs, models.Directory{
-			Name: filepath.Base(absPath),
-			Path: absPath,
+		candidates = append(candidates, candidate{
+			dir: models.Directory{
+				Name:     name,
+				Path:     absPath,
+				ReadOnly: !writable,
+			},
+			explicit: name != "",
+			source:   fmt.Sprintf("argument %q", arg),
 		})
 	}
 
+	if err := assignNames(candidates); err != nil {
+		return nil, err
+	}
+
+	dirs := make([]models.Directory, len(candidates))
+	for i, c := range candidates {
+		dirs[i] = c.dir
+	}
 	return dirs, nil
 }
+
+// splitAlias splits a name=path argument. Arguments whose part before the
+// first '=' is not a valid mount name are treated as plain paths, so
+// "/srv/a=b" still refers to a directory; use "./a=b" for a relative one.
+func splitAlias(arg string) (name, path string) {
+	name, path, ok := strings.Cut(arg, "=")
+	if !ok || config.ValidateMountName(name) != nil {
+		return "", arg
+	}
+	return name, path
+}
+
+// assignNames checks explicit names for conflicts and derives names for the
+// remaining mounts. A derived name starts as the directory's base name; while
+// it collides with another mount it is prefixed with further parent
+// directories ("a-docs", "b-docs"), and as a last resort numbered ("docs-2").
+// The result depends only on the paths and their order, so names are stable
+// across restarts.
+func assignNames(candidates []candidate) error {
+	explicit := make(map[string]int)
+	for i, c := range candidates {
+		if !c.explicit {
+			continue
+		}
+		if err := config.ValidateMountName(c.dir.Name); err != nil {
+			return fmt.Errorf("%s: %w", c.source, err)
+		}
+		if j, ok := explicit[c.dir.Name]; ok {
+			return fmt.Errorf("%s: mount name %q is already used by %s", c.source, c.dir.Name, candidates[j].source)
+		}
+		explicit[c.dir.Name] = i
+	}
+
+	depth := make(map[int]int)
+	for i, c := range candidates {
+		if !c.explicit {
+			depth[i] = 1
+			candidates[i].dir.Name = derivedName(c.dir.Path, 1)
+		}
+	}
+
+	for {
+		users := make(map[string][]int)
+		for i := range depth {
+			users[candidates[i].dir.Name] = append(users[candidates[i].dir.Name], i)
+		}
+
+		changed := false
+		for name, idx := range users {
+			if _, clash := explicit[name]; len(idx) < 2 && !clash {
+				continue
+			}
+			for _, i := range idx {
+				next := derivedName(candidates[i].dir.Path, depth[i]+1)
+				if next != candidates[i].dir.Name {
+					depth[i]++
+					candidates[i].dir.Name = next
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	// Parent prefixes ran out: number the remaining collisions in order
+	taken := make(map[string]bool)
+	for name := range explicit {
+		taken[name] = true
+	}
+	for i, c := range candidates {
+		if c.explicit {
+			continue
+		}
+		name := c.dir.Name
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s-%d", c.dir.Name, n)
+		}
+		taken[name] = true
+		candidates[i].dir.Name = name
+	}
+
+	return nil
+}
+
+// derivedName joins the last depth components of path with dashes
+func derivedName(path string, depth int) string {
+	var parts []string
+	for len(parts) < depth {
+		base := filepath.Base(path)
+		if base == path || base == string(filepath.Separator) || base == "." {
+			break
+		}
+		parts = append([]string{base}, parts...)
+		path = filepath.Dir(path)
+	}
+	if len(parts) == 0 {
+		return "root"
+	}
+
+	name := strings.Join(parts, "-")
+	if config.ValidateMountName(name) != nil {
+		name = strings.Map(func(c rune) rune {
+			if c < 0x20 || c == 0x7f || strings.ContainsRune("/\\?#%~", c) {
+				return '_'
+			}
+			return c
+		}, name)
+		if config.ValidateMountName(name) != nil {
+			name = "_" + name
+		}
+	}
+	return name
+}
+
+// validateDir resolves path to an absolute path and checks it is a directory
+func validateDir(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %s: %w", path, err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot access %s: %w", path, err)
+	}
+
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", path)
+	}
+
+	return absPath, nil
+}
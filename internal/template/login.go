@@ -0,0 +1,101 @@
+package template
+
+import (
+	"html/template"
+	"io"
+
+	"fileserv/internal/models"
+)
+
+// login-styles lays out the small forms visitors fill in: the login page,
+// the password prompt of share links and the upload form of drop boxes
+var _ = template.Must(tmpl.New("login-styles").Parse(`
+    <style>
+        .login-card {
+            max-width: 380px;
+            margin: 10vh auto 0;
+            background: var(--bg-secondary);
+            border: 1px solid var(--border-color);
+            border-radius: 8px;
+            padding: 2rem;
+            box-shadow: 0 2px 4px var(--shadow);
+        }
+
+        .login-card h1 {
+            margin-bottom: 1.5rem;
+        }
+
+        .login-card label {
+            display: block;
+            font-size: 0.9rem;
+            color: var(--text-secondary);
+            margin-bottom: 0.25rem;
+        }
+
+        .login-card input {
+            width: 100%;
+            background: var(--bg-primary);
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
+            border-radius: 6px;
+            padding: 0.6rem 0.75rem;
+            font-size: 1rem;
+            margin-bottom: 1rem;
+        }
+
+        .login-card input:focus {
+            outline: none;
+            border-color: var(--accent-color);
+            box-shadow: 0 0 0 3px rgba(13, 110, 253, 0.1);
+        }
+
+        .login-card .button {
+            width: 100%;
+            padding: 0.6rem;
+            font-size: 1rem;
+        }
+
+        .dropbox-limits {
+            font-size: 0.85rem;
+            color: var(--text-secondary);
+            margin-bottom: 1rem;
+        }
+
+        .login-error {
+            color: #dc3545;
+            margin-bottom: 1rem;
+            font-size: 0.9rem;
+        }
+    </style>
+`))
+
+var _ = template.Must(tmpl.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign in · {{.Realm}}</title>
+    {{template "styles"}}
+    {{template "login-styles"}}
+</head>
+<body>
+    <div class="container">
+        <form class="login-card" method="post" action="/_fileserv/login">
+            <h1>🔒 {{.Realm}}</h1>
+            {{if .Error}}<div class="login-error">{{.Error}}</div>{{end}}
+            <input type="hidden" name="next" value="{{.Next}}">
+            <label for="username">Username</label>
+            <input id="username" name="username" value="{{.Username}}" autocomplete="username" required {{if not .Username}}autofocus{{end}}>
+            <label for="password">Password</label>
+            <input id="password" name="password" type="password" autocomplete="current-password" required {{if .Username}}autofocus{{end}}>
+            <button type="submit" class="button button-primary">Sign in</button>
+        </form>
+    </div>
+</body>
+</html>
+`))
+
+// RenderLogin renders the login form
+func RenderLogin(w io.Writer, data models.LoginData) error {
+	return tmpl.ExecuteTemplate(w, "login", data)
+}
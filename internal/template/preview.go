@@ -0,0 +1,81 @@
+package template
+
+import (
+	"html/template"
+	"io"
+
+	"fileserv/internal/models"
+)
+
+var _ = template.Must(tmpl.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.File.Name}}</title>
+    {{template "styles"}}
+</head>
+<body>
+    <div class="container">
+        <header>
+            <div class="header-top">
+                <a href="{{parent .File.Path}}" class="back-link">← Back to {{parent .File.Path}}</a>
+                {{template "user" .}}
+            </div>
+            <h1>{{.File.Name}}</h1>
+            <div class="preview-bar">
+                <span class="file-meta">
+                    {{formatSize .File.Size}} · {{.File.ModTime.Format "2006-01-02 15:04"}}{{if .Language}} · {{.Language}}{{end}}
+                </span>
+                <a href="{{.File.Path}}" class="button">Raw</a>
+                <a href="{{.File.Path}}?download" class="button">⬇️ Download</a>
+            </div>
+        </header>
+
+        {{if .Truncated}}
+        <div class="notice">
+            {{if eq .Kind "table"}}Only the first {{.Limit}} rows are shown.{{else}}Only the first {{formatSize .Limit}} is shown.{{end}}
+            Download the file to see all of it.
+        </div>
+        {{end}}
+
+        {{if eq .Kind "markdown"}}
+        <article class="markdown-body">{{.HTML}}</article>
+        {{else if eq .Kind "table"}}
+        <div class="preview-table">
+            <table>
+                {{range $i, $row := .Rows}}
+                <tr>{{range $row}}{{if eq $i 0}}<th>{{.}}</th>{{else}}<td>{{.}}</td>{{end}}{{end}}</tr>
+                {{end}}
+            </table>
+        </div>
+        {{else if eq .Kind "image"}}
+        <div class="preview-media"><img src="{{.File.Path}}" alt="{{.File.Name}}"></div>
+        {{else if eq .Kind "video"}}
+        <div class="preview-media"><video src="{{.File.Path}}" controls preload="metadata"></video></div>
+        {{else if eq .Kind "audio"}}
+        <div class="preview-media"><audio src="{{.File.Path}}" controls preload="metadata"></audio></div>
+        {{else if eq .Kind "binary"}}
+        <pre class="preview-hex">{{.Hex}}</pre>
+        {{else if .Lines}}
+        <div class="preview-code">
+            <table>
+                {{range $i, $line := .Lines}}
+                <tr id="L{{inc $i}}"><td class="line-number"><a href="#L{{inc $i}}">{{inc $i}}</a></td><td><code>{{range $line}}{{if .Class}}<span class="tok-{{.Class}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{end}}</code></td></tr>
+                {{end}}
+            </table>
+        </div>
+        {{else}}
+        <div class="empty-state">
+            <p>📄 This file is empty</p>
+        </div>
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+// RenderPreview renders the preview page of a file
+func RenderPreview(w io.Writer, data models.PreviewData) error {
+	return tmpl.ExecuteTemplate(w, "preview", data)
+}
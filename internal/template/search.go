@@ -0,0 +1,79 @@
+package template
+
+import (
+	"html/template"
+	"io"
+
+	"fileserv/internal/models"
+)
+
+var _ = template.Must(tmpl.New("search").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{if .Query}}{{.Query}} · {{end}}Search</title>
+    {{template "styles"}}
+</head>
+<body>
+    <div class="container">
+        <header>
+            <div class="header-top">
+                <a href="{{if .In}}{{.In}}{{else}}/{{end}}" class="back-link">← Back to {{if .In}}{{.In}}{{else}}all directories{{end}}</a>
+                {{template "user" .}}
+            </div>
+            <h1>🔍 Search</h1>
+            {{if .Searched}}
+            <div class="breadcrumb">
+                {{len .Results}} result{{if ne (len .Results) 1}}s{{end}} for “{{.Query}}”{{if .In}} in {{.In}}{{end}}
+            </div>
+            {{end}}
+            {{template "search-form" .SearchForm}}
+        </header>
+
+        {{if .TimedOut}}
+        <div class="notice">The search took too long and was stopped; these are the results found so far.</div>
+        {{else if .Truncated}}
+        <div class="notice">Only the first {{.Limit}} results are shown; narrow the search to see the rest.</div>
+        {{end}}
+
+        {{if .Matches}}
+        <div class="file-list">
+            {{range .Matches}}
+            <div class="file-item content-match">
+                <div class="match-file">
+                    {{template "file-link" .File}}
+                    <a href="{{parent .File.Path}}" class="file-where" title="{{parent .File.Path}}">{{parent .File.Path}}</a>
+                </div>
+                <ol class="match-lines">
+                    {{$file := .File}}
+                    {{range .Lines}}
+                    <li><a href="{{$file.Path}}?view#L{{.Number}}" class="line-number">{{.Number}}</a><code>{{range .Parts}}{{if .Match}}<mark>{{.Text}}</mark>{{else}}{{.Text}}{{end}}{{end}}</code></li>
+                    {{end}}
+                </ol>
+            </div>
+            {{end}}
+        </div>
+        {{else if .Results}}
+        <div class="file-list">
+            {{range .Results}}
+            <div class="file-item">
+                {{template "file-link" .}}
+                <a href="{{parent .Path}}" class="file-where" title="{{parent .Path}}">{{parent .Path}}</a>
+            </div>
+            {{end}}
+        </div>
+        {{else if .Searched}}
+        <div class="empty-state">
+            <p>🔍 Nothing matches “{{.Query}}”</p>
+        </div>
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+// RenderSearch renders the search page
+func RenderSearch(w io.Writer, data models.SearchData) error {
+	return tmpl.ExecuteTemplate(w, "search", data)
+}
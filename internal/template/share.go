@@ -0,0 +1,329 @@
+package template
+
+import (
+	"html/template"
+	"io"
+
+	"fileserv/internal/models"
+)
+
+// share is what a share link opens: a password prompt, the shared file
+// with a download button, a listing of the shared directory, or the upload
+// form of a drop box. It links nowhere outside the share.
+var _ = template.Must(tmpl.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="robots" content="noindex">
+    <title>{{.Name}}</title>
+    {{template "styles"}}
+    {{if or .NeedPassword .DropBox}}{{template "login-styles"}}{{end}}
+</head>
+<body>
+    <div class="container">
+        {{if .NeedPassword}}
+        <form class="login-card" method="post">
+            <h1>🔒 {{.Name}}</h1>
+            {{if .Error}}<div class="login-error">{{.Error}}</div>{{end}}
+            <label for="password">This link is protected by a password</label>
+            <input id="password" name="password" type="password" autocomplete="off" required autofocus>
+            <button type="submit" class="button button-primary">Open</button>
+        </form>
+        {{else if .DropBox}}
+        <form class="login-card" method="post" enctype="multipart/form-data">
+            <h1>📥 Send files to {{.Name}}</h1>
+            {{if .Received}}<div class="notice">✅ Thank you, {{.Received}} file{{if gt .Received 1}}s{{end}} received. You can send more below.</div>{{end}}
+            <label for="name">Your name (optional)</label>
+            <input id="name" name="name" maxlength="200" autocomplete="name">
+            <label for="email">Your email (optional)</label>
+            <input id="email" name="email" type="email" maxlength="200" autocomplete="email">
+            <label for="file">Files</label>
+            <input id="file" name="file" type="file" multiple required{{if .Types}} accept="{{range $i, $t := .Types}}{{if $i}},{{end}}{{$t}}{{end}}"{{end}}>
+            <div class="dropbox-limits">
+                {{if .MaxSize}}Up to {{formatSize .MaxSize}} per submission.{{end}}
+                {{if .Types}}Accepted: {{range $i, $t := .Types}}{{if $i}}, {{end}}{{$t}}{{end}}.{{end}}
+                Nothing on this server is shown to you, and what you send is only seen by its owner.
+                Open until {{.Expires.Local.Format "2006-01-02 15:04"}}.
+            </div>
+            <button type="submit" class="button button-primary">Send</button>
+        </form>
+        {{else}}
+        <header>
+            {{if .ParentURL}}<div class="header-top"><a href="{{.ParentURL}}" class="back-link">← Back</a></div>{{end}}
+            <h1>{{if .IsDir}}📁 {{.CurrentPath}}{{else}}📄 {{.Name}}{{end}}</h1>
+            <div class="breadcrumb">Shared with you until {{.Expires.Local.Format "2006-01-02 15:04"}}</div>
+        </header>
+
+        {{if not .IsDir}}
+        <div class="file-list">
+            <div class="file-item">
+                <div class="file-link">
+                    <div class="file-icon">📄</div>
+                    <div class="file-info">
+                        <div class="file-name">{{.File.Name}}</div>
+                        <div class="file-meta">{{.File.ModTime.Format "2006-01-02 15:04"}}</div>
+                    </div>
+                    <div class="file-size">{{formatSize .File.Size}}</div>
+                </div>
+            </div>
+        </div>
+        <div class="toolbar">
+            <a href="{{.DownloadURL}}" class="button button-primary">⬇️ Download</a>
+        </div>
+        {{else}}
+        <div class="toolbar">
+            <a href="?archive=zip" class="button">⬇️ Download ZIP</a>
+            <a href="?archive=tar.gz" class="button">⬇️ tar.gz</a>
+        </div>
+        {{if .Files}}
+        <div class="file-list">
+            <div class="file-item list-header">
+                {{range .SortLinks}}
+                <a href="{{.URL}}" class="sort-{{.Label}}{{if .Active}} active{{end}}">{{.Label}}{{if .Arrow}} {{.Arrow}}{{end}}</a>
+                {{end}}
+            </div>
+            {{range .Files}}
+            <div class="file-item">
+                <a href="{{.URL}}" class="file-link">
+                    <div class="file-icon">{{if .IsDir}}📁{{else}}📄{{end}}</div>
+                    <div class="file-info">
+                        <div class="file-name">{{.Name}}</div>
+                        <div class="file-meta">{{if .IsDir}}Directory{{else}}File{{end}}</div>
+                    </div>
+                    <div class="file-mtime">{{.ModTime.Format "2006-01-02 15:04"}}</div>
+                    <div class="file-size">{{if not .IsDir}}{{formatSize .Size}}{{end}}</div>
+                </a>
+            </div>
+            {{end}}
+        </div>
+        {{template "pager" .Pagination}}
+        {{else}}
+        <div class="empty-state">
+            <p>📭 This directory is empty</p>
+        </div>
+        {{end}}
+        {{end}}
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+// shares lists the active share links of the user, or of everyone for
+// admins, with buttons to copy and revoke them
+var _ = template.Must(tmpl.New("shares").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Share links</title>
+    {{template "styles"}}
+</head>
+<body>
+    <div class="container">
+        <header>
+            <div class="header-top">
+                <a href="/" class="back-link">← Back to all directories</a>
+                {{template "user" .}}
+            </div>
+            <h1>🔗 Share links</h1>
+            <div class="breadcrumb">{{if .All}}Every active share link on this server{{else}}The share links you have created that are still active{{end}}</div>
+        </header>
+
+        {{if .Shares}}
+        <div class="file-list">
+            {{range .Shares}}
+            <div class="file-item">
+                <div class="file-link">
+                    <div class="file-icon">{{if .DropBox}}📥{{else if .IsDir}}📁{{else}}📄{{end}}</div>
+                    <div class="file-info">
+                        <div class="file-name"><a href="{{.Path}}">{{.Path}}</a></div>
+                        <div class="file-meta">
+                            expires {{.Expires.Local.Format "2006-01-02 15:04"}}
+                            {{if .Password}}· 🔒 password{{end}}
+                            {{if .DropBox}}
+                            · drop box{{if .MaxSize}} up to {{formatSize .MaxSize}}{{end}}{{with .Types}} for {{range $i, $t := .}}{{if $i}}, {{end}}{{$t}}{{end}}{{end}}
+                            {{else}}
+                            · {{.Downloads}}{{if .MaxDownloads}} of {{.MaxDownloads}}{{end}} downloads
+                            {{end}}
+                            {{if $.All}}{{if .CreatedBy}}· by {{.CreatedBy}}{{end}}{{end}}
+                        </div>
+                    </div>
+                </div>
+                <div class="file-actions" data-id="{{.ID}}" data-url="{{.URL}}">
+                    <button type="button" data-op="copy" title="Copy link">📋</button>
+                    <button type="button" data-op="revoke" title="Revoke">❌</button>
+                </div>
+            </div>
+            {{end}}
+        </div>
+        {{else}}
+        <div class="empty-state">
+            <p>🔗 There are no active share links</p>
+        </div>
+        {{end}}
+    </div>
+    <script>
+    (function () {
+        document.querySelectorAll('.file-actions button').forEach(function (button) {
+            button.addEventListener('click', function () {
+                var actions = button.parentNode;
+                if (button.dataset.op === 'copy') {
+                    var url = window.location.origin + actions.dataset.url;
+                    navigator.clipboard.writeText(url).then(function () {
+                        button.textContent = '✅';
+                    }, function () {
+                        prompt('Share link:', url);
+                    });
+                    return;
+                }
+                if (!confirm('Revoke this share link? It stops working at once.')) return;
+                fetch('/_fileserv/shares/' + encodeURIComponent(actions.dataset.id), {method: 'DELETE'}).then(function (res) {
+                    if (res.ok) return window.location.reload();
+                    return res.json().then(function (e) {
+                        alert(e.error || res.statusText);
+                    }, function () {
+                        alert(res.status + ' ' + res.statusText);
+                    });
+                }, function (err) {
+                    alert(err.message);
+                });
+            });
+        });
+    })();
+    </script>
+</body>
+</html>
+`))
+
+// share-dialog creates share links for the entries of a listing, and for
+// the directory itself from the toolbar
+var _ = template.Must(tmpl.New("share-dialog").Parse(`
+    <dialog class="share-dialog" id="share-dialog">
+        <form method="dialog" id="share-form">
+            <h2><span id="share-title">🔗 Share</span> <span id="share-name"></span></h2>
+            <label for="share-expires">Expires after</label>
+            <select id="share-expires" name="expires_in">
+                <option value="1h">1 hour</option>
+                <option value="24h">1 day</option>
+                <option value="7d" selected>7 days</option>
+                <option value="30d">30 days</option>
+                <option value="90d">90 days</option>
+                <option value="365d">1 year</option>
+            </select>
+            <label for="share-password">Password (optional)</label>
+            <input id="share-password" name="password" type="password" autocomplete="new-password">
+            <div class="share-downloads">
+                <label for="share-max">Maximum downloads (0 for no limit)</label>
+                <input id="share-max" name="max_downloads" type="number" min="0" value="0">
+            </div>
+            <div class="share-dropbox" hidden>
+                <label for="share-size">Largest upload, such as 100MB (empty for no limit)</label>
+                <input id="share-size" name="max_size">
+                <label for="share-types">Accepted types, such as .pdf, image/* (empty for any)</label>
+                <input id="share-types" name="types">
+            </div>
+            <div class="share-result" id="share-result" hidden>
+                <input id="share-url" readonly aria-label="Share link">
+                <button type="button" class="button" id="share-copy">📋 Copy</button>
+            </div>
+            <div class="share-error" id="share-error" hidden></div>
+            <div class="share-buttons">
+                <a href="/_fileserv/shares">All my share links</a>
+                <button type="button" class="button" id="share-close">Close</button>
+                <button type="submit" class="button button-primary" id="share-create">Create link</button>
+            </div>
+        </form>
+    </dialog>
+    <script>
+    (function () {
+        var dialog = document.getElementById('share-dialog');
+        var form = document.getElementById('share-form');
+        var result = document.getElementById('share-result');
+        var url = document.getElementById('share-url');
+        var error = document.getElementById('share-error');
+        var create = document.getElementById('share-create');
+        var target, dropBox;
+
+        function open(path, name, asDropBox) {
+            target = path;
+            dropBox = asDropBox;
+            document.getElementById('share-title').textContent = dropBox ? '📥 Drop box for' : '🔗 Share';
+            document.getElementById('share-name').textContent = name;
+            form.querySelector('.share-downloads').hidden = dropBox;
+            form.querySelector('.share-dropbox').hidden = !dropBox;
+            form.reset();
+            result.hidden = error.hidden = true;
+            create.disabled = false;
+            dialog.showModal();
+        }
+
+        ['share-folder', 'drop-box'].forEach(function (id) {
+            var button = document.getElementById(id);
+            if (!button) return;
+            button.addEventListener('click', function () {
+                var current = decodeURIComponent(window.location.pathname).replace(/\/+$/, '');
+                open(current, current.slice(current.lastIndexOf('/') + 1), id === 'drop-box');
+            });
+        });
+        document.querySelectorAll('.file-actions .share-button').forEach(function (button) {
+            button.addEventListener('click', function () {
+                open(button.parentNode.dataset.path, button.parentNode.dataset.name, false);
+            });
+        });
+        document.getElementById('share-close').addEventListener('click', function () {
+            dialog.close();
+        });
+        document.getElementById('share-copy').addEventListener('click', function () {
+            url.select();
+            navigator.clipboard.writeText(url.value).catch(function () {
+                document.execCommand('copy');
+            });
+        });
+
+        form.addEventListener('submit', function (e) {
+            e.preventDefault();
+            create.disabled = true;
+            fetch('/_fileserv/shares', {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json', 'Accept': 'application/json'},
+                body: JSON.stringify(dropBox ? {
+                    path: target,
+                    drop_box: true,
+                    expires_in: form.expires_in.value,
+                    password: form.password.value,
+                    max_size: form.max_size.value.trim(),
+                    types: form.types.value.split(',').map(function (t) { return t.trim(); }).filter(Boolean)
+                } : {
+                    path: target,
+                    expires_in: form.expires_in.value,
+                    password: form.password.value,
+                    max_downloads: parseInt(form.max_downloads.value, 10) || 0
+                })
+            }).then(function (res) {
+                return res.json().then(function (body) {
+                    if (!res.ok) throw new Error(body.error || res.statusText);
+                    url.value = window.location.origin + body.url;
+                    result.hidden = false;
+                    url.select();
+                });
+            }).catch(function (err) {
+                error.textContent = err.message;
+                error.hidden = false;
+                create.disabled = false;
+            });
+        });
+    })();
+    </script>
+`))
+
+// RenderShare renders the page a share link opens
+func RenderShare(w io.Writer, data models.ShareData) error {
+	return tmpl.ExecuteTemplate(w, "share", data)
+}
+
+// RenderShares renders the list of share links
+func RenderShares(w io.Writer, data models.SharesData) error {
+	return tmpl.ExecuteTemplate(w, "shares", data)
+}
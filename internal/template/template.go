@@ -4,18 +4,317 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"path"
 
 	"fileserv/internal/models"
 )
 
 var tmpl = template.Must(template.New("listing").Funcs(template.FuncMap{
 	"formatSize": formatSize,
+	"parent":     path.Dir,
+	"inc":        func(i int) int { return i + 1 },
 }).Parse(`<!DOCTYPE html>
 <html lang="en">
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>{{if .IsRoot}}File Server{{else}}{{.CurrentPath}}{{end}}</title>
+    {{template "styles"}}
+</head>
+<body>
+    <div class="container">
+        <header>
+            <div class="header-top">
+                {{if not .IsRoot}}<a href="/" class="back-link">← Back to all directories</a>{{end}}
+                {{template "user" .}}
+            </div>
+            {{if .IsRoot}}
+                <h1>📁 File Server</h1>
+                <div class="breadcrumb">Select a directory to browse</div>
+            {{else}}
+                <h1>{{.CurrentPath}}</h1>
+            {{end}}
+            {{template "search-form" .Search}}
+        </header>
+
+        {{if .IsRoot}}
+            <div class="directory-selector">
+                {{range .Directories}}
+                <a href="/{{.Name}}" class="directory-card">
+                    <div class="directory-card-icon">📂</div>
+                    <div class="directory-card-name">{{.Name}}</div>
+                    <div class="directory-card-path">{{.Path}}</div>
+                </a>
+                {{end}}
+            </div>
+            {{if .Indexes}}
+            <div class="index-status">
+                <h2>File name indexes</h2>
+                <div class="file-list">
+                    {{range .Indexes}}
+                    <div class="file-item">
+                        <div class="file-info">
+                            <div class="file-name">{{.Mount}}</div>
+                            <div class="file-meta">
+                                {{.Entries}} entries ·
+                                {{if .LastScan.IsZero}}not scanned yet{{else}}last scan {{.LastScan.Local.Format "2006-01-02 15:04"}}{{end}}
+                                {{if .Scanning}}· scanning now{{end}}
+                                · {{if .Watching}}watching for changes{{else}}rescans only{{end}}
+                                {{if .TextFiles}}· {{.TextFiles}} files indexed by content{{end}}
+                            </div>
+                        </div>
+                    </div>
+                    {{end}}
+                </div>
+            </div>
+            {{end}}
+        {{else}}
+            {{if gt (len .Directories) 1}}
+            <div class="directory-nav">
+                <label for="dir-select">Switch directory: </label>
+                <select id="dir-select" onchange="window.location.href='/' + this.value">
+                    {{range .Directories}}
+                    <option value="{{.Name}}">{{.Name}}</option>
+                    {{end}}
+                </select>
+            </div>
+            {{end}}
+
+            {{with .Description}}<div class="description">{{template "readme" .}}</div>{{end}}
+
+            {{if .Writable}}{{template "upload" .}}{{end}}
+
+            <div class="toolbar">
+                <form class="filter-form" method="get">
+                    <input type="search" name="filter" value="{{.Filter}}" placeholder="Filter, e.g. *.iso" aria-label="Filter">
+                </form>
+                <a href="{{.LayoutURL}}" class="button">{{if .Grid}}☰ List{{else}}▦ Grid{{end}}</a>
+                <a href="?archive=zip" class="button archive-link" data-format="zip">⬇️ Download ZIP</a>
+                <a href="?archive=tar.gz" class="button archive-link" data-format="tar.gz">⬇️ tar.gz</a>
+                {{if .CanShare}}<button type="button" class="button" id="share-folder">🔗 Share folder</button>{{end}}
+                {{if .CanDropBox}}<button type="button" class="button" id="drop-box">📥 Drop box</button>{{end}}
+                {{if .Writable}}
+                {{if .TrashURL}}<a href="{{.TrashURL}}" class="button">🗑️ Trash</a>{{end}}
+                <button type="button" class="button" id="new-folder">📁 New folder</button>
+                {{end}}
+            </div>
+            {{template "archive"}}
+            {{if .Writable}}{{template "ops" .}}{{end}}
+            {{if .CanShare}}{{template "share-dialog"}}{{end}}
+
+            {{if and .Files .Grid}}
+            <div class="grid-sort">
+                Sort by
+                {{range .SortLinks}}
+                <a href="{{.URL}}"{{if .Active}} class="active"{{end}}>{{.Label}}{{if .Arrow}} {{.Arrow}}{{end}}</a>
+                {{end}}
+            </div>
+            <div class="file-grid">
+                {{range .Files}}
+                <div class="grid-item">
+                    <input type="checkbox" class="file-select" value="{{.Name}}" aria-label="Select {{.Name}}">
+                    <a href="{{.Path}}" class="grid-link"{{if .Thumbnail}} data-lightbox data-name="{{.Name}}"{{end}}>
+                        <div class="grid-thumb">
+                            {{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="" loading="lazy" onerror="this.parentNode.textContent = '🖼️'">{{else if .IsDir}}📁{{else}}📄{{end}}
+                        </div>
+                        <div class="grid-name" title="{{.Name}}">{{.Name}}</div>
+                        <div class="file-meta">{{if .IsDir}}Directory{{else}}{{formatSize .Size}}{{end}}</div>
+                    </a>
+                    {{if or $.Writable $.CanShare}}
+                    <div class="file-actions" data-path="{{.Path}}" data-name="{{.Name}}">
+                        {{if $.CanShare}}<button type="button" class="share-button" title="Share">🔗</button>{{end}}
+                        {{if $.Writable}}
+                        <button type="button" data-op="rename" title="Rename">✏️</button>
+                        <button type="button" data-op="move" title="Move">📦</button>
+                        <button type="button" data-op="copy" title="Copy">📑</button>
+                        <button type="button" data-op="delete" title="Delete">🗑️</button>
+                        {{end}}
+                    </div>
+                    {{end}}
+                </div>
+                {{end}}
+            </div>
+            {{template "lightbox"}}
+            {{template "pager" .Pagination}}
+            {{else if .Files}}
+            <div class="file-list">
+                <div class="file-item list-header">
+                    {{range .SortLinks}}
+                    <a href="{{.URL}}" class="sort-{{.Label}}{{if .Active}} active{{end}}">{{.Label}}{{if .Arrow}} {{.Arrow}}{{end}}</a>
+                    {{end}}
+                </div>
+                {{range .Files}}
+                <div class="file-item">
+                    <input type="checkbox" class="file-select" value="{{.Name}}" aria-label="Select {{.Name}}">
+                    {{template "file-link" .}}
+                    {{if or $.Writable $.CanShare}}
+                    <div class="file-actions" data-path="{{.Path}}" data-name="{{.Name}}">
+                        {{if $.CanShare}}<button type="button" class="share-button" title="Share">🔗</button>{{end}}
+                        {{if $.Writable}}
+                        <button type="button" data-op="rename" title="Rename">✏️</button>
+                        <button type="button" data-op="move" title="Move">📦</button>
+                        <button type="button" data-op="copy" title="Copy">📑</button>
+                        <button type="button" data-op="delete" title="Delete">🗑️</button>
+                        {{end}}
+                    </div>
+                    {{end}}
+                </div>
+                {{end}}
+            </div>
+            {{template "pager" .Pagination}}
+            {{else if .Pagination.Total}}
+            <div class="empty-state">
+                <p>📄 There is no page {{.Pagination.Page}}</p>
+            </div>
+            {{template "pager" .Pagination}}
+            {{else if .Filter}}
+            <div class="empty-state">
+                <p>🔍 Nothing matches “{{.Filter}}”</p>
+            </div>
+            {{else}}
+            <div class="empty-state">
+                <p>📭 This directory is empty</p>
+            </div>
+            {{end}}
+
+            {{with .Readme}}
+            <section class="readme">
+                <h2 class="readme-name">📖 {{.Name}}</h2>
+                {{template "readme" .}}
+            </section>
+            {{end}}
+        {{end}}
+    </div>
+</body>
+</html>
+`))
+
+// file-link is the body of a row in a listing, linking to the directory or
+// to the file's preview
+var _ = template.Must(tmpl.New("file-link").Parse(`
+    <a href="{{.Path}}{{if not .IsDir}}?view{{end}}" class="file-link">
+        <div class="file-icon">{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="" loading="lazy" onerror="this.parentNode.textContent = '🖼️'">{{else if .IsDir}}📁{{else}}📄{{end}}</div>
+        <div class="file-info">
+            <div class="file-name">{{.Name}}</div>
+            <div class="file-meta">{{if .IsDir}}Directory{{else}}File{{end}}</div>
+        </div>
+        <div class="file-mtime">{{.ModTime.Format "2006-01-02 15:04"}}</div>
+        <div class="file-size">{{if not .IsDir}}{{formatSize .Size}}{{end}}</div>
+    </a>
+`))
+
+// search-form searches file names, or contents where they are indexed, in
+// the directory shown or everywhere
+var _ = template.Must(tmpl.New("search-form").Parse(`
+    <form class="search-form" method="get" action="/_fileserv/search" role="search">
+        <input type="search" name="q" value="{{.Query}}" placeholder="Search file names" aria-label="Search file names">
+        <select name="mode" aria-label="Match">
+            <option value="substring">Contains</option>
+            <option value="prefix"{{if eq .Mode "prefix"}} selected{{end}}>Starts with</option>
+            <option value="glob"{{if eq .Mode "glob"}} selected{{end}}>Glob</option>
+            <option value="regex"{{if eq .Mode "regex"}} selected{{end}}>Regex</option>
+            {{if .FullText}}<option value="content"{{if eq .Mode "content"}} selected{{end}}>File contents</option>{{end}}
+        </select>
+        {{if .In}}
+        <select name="in" aria-label="Where">
+            <option value="{{.In}}">In {{.In}}</option>
+            <option value="">Everywhere</option>
+        </select>
+        {{end}}
+        <button type="submit" class="button">🔍 Search</button>
+    </form>
+`))
+
+// lightbox shows the images of a gallery full size, one at a time, with
+// the arrow keys paging through them
+var _ = template.Must(tmpl.New("lightbox").Parse(`
+    <div class="lightbox" id="lightbox" hidden>
+        <button type="button" class="lightbox-close" aria-label="Close">✕</button>
+        <button type="button" class="lightbox-prev" aria-label="Previous image">‹</button>
+        <figure>
+            <img alt="">
+            <figcaption></figcaption>
+        </figure>
+        <button type="button" class="lightbox-next" aria-label="Next image">›</button>
+    </div>
+    <script>
+    (function () {
+        var links = Array.prototype.slice.call(document.querySelectorAll('a[data-lightbox]'));
+        var box = document.getElementById('lightbox');
+        var img = box.querySelector('img');
+        var caption = box.querySelector('figcaption');
+        var current = -1;
+
+        function show(i) {
+            current = (i + links.length) % links.length;
+            img.src = links[current].href;
+            caption.textContent = links[current].dataset.name + ' · ' + (current + 1) + ' of ' + links.length;
+            box.hidden = false;
+            // Fetch the next image while this one is looked at
+            new Image().src = links[(current + 1) % links.length].href;
+        }
+
+        function close() {
+            box.hidden = true;
+            img.removeAttribute('src');
+            current = -1;
+        }
+
+        links.forEach(function (link, i) {
+            link.addEventListener('click', function (e) {
+                // Leave opening in a new tab to the browser
+                if (e.button !== 0 || e.ctrlKey || e.metaKey || e.shiftKey) return;
+                e.preventDefault();
+                show(i);
+            });
+        });
+
+        box.querySelector('.lightbox-close').addEventListener('click', close);
+        box.querySelector('.lightbox-prev').addEventListener('click', function () { show(current - 1); });
+        box.querySelector('.lightbox-next').addEventListener('click', function () { show(current + 1); });
+        box.addEventListener('click', function (e) {
+            if (e.target === box) close();
+        });
+        document.addEventListener('keydown', function (e) {
+            if (box.hidden) return;
+            switch (e.key) {
+            case 'Escape':
+                close();
+                break;
+            case 'ArrowLeft':
+                show(current - 1);
+                break;
+            case 'ArrowRight':
+                show(current + 1);
+                break;
+            default:
+                return;
+            }
+            e.preventDefault();
+        });
+    })();
+    </script>
+`))
+
+// readme is a README or description shown with a listing
+var _ = template.Must(tmpl.New("readme").Parse(`
+    {{if .HTML}}<article class="markdown-body">{{.HTML}}</article>{{else}}<pre class="readme-text">{{.Text}}</pre>{{end}}
+    {{if .Truncated}}<div class="notice">Only the start of {{.Name}} is shown.</div>{{end}}
+`))
+
+// pager links to the neighbouring pages of a long listing
+var _ = template.Must(tmpl.New("pager").Parse(`
+    {{if gt .Pages 1}}
+    <nav class="pager">
+        {{if .PrevURL}}<a href="{{.PrevURL}}" class="button" rel="prev">← Previous</a>{{end}}
+        <span>Page {{.Page}} of {{.Pages}} · {{.Total}} entries</span>
+        {{if .NextURL}}<a href="{{.NextURL}}" class="button" rel="next">Next →</a>{{end}}
+    </nav>
+    {{end}}
+`))
+
+// styles is the stylesheet shared by every page
+var _ = template.Must(tmpl.New("styles").Parse(`
     <style>
         :root {
             --bg-primary: #ffffff;
@@ -148,167 +447,1099 @@ var tmpl = template.Must(template.New("listing").Funcs(template.FuncMap{
             background: var(--bg-hover);
         }
 
-        .file-icon {
-            font-size: 1.5rem;
+        .file-select {
             margin-right: 1rem;
-            min-width: 2rem;
-            text-align: center;
+            cursor: pointer;
         }
 
-        .file-info {
+        .file-link {
+            display: flex;
+            align-items: center;
             flex: 1;
             min-width: 0;
+            text-decoration: none;
+            color: inherit;
         }
 
-        .file-name {
-            font-weight: 500;
-            margin-bottom: 0.2rem;
-            word-break: break-all;
+        .file-actions {
+            display: flex;
+            gap: 0.25rem;
+            margin-left: 0.75rem;
+            opacity: 0.35;
+            transition: opacity 0.15s ease;
         }
 
-        .file-meta {
-            font-size: 0.85rem;
-            color: var(--text-secondary);
+        .file-item:hover .file-actions,
+        .file-actions:focus-within {
+            opacity: 1;
         }
 
-        .file-size {
-            margin-left: auto;
-            padding-left: 1rem;
-            color: var(--text-secondary);
-            font-size: 0.9rem;
-            white-space: nowrap;
+        .file-actions button {
+            background: none;
+            border: 1px solid transparent;
+            border-radius: 6px;
+            padding: 0.2rem 0.4rem;
+            font-size: 1rem;
+            cursor: pointer;
         }
 
-        .directory-nav {
+        .file-actions button:hover {
+            border-color: var(--border-color);
+            background: var(--bg-primary);
+        }
+
+        .toolbar {
+            display: flex;
+            justify-content: flex-end;
+            gap: 0.5rem;
+            margin-bottom: 1rem;
+        }
+
+        .share-dialog {
+            margin: auto;
+            width: min(420px, 90vw);
             background: var(--bg-secondary);
-            padding: 0.75rem 1.5rem;
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
             border-radius: 8px;
+            padding: 1.5rem;
+            box-shadow: 0 4px 12px var(--shadow);
+        }
+
+        .share-dialog::backdrop {
+            background: rgba(0, 0, 0, 0.4);
+        }
+
+        .share-dialog h2 {
+            font-size: 1.1rem;
             margin-bottom: 1rem;
-            border: 1px solid var(--border-color);
+            overflow-wrap: anywhere;
         }
 
-        .directory-nav select {
+        .share-dialog label {
+            display: block;
+            font-size: 0.9rem;
+            color: var(--text-secondary);
+            margin-bottom: 0.25rem;
+        }
+
+        .share-dialog input,
+        .share-dialog select {
+            width: 100%;
             background: var(--bg-primary);
             color: var(--text-primary);
             border: 1px solid var(--border-color);
-            padding: 0.5rem 1rem;
             border-radius: 6px;
+            padding: 0.4rem 0.6rem;
             font-size: 0.95rem;
-            cursor: pointer;
-            transition: all 0.2s ease;
+            margin-bottom: 0.75rem;
         }
 
-        .directory-nav select:hover {
-            border-color: var(--accent-color);
+        .share-result {
+            display: flex;
+            gap: 0.5rem;
         }
 
-        .directory-nav select:focus {
-            outline: none;
-            border-color: var(--accent-color);
-            box-shadow: 0 0 0 3px rgba(13, 110, 253, 0.1);
+        .share-result input {
+            flex: 1;
         }
 
-        .back-link {
-            display: inline-flex;
+        .share-error {
+            color: #dc3545;
+            margin-bottom: 0.75rem;
+            font-size: 0.9rem;
+        }
+
+        .share-buttons {
+            display: flex;
             align-items: center;
-            color: var(--accent-color);
-            text-decoration: none;
-            margin-bottom: 1rem;
-            font-weight: 500;
-            transition: color 0.2s ease;
+            justify-content: flex-end;
+            gap: 0.5rem;
+            font-size: 0.9rem;
         }
 
-        .back-link:hover {
-            color: var(--accent-hover);
+        .share-buttons a {
+            margin-right: auto;
+            color: var(--accent-color);
         }
 
-        .empty-state {
+        .file-icon {
+            font-size: 1.5rem;
+            margin-right: 1rem;
+            min-width: 2rem;
             text-align: center;
-            padding: 3rem 1rem;
+        }
+
+        .file-icon img {
+            width: 2rem;
+            height: 2rem;
+            object-fit: cover;
+            border-radius: 4px;
+            vertical-align: middle;
+        }
+
+        .grid-sort {
+            display: flex;
+            gap: 1rem;
+            margin-bottom: 0.75rem;
+            font-size: 0.9rem;
             color: var(--text-secondary);
         }
 
-        @media (max-width: 768px) {
-            .container {
-                padding: 1rem;
-            }
+        .grid-sort a {
+            color: var(--text-secondary);
+            text-decoration: none;
+        }
 
-            h1 {
-                font-size: 1.5rem;
-            }
+        .grid-sort a.active,
+        .grid-sort a:hover {
+            color: var(--accent-color);
+        }
 
-            .file-item {
-                padding: 0.75rem 1rem;
-            }
+        .file-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(180px, 1fr));
+            gap: 1rem;
+        }
 
-            .file-size {
-                display: none;
-            }
+        .grid-item {
+            position: relative;
+            background: var(--bg-secondary);
+            border: 1px solid var(--border-color);
+            border-radius: 8px;
+            overflow: hidden;
+        }
 
-            .directory-selector {
-                grid-template-columns: 1fr;
-            }
+        .grid-item:hover {
+            border-color: var(--accent-color);
         }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <header>
-            {{if .IsRoot}}
-                <h1>📁 File Server</h1>
-                <div class="breadcrumb">Select a directory to browse</div>
-            {{else}}
-                <a href="/" class="back-link">← Back to all directories</a>
-                <h1>{{.CurrentPath}}</h1>
-            {{end}}
-        </header>
 
-        {{if .IsRoot}}
-            <div class="directory-selector">
-                {{range .Directories}}
-                <a href="/{{.Name}}" class="directory-card">
-                    <div class="directory-card-icon">📂</div>
-                    <div class="directory-card-name">{{.Name}}</div>
-                    <div class="directory-card-path">{{.Path}}</div>
-                </a>
-                {{end}}
-            </div>
-        {{else}}
-            {{if gt (len .Directories) 1}}
-            <div class="directory-nav">
-                <label for="dir-select">Switch directory: </label>
-                <select id="dir-select" onchange="window.location.href='/' + this.value">
-                    {{range .Directories}}
-                    <option value="{{.Name}}">{{.Name}}</option>
-                    {{end}}
-                </select>
-            </div>
-            {{end}}
+        .grid-item .file-select {
+            position: absolute;
+            top: 0.5rem;
+            left: 0.5rem;
+            margin: 0;
+        }
 
-            {{if .Files}}
-            <div class="file-list">
-                {{range .Files}}
-                <a href="{{.Path}}" class="file-item">
-                    <div class="file-icon">{{if .IsDir}}📁{{else}}📄{{end}}</div>
-                    <div class="file-info">
-                        <div class="file-name">{{.Name}}</div>
-                        <div class="file-meta">{{if .IsDir}}Directory{{else}}File{{end}}</div>
-                    </div>
-                    {{if not .IsDir}}
-                    <div class="file-size">{{formatSize .Size}}</div>
-                    {{end}}
-                </a>
-                {{end}}
-            </div>
-            {{else}}
-            <div class="empty-state">
-                <p>📭 This directory is empty</p>
-            </div>
-            {{end}}
+        .grid-item .file-actions {
+            justify-content: center;
+            margin: 0 0 0.5rem;
+        }
+
+        .grid-item:hover .file-actions {
+            opacity: 1;
+        }
+
+        .grid-link {
+            display: block;
+            padding: 0.75rem;
+            color: inherit;
+            text-decoration: none;
+            text-align: center;
+        }
+
+        .grid-thumb {
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            height: 140px;
+            font-size: 3rem;
+        }
+
+        .grid-thumb img {
+            max-width: 100%;
+            max-height: 100%;
+            border-radius: 4px;
+        }
+
+        .grid-name {
+            margin-top: 0.5rem;
+            font-weight: 500;
+            white-space: nowrap;
+            overflow: hidden;
+            text-overflow: ellipsis;
+        }
+
+        .preview-bar {
+            display: flex;
+            align-items: center;
+            gap: 0.5rem;
+            margin-top: 0.5rem;
+        }
+
+        .preview-bar .file-meta {
+            margin-right: auto;
+        }
+
+        .preview-code,
+        .preview-table,
+        .preview-hex {
+            background: var(--bg-secondary);
+            border: 1px solid var(--border-color);
+            border-radius: 8px;
+            overflow: auto;
+        }
+
+        .preview-code table {
+            border-collapse: collapse;
+            font-family: monospace;
+            font-size: 0.85rem;
+            line-height: 1.5;
+            tab-size: 4;
+        }
+
+        .preview-code td {
+            padding: 0 0.75rem;
+            white-space: pre;
+        }
+
+        .preview-code .line-number {
+            text-align: right;
+            user-select: none;
+            border-right: 1px solid var(--border-color);
+        }
+
+        .preview-code .line-number a {
+            color: var(--text-secondary);
+            text-decoration: none;
+        }
+
+        .preview-code tr:target {
+            background: var(--bg-hover);
+        }
+
+        .tok-keyword {
+            color: var(--accent-color);
+            font-weight: 600;
+        }
+
+        .tok-string {
+            color: #198754;
+        }
+
+        .tok-comment {
+            color: var(--text-secondary);
+            font-style: italic;
+        }
+
+        .tok-number {
+            color: #d63384;
+        }
+
+        @media (prefers-color-scheme: dark) {
+            .tok-string {
+                color: #8ce99a;
+            }
+
+            .tok-number {
+                color: #faa2c1;
+            }
+        }
+
+        .preview-table {
+            max-height: 75vh;
+        }
+
+        .preview-table table {
+            border-collapse: collapse;
+            font-size: 0.9rem;
+        }
+
+        .preview-table th,
+        .preview-table td {
+            padding: 0.35rem 0.75rem;
+            border: 1px solid var(--border-color);
+            white-space: nowrap;
+            text-align: left;
+        }
+
+        .preview-table th {
+            position: sticky;
+            top: 0;
+            background: var(--bg-hover);
+        }
+
+        .preview-hex {
+            padding: 1rem;
+            font-size: 0.85rem;
+        }
+
+        .preview-media {
+            text-align: center;
+        }
+
+        .preview-media img,
+        .preview-media video {
+            max-width: 100%;
+            max-height: 80vh;
+        }
+
+        .description {
+            margin-bottom: 1.5rem;
+        }
+
+        .readme {
+            margin-top: 2rem;
+            border: 1px solid var(--border-color);
+            border-radius: 8px;
+        }
+
+        .readme-name {
+            padding: 0.6rem 1rem;
+            font-size: 0.95rem;
+            background: var(--bg-secondary);
+            border-bottom: 1px solid var(--border-color);
+            border-radius: 8px 8px 0 0;
+        }
+
+        .readme .markdown-body,
+        .readme-text {
+            padding: 1rem 1.5rem;
+        }
+
+        .readme-text {
+            white-space: pre-wrap;
+            font-size: 0.9rem;
+        }
+
+        .markdown-body {
+            line-height: 1.7;
+        }
+
+        .markdown-body > * + * {
+            margin-top: 1rem;
+        }
+
+        .markdown-body h1,
+        .markdown-body h2 {
+            padding-bottom: 0.3rem;
+            border-bottom: 1px solid var(--border-color);
+        }
+
+        .markdown-body ul,
+        .markdown-body ol {
+            padding-left: 2rem;
+        }
+
+        .markdown-body a {
+            color: var(--accent-color);
+        }
+
+        .markdown-body img {
+            max-width: 100%;
+        }
+
+        .markdown-body code {
+            background: var(--bg-secondary);
+            border-radius: 4px;
+            padding: 0.1rem 0.3rem;
+            font-size: 0.9em;
+        }
+
+        .markdown-body pre {
+            background: var(--bg-secondary);
+            border: 1px solid var(--border-color);
+            border-radius: 8px;
+            padding: 1rem;
+            overflow: auto;
+        }
+
+        .markdown-body pre code {
+            background: none;
+            padding: 0;
+        }
+
+        .markdown-body blockquote {
+            padding-left: 1rem;
+            border-left: 4px solid var(--border-color);
+            color: var(--text-secondary);
+        }
+
+        .markdown-body table {
+            border-collapse: collapse;
+        }
+
+        .markdown-body th,
+        .markdown-body td {
+            padding: 0.35rem 0.75rem;
+            border: 1px solid var(--border-color);
+        }
+
+        .lightbox {
+            position: fixed;
+            inset: 0;
+            z-index: 100;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            background: rgba(0, 0, 0, 0.9);
+        }
+
+        .lightbox[hidden] {
+            display: none;
+        }
+
+        .lightbox figure {
+            display: flex;
+            flex-direction: column;
+            align-items: center;
+            max-width: calc(100% - 8rem);
+            max-height: 100%;
+        }
+
+        .lightbox img {
+            max-width: 100%;
+            max-height: calc(100vh - 5rem);
+            object-fit: contain;
+        }
+
+        .lightbox figcaption {
+            margin-top: 0.75rem;
+            color: #e9ecef;
+            font-size: 0.9rem;
+        }
+
+        .lightbox button {
+            background: none;
+            border: none;
+            color: #e9ecef;
+            font-size: 2.5rem;
+            padding: 1rem;
+            cursor: pointer;
+        }
+
+        .lightbox button:hover {
+            color: #ffffff;
+        }
+
+        .lightbox .lightbox-close {
+            position: absolute;
+            top: 0.5rem;
+            right: 0.5rem;
+            font-size: 1.5rem;
+        }
+
+        .file-info {
+            flex: 1;
+            min-width: 0;
+        }
+
+        .file-name {
+            font-weight: 500;
+            margin-bottom: 0.2rem;
+            word-break: break-all;
+        }
+
+        .file-meta {
+            font-size: 0.85rem;
+            color: var(--text-secondary);
+        }
+
+        .file-size {
+            margin-left: auto;
+            padding-left: 1rem;
+            min-width: 6rem;
+            text-align: right;
+            color: var(--text-secondary);
+            font-size: 0.9rem;
+            white-space: nowrap;
+        }
+
+        .file-mtime {
+            padding-left: 1rem;
+            color: var(--text-secondary);
+            font-size: 0.9rem;
+            white-space: nowrap;
+        }
+
+        .list-header {
+            gap: 1rem;
+            padding-top: 0.6rem;
+            padding-bottom: 0.6rem;
+            font-size: 0.85rem;
+        }
+
+        .list-header:hover {
+            background: none;
+        }
+
+        .list-header a {
+            color: var(--text-secondary);
+            text-decoration: none;
+        }
+
+        .list-header a:hover,
+        .list-header a.active {
+            color: var(--accent-color);
+        }
+
+        .list-header .sort-Modified {
+            margin-left: auto;
+        }
+
+        .filter-form {
+            margin-right: auto;
+        }
+
+        .search-form {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 0.5rem;
+            margin-top: 1rem;
+        }
+
+        .search-form input,
+        .search-form select {
+            background: var(--bg-primary);
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
+            border-radius: 6px;
+            padding: 0.35rem 0.75rem;
+            font-size: 0.9rem;
+        }
+
+        .search-form input {
+            flex: 1;
+            min-width: 12rem;
+        }
+
+        .index-status {
+            margin-top: 2rem;
+        }
+
+        .index-status h2 {
+            font-size: 1.1rem;
+            margin-bottom: 0.75rem;
+        }
+
+        .notice {
+            background: var(--bg-secondary);
+            border: 1px solid var(--border-color);
+            border-radius: 6px;
+            padding: 0.6rem 1rem;
+            margin-bottom: 1rem;
+            font-size: 0.9rem;
+        }
+
+        .file-where {
+            padding-left: 1rem;
+            color: var(--text-secondary);
+            font-size: 0.85rem;
+            text-decoration: none;
+            white-space: nowrap;
+            overflow: hidden;
+            text-overflow: ellipsis;
+            max-width: 30%;
+        }
+
+        .file-where:hover {
+            color: var(--accent-color);
+        }
+
+        .content-match {
+            display: block;
+        }
+
+        .match-file {
+            display: flex;
+            align-items: center;
+        }
+
+        .match-lines {
+            list-style: none;
+            margin: 0.5rem 0 0 2.5rem;
+            font-size: 0.85rem;
+            color: var(--text-secondary);
+        }
+
+        .match-lines li {
+            display: flex;
+            gap: 0.75rem;
+            overflow: hidden;
+        }
+
+        .match-lines .line-number {
+            min-width: 3rem;
+            text-align: right;
+            font-family: monospace;
+            color: var(--text-secondary);
+            text-decoration: none;
+        }
+
+        .match-lines code {
+            white-space: pre;
+            overflow: hidden;
+            text-overflow: ellipsis;
+        }
+
+        .match-lines mark {
+            background: var(--accent-color);
+            color: var(--bg-primary);
+            border-radius: 2px;
+        }
+
+        .pager {
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            gap: 1rem;
+            margin-top: 1rem;
+            color: var(--text-secondary);
+            font-size: 0.9rem;
+        }
+
+        .filter-form input {
+            background: var(--bg-primary);
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
+            border-radius: 6px;
+            padding: 0.35rem 0.75rem;
+            font-size: 0.9rem;
+        }
+
+        .directory-nav {
+            background: var(--bg-secondary);
+            padding: 0.75rem 1.5rem;
+            border-radius: 8px;
+            margin-bottom: 1rem;
+            border: 1px solid var(--border-color);
+        }
+
+        .directory-nav select {
+            background: var(--bg-primary);
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
+            padding: 0.5rem 1rem;
+            border-radius: 6px;
+            font-size: 0.95rem;
+            cursor: pointer;
+            transition: all 0.2s ease;
+        }
+
+        .directory-nav select:hover {
+            border-color: var(--accent-color);
+        }
+
+        .directory-nav select:focus {
+            outline: none;
+            border-color: var(--accent-color);
+            box-shadow: 0 0 0 3px rgba(13, 110, 253, 0.1);
+        }
+
+        .back-link {
+            display: inline-flex;
+            align-items: center;
+            color: var(--accent-color);
+            text-decoration: none;
+            margin-bottom: 1rem;
+            font-weight: 500;
+            transition: color 0.2s ease;
+        }
+
+        .back-link:hover {
+            color: var(--accent-hover);
+        }
+
+        .empty-state {
+            text-align: center;
+            padding: 3rem 1rem;
+            color: var(--text-secondary);
+        }
+
+        .header-top {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            gap: 1rem;
+        }
+
+        .user-info {
+            display: flex;
+            align-items: center;
+            gap: 0.75rem;
+            margin-left: auto;
+            margin-bottom: 1rem;
+            color: var(--text-secondary);
+            font-size: 0.9rem;
+            white-space: nowrap;
+        }
+
+        .user-info form {
+            display: inline;
+        }
+
+        .button {
+            background: var(--bg-secondary);
+            color: var(--text-primary);
+            border: 1px solid var(--border-color);
+            padding: 0.35rem 0.9rem;
+            border-radius: 6px;
+            font-size: 0.9rem;
+            cursor: pointer;
+            text-decoration: none;
+            transition: all 0.2s ease;
+        }
+
+        .button:hover {
+            border-color: var(--accent-color);
+            color: var(--accent-color);
+        }
+
+        .button-primary {
+            background: var(--accent-color);
+            border-color: var(--accent-color);
+            color: #fff;
+        }
+
+        .button-primary:hover {
+            background: var(--accent-hover);
+            border-color: var(--accent-hover);
+            color: #fff;
+        }
+
+        .upload-zone {
+            border: 2px dashed var(--border-color);
+            border-radius: 8px;
+            padding: 1.25rem 1.5rem;
+            margin-bottom: 1rem;
+            text-align: center;
+            color: var(--text-secondary);
+            transition: all 0.2s ease;
+        }
+
+        .upload-zone.dragover {
+            border-color: var(--accent-color);
+            background: var(--bg-hover);
+        }
+
+        .upload-zone input[type="file"] {
+            display: none;
+        }
+
+        .upload-zone label {
+            color: var(--accent-color);
+            cursor: pointer;
+            font-weight: 500;
+        }
+
+        .upload-progress {
+            margin-top: 0.75rem;
+            text-align: left;
+            font-size: 0.85rem;
+        }
+
+        .upload-progress progress {
+            width: 100%;
+            height: 0.5rem;
+        }
+
+        .upload-error {
+            color: #dc3545;
+        }
+
+        @media (max-width: 768px) {
+            .container {
+                padding: 1rem;
+            }
+
+            h1 {
+                font-size: 1.5rem;
+            }
+
+            .file-item {
+                padding: 0.75rem 1rem;
+            }
+
+            .file-size,
+            .file-mtime,
+            .file-where {
+                display: none;
+            }
+
+            .file-actions {
+                opacity: 1;
+            }
+
+            .directory-selector {
+                grid-template-columns: 1fr;
+            }
+        }
+    </style>
+`))
+
+// user shows who is signed in, with a sign-out button for login sessions
+var _ = template.Must(tmpl.New("user").Parse(`
+    {{if .User}}
+    <div class="user-info">
+        <span>👤 {{.User}}</span>
+        {{if .CanLogout}}
+        <form method="post" action="/_fileserv/logout">
+            <button type="submit" class="button">Sign out</button>
+        </form>
         {{end}}
     </div>
-</body>
-</html>
+    {{end}}
+`))
+
+// upload is the drop zone and file picker for writable directories. It
+// works as a plain form without JavaScript. With it, each file is sent in
+// its own request with a progress bar, using the tus protocol when the
+// server offers it so interrupted uploads resume where they stopped.
+var _ = template.Must(tmpl.New("upload").Parse(`
+    <form class="upload-zone" id="upload-zone" method="post" enctype="multipart/form-data">
+        📤 Drop files here or
+        <label for="upload-input">choose files</label>
+        <input id="upload-input" type="file" name="file" multiple>
+        {{if .MaxUpload}}<span>(up to {{formatSize .MaxUpload}} each)</span>{{end}}
+        <noscript><button type="submit" class="button">Upload</button></noscript>
+        <div class="upload-progress" id="upload-progress"></div>
+    </form>
+    <script>
+    (function () {
+        var zone = document.getElementById('upload-zone');
+        var input = document.getElementById('upload-input');
+        var progress = document.getElementById('upload-progress');
+        var tusURL = {{.TusURL}};
+        var target = decodeURIComponent(window.location.pathname);
+        var chunkSize = 8 * 1024 * 1024;
+        var storePrefix = 'fileserv-tus:';
+
+        function request(method, url, headers, body, onprogress) {
+            return new Promise(function (resolve) {
+                var xhr = new XMLHttpRequest();
+                xhr.open(method, url);
+                for (var k in headers) xhr.setRequestHeader(k, headers[k]);
+                if (onprogress) xhr.upload.onprogress = onprogress;
+                xhr.onload = xhr.onerror = function () { resolve(xhr); };
+                xhr.send(body === undefined ? null : body);
+            });
+        }
+
+        function sleep(ms) {
+            return new Promise(function (resolve) { setTimeout(resolve, ms); });
+        }
+
+        function b64(s) {
+            return btoa(unescape(encodeURIComponent(s)));
+        }
+
+        async function formUpload(file, bar) {
+            var body = new FormData();
+            body.append('file', file, file.name);
+            var xhr = await request('POST', window.location.pathname, {'Accept': 'application/json'}, body, function (e) {
+                if (e.lengthComputable) bar.value = e.loaded / e.total;
+            });
+            if (xhr.status < 200 || xhr.status >= 300) {
+                throw new Error(xhr.status ? xhr.responseText : 'network error');
+            }
+        }
+
+        async function tusUpload(file, bar) {
+            var tus = {'Tus-Resumable': '1.0.0'};
+            var key = storePrefix + JSON.stringify([target, file.name, file.size, file.lastModified]);
+            var url = localStorage.getItem(key);
+            var offset = 0;
+
+            async function sync() {
+                var head = await request('HEAD', url, tus);
+                if (head.status !== 200) return false;
+                offset = parseInt(head.getResponseHeader('Upload-Offset'), 10);
+                return true;
+            }
+
+            if (!url || !(await sync())) {
+                var created = await request('POST', tusURL, Object.assign({
+                    'Upload-Length': file.size,
+                    'Upload-Metadata': 'filename ' + b64(file.name) + ',target ' + b64(target)
+                }, tus));
+                if (created.status !== 201) {
+                    throw new Error(created.status ? created.responseText : 'network error');
+                }
+                url = created.getResponseHeader('Location');
+                offset = 0;
+                localStorage.setItem(key, url);
+            }
+
+            var failures = 0;
+            do {
+                var chunk = file.slice(offset, offset + chunkSize);
+                var headers = Object.assign({
+                    'Upload-Offset': offset,
+                    'Content-Type': 'application/offset+octet-stream'
+                }, tus);
+                if (window.crypto && crypto.subtle) {
+                    var digest = new Uint8Array(await crypto.subtle.digest('SHA-256', await chunk.arrayBuffer()));
+                    headers['Upload-Checksum'] = 'sha256 ' + btoa(String.fromCharCode.apply(null, digest));
+                }
+                var start = offset;
+                var res = await request('PATCH', url, headers, chunk, function (e) {
+                    bar.value = file.size ? (start + e.loaded) / file.size : 1;
+                });
+
+                if (res.status === 204) {
+                    offset = parseInt(res.getResponseHeader('Upload-Offset'), 10);
+                    failures = 0;
+                    continue;
+                }
+                if (res.status !== 0 && res.status !== 409 && res.status !== 460 && res.status < 500) {
+                    localStorage.removeItem(key);
+                    throw new Error(res.responseText);
+                }
+                // Network trouble: wait, ask the server where we are, retry
+                if (++failures > 8) throw new Error('upload interrupted, choose the file again to resume');
+                await sleep(1000 * failures);
+                await sync();
+            } while (offset < file.size);
+
+            localStorage.removeItem(key);
+        }
+
+        function uploadOne(file) {
+            var row = document.createElement('div');
+            var bar = document.createElement('progress');
+            row.textContent = file.name;
+            bar.max = 1;
+            bar.value = 0;
+            row.appendChild(bar);
+            progress.appendChild(row);
+
+            return (tusURL ? tusUpload : formUpload)(file, bar).then(function () {
+                bar.value = 1;
+                return true;
+            }, function (err) {
+                row.className = 'upload-error';
+                row.textContent = file.name + ': ' + err.message;
+                return false;
+            });
+        }
+
+        async function uploadAll(files) {
+            var ok = true;
+            for (var i = 0; i < files.length; i++) {
+                ok = (await uploadOne(files[i])) && ok;
+            }
+            if (ok) window.location.reload();
+        }
+
+        // Browsers cannot reopen a file on their own, so point out
+        // interrupted uploads that resume when the file is chosen again
+        if (tusURL) {
+            for (var i = 0; i < localStorage.length; i++) {
+                var k = localStorage.key(i);
+                if (k.indexOf(storePrefix) !== 0) continue;
+                var saved = JSON.parse(k.slice(storePrefix.length));
+                if (saved[0] !== target) continue;
+                var note = document.createElement('div');
+                note.textContent = '⏸ ' + saved[1] + ' was interrupted; choose it again to resume.';
+                progress.appendChild(note);
+            }
+        }
+
+        input.addEventListener('change', function () {
+            uploadAll(input.files);
+        });
+        zone.addEventListener('dragover', function (e) {
+            e.preventDefault();
+            zone.classList.add('dragover');
+        });
+        zone.addEventListener('dragleave', function () {
+            zone.classList.remove('dragover');
+        });
+        zone.addEventListener('drop', function (e) {
+            e.preventDefault();
+            zone.classList.remove('dragover');
+            uploadAll(e.dataTransfer.files);
+        });
+    })();
+    </script>
+`))
+
+// archive points the download buttons at the selected entries, or at the
+// whole directory when nothing is selected
+var _ = template.Must(tmpl.New("archive").Parse(`
+    <script>
+    (function () {
+        var boxes = document.getElementsByClassName('file-select');
+        var links = document.querySelectorAll('.archive-link');
+        var labels = {'zip': '⬇️ Download ZIP', 'tar.gz': '⬇️ tar.gz'};
+
+        function update() {
+            var names = [];
+            for (var i = 0; i < boxes.length; i++) {
+                if (boxes[i].checked) names.push(boxes[i].value);
+            }
+            links.forEach(function (link) {
+                var format = link.dataset.format;
+                var query = '?archive=' + encodeURIComponent(format);
+                names.forEach(function (name) {
+                    query += '&name=' + encodeURIComponent(name);
+                });
+                link.href = query;
+                link.textContent = labels[format] + (names.length ? ' (' + names.length + ' selected)' : '');
+            });
+        }
+
+        document.addEventListener('change', function (e) {
+            if (e.target.classList.contains('file-select')) update();
+        });
+        update();
+    })();
+    </script>
+`))
+
+// ops wires the new folder button and the row actions of writable
+// directories to the file operation endpoints
+var _ = template.Must(tmpl.New("ops").Parse(`
+    <script>
+    (function () {
+        var current = decodeURIComponent(window.location.pathname).replace(/\/+$/, '');
+
+        function op(name, body) {
+            return fetch('/_fileserv/ops/' + name, {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify(body)
+            }).then(function (res) {
+                if (res.ok) return window.location.reload();
+                return res.json().then(function (e) {
+                    alert(e.error || res.statusText);
+                }, function () {
+                    alert(res.status + ' ' + res.statusText);
+                });
+            }, function (err) {
+                alert(err.message);
+            });
+        }
+
+        document.getElementById('new-folder').addEventListener('click', function () {
+            var name = prompt('New folder name:');
+            if (name) op('mkdir', {path: current + '/' + name});
+        });
+
+        document.querySelectorAll('.file-actions button').forEach(function (button) {
+            button.addEventListener('click', function () {
+                var path = button.parentNode.dataset.path;
+                var name = button.parentNode.dataset.name;
+                var dest;
+
+                switch (button.dataset.op) {
+                case 'rename':
+                    var newName = prompt('Rename ' + name + ' to:', name);
+                    if (newName && newName !== name) op('rename', {path: path, name: newName});
+                    break;
+                case 'move':
+                case 'copy':
+                    dest = prompt((button.dataset.op === 'move' ? 'Move ' : 'Copy ') + name + ' to folder:', current);
+                    if (dest) op(button.dataset.op, {paths: [path], dest: dest});
+                    break;
+                case 'delete':
+                    if (confirm('Delete ' + name + '?')) op('delete', {paths: [path]});
+                    break;
+                }
+            });
+        });
+    })();
+    </script>
 `))
 
 // RenderListing renders the directory listing template
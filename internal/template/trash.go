@@ -0,0 +1,114 @@
+package template
+
+import (
+	"html/template"
+	"io"
+
+	"fileserv/internal/models"
+)
+
+var _ = template.Must(tmpl.New("trash").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Trash · {{.Mount}}</title>
+    {{template "styles"}}
+</head>
+<body>
+    <div class="container">
+        <header>
+            <div class="header-top">
+                <a href="{{.Mount}}" class="back-link">← Back to {{.Mount}}</a>
+                {{template "user" .}}
+            </div>
+            <h1>🗑️ Trash of {{.Mount}}</h1>
+            <div class="breadcrumb">Deleted items are kept for {{.RetentionDays}} days, then removed for good</div>
+        </header>
+
+        {{if .Items}}
+        <div class="toolbar">
+            <button type="button" class="button" id="empty-trash">Empty trash</button>
+        </div>
+        <div class="file-list">
+            {{range .Items}}
+            <div class="file-item">
+                <div class="file-link">
+                    <div class="file-icon">{{if .IsDir}}📁{{else}}📄{{end}}</div>
+                    <div class="file-info">
+                        <div class="file-name">{{.Name}}</div>
+                        <div class="file-meta">
+                            {{.Path}} · deleted {{.DeletedAt.Local.Format "2006-01-02 15:04"}}{{if .DeletedBy}} by {{.DeletedBy}}{{end}}
+                        </div>
+                    </div>
+                    {{if not .IsDir}}
+                    <div class="file-size">{{formatSize .Size}}</div>
+                    {{end}}
+                </div>
+                <div class="file-actions" data-id="{{.ID}}" data-name="{{.Name}}">
+                    <button type="button" data-op="restore" title="Restore">↩️</button>
+                    <button type="button" data-op="purge" title="Delete forever">❌</button>
+                </div>
+            </div>
+            {{end}}
+        </div>
+        {{else}}
+        <div class="empty-state">
+            <p>🗑️ The trash is empty</p>
+        </div>
+        {{end}}
+    </div>
+    <script>
+    (function () {
+        var mount = {{.Mount}};
+
+        function op(name, ids) {
+            return fetch('/_fileserv/ops/' + name, {
+                method: 'POST',
+                headers: {'Content-Type': 'application/json'},
+                body: JSON.stringify({path: mount, ids: ids})
+            }).then(function (res) {
+                if (res.ok) return window.location.reload();
+                return res.json().then(function (e) {
+                    alert(e.error || res.statusText);
+                }, function () {
+                    alert(res.status + ' ' + res.statusText);
+                });
+            }, function (err) {
+                alert(err.message);
+            });
+        }
+
+        document.querySelectorAll('.file-actions button').forEach(function (button) {
+            button.addEventListener('click', function () {
+                var id = button.parentNode.dataset.id;
+                var name = button.parentNode.dataset.name;
+                if (button.dataset.op === 'restore') {
+                    op('restore', [id]);
+                } else if (confirm('Delete ' + name + ' forever?')) {
+                    op('purge', [id]);
+                }
+            });
+        });
+
+        var empty = document.getElementById('empty-trash');
+        if (empty) {
+            empty.addEventListener('click', function () {
+                if (!confirm('Delete everything in the trash forever?')) return;
+                var ids = [];
+                document.querySelectorAll('.file-actions').forEach(function (row) {
+                    ids.push(row.dataset.id);
+                });
+                op('purge', ids);
+            });
+        }
+    })();
+    </script>
+</body>
+</html>
+`))
+
+// RenderTrash renders a mount's trash page
+func RenderTrash(w io.Writer, data models.TrashData) error {
+	return tmpl.ExecuteTemplate(w, "trash", data)
+}
@@ -0,0 +1,520 @@
+// Package tus implements the server side of the tus resumable upload
+// protocol, version 1.0.0, with the creation, termination and checksum
+// extensions. See https://tus.io/protocols/resumable-upload.
+package tus
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fileserv/internal/auth"
+)
+
+const (
+	// Version is the protocol version spoken by the handler
+	Version = "1.0.0"
+	// Extensions lists the supported protocol extensions
+	Extensions = "creation,termination,checksum"
+
+	// statusChecksumMismatch is the tus-specific status for a bad checksum
+	statusChecksumMismatch = 460
+
+	// expireAfter removes uploads that have not progressed for this long
+	expireAfter = 7 * 24 * time.Hour
+)
+
+// checksums maps the algorithms accepted in Upload-Checksum to hashes
+var checksums = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// Backend decides where uploads may go and moves finished ones into place
+type Backend interface {
+	// Target checks that the request may upload into the directory at the
+	// URL path target and returns the directory's filesystem path. Errors
+	// wrapping os.ErrPermission or os.ErrNotExist map to 403 and 404.
+	Target(r *http.Request, target string) (string, error)
+	// Finish moves the completed file at path into dir under name and
+	// returns the name it was stored as
+	Finish(path, dir, name string) (string, error)
+}
+
+// info is the persisted state of an upload. The data itself is written to
+// a hidden file in the target directory, so finishing an upload is a rename
+// on the same filesystem.
+type info struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata"`
+	Target   string            `json:"target"`
+	DataPath string            `json:"data_path"`
+	User     string            `json:"user,omitempty"`
+	Created  time.Time         `json:"created"`
+}
+
+// Handler serves tus uploads below a base path
+type Handler struct {
+	base    string
+	dir     string
+	maxSize int64
+	backend Backend
+
+	mu    sync.Mutex
+	busy  map[string]bool
+	state sync.Mutex
+}
+
+// NewHandler creates a handler mounted at base, keeping upload state in dir
+func NewHandler(base, dir string, maxSize int64, backend Backend) (*Handler, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating upload state directory: %w", err)
+	}
+	h := &Handler{
+		base:    strings.TrimSuffix(base, "/") + "/",
+		dir:     dir,
+		maxSize: maxSize,
+		backend: backend,
+		busy:    make(map[string]bool),
+	}
+	go h.expireLoop()
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		w.Header().Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+		if h.maxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "Precondition Failed: unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, h.base)
+	if id == "" || r.URL.Path == strings.TrimSuffix(h.base, "/") {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "OPTIONS, POST")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.create(w, r)
+		return
+	}
+	if !validID(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		h.head(w, r, id)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// create handles POST, the creation extension
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Bad Request: Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Bad Request: invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.maxSize > 0 && length > h.maxSize {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	meta, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if meta["filename"] == "" || meta["target"] == "" {
+		http.Error(w, "Bad Request: metadata must include filename and target", http.StatusBadRequest)
+		return
+	}
+
+	dir, err := h.backend.Target(r, meta["target"])
+	if err != nil {
+		targetError(w, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		log.Printf("Error creating upload ID: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	up := &info{
+		ID:       id,
+		Length:   length,
+		Metadata: meta,
+		Target:   meta["target"],
+		DataPath: filepath.Join(dir, ".fileserv-tus-"+id),
+		Created:  time.Now(),
+	}
+	if u := auth.UserFrom(r.Context()); u != nil {
+		up.User = u.Name
+	}
+
+	f, err := os.OpenFile(up.DataPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		targetError(w, err)
+		return
+	}
+	f.Close()
+	if err := h.save(up); err != nil {
+		os.Remove(up.DataPath)
+		log.Printf("Error saving upload state: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", h.base+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports the current offset of an upload
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	up, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	offset, err := h.offset(up)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.Header().Set("Upload-Metadata", encodeMetadata(up.Metadata))
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends the request body to an upload
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Unsupported Media Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	up, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	if !h.acquire(id) {
+		http.Error(w, "Conflict: upload is busy", http.StatusConflict)
+		return
+	}
+	defer h.release(id)
+
+	offset, err := h.offset(up)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	claimed, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || claimed != offset {
+		http.Error(w, "Conflict: Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	var sum hash.Hash
+	var want []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		algo, encoded, _ := strings.Cut(header, " ")
+		newHash, ok := checksums[algo]
+		want, err = base64.StdEncoding.DecodeString(encoded)
+		if !ok || err != nil {
+			http.Error(w, "Bad Request: unsupported checksum", http.StatusBadRequest)
+			return
+		}
+		sum = newHash()
+	}
+
+	f, err := os.OpenFile(up.DataPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var dst io.Writer = f
+	if sum != nil {
+		dst = io.MultiWriter(f, sum)
+	}
+	n, copyErr := io.Copy(dst, io.LimitReader(r.Body, up.Length-offset))
+
+	// Data that fails its checksum must not become part of the upload;
+	// without a checksum whatever arrived before an interruption is kept
+	// so the client can resume from there
+	if sum != nil && (copyErr != nil || !equalSum(sum.Sum(nil), want)) {
+		f.Truncate(offset)
+		f.Close()
+		if copyErr != nil {
+			return
+		}
+		http.Error(w, "Checksum Mismatch", statusChecksumMismatch)
+		return
+	}
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		log.Printf("Upload %s interrupted at %d bytes: %v", id, offset+n, copyErr)
+		return
+	}
+
+	offset += n
+	if offset == up.Length {
+		name, err := h.finish(r, up)
+		if err != nil {
+			targetError(w, err)
+			return
+		}
+		log.Printf("Completed resumable upload %s into %s", name, up.Target)
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish moves a complete upload into its target directory
+func (h *Handler) finish(r *http.Request, up *info) (string, error) {
+	dir, err := h.backend.Target(r, up.Target)
+	if err != nil {
+		return "", err
+	}
+	name, err := h.backend.Finish(up.DataPath, dir, up.Metadata["filename"])
+	if err != nil {
+		return "", err
+	}
+	os.Remove(h.infoPath(up.ID))
+	return name, nil
+}
+
+// terminate handles DELETE, the termination extension
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	up, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	if !h.acquire(id) {
+		http.Error(w, "Conflict: upload is busy", http.StatusConflict)
+		return
+	}
+	defer h.release(id)
+
+	h.remove(up)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup loads an upload and checks it belongs to the requesting user
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, id string) (*info, bool) {
+	up, err := h.load(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	name := ""
+	if u := auth.UserFrom(r.Context()); u != nil {
+		name = u.Name
+	}
+	if up.User != name {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return up, true
+}
+
+// offset is the number of bytes received so far
+func (h *Handler) offset(up *info) (int64, error) {
+	st, err := os.Stat(up.DataPath)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (h *Handler) acquire(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[id] {
+		return false
+	}
+	h.busy[id] = true
+	return true
+}
+
+func (h *Handler) release(id string) {
+	h.mu.Lock()
+	delete(h.busy, id)
+	h.mu.Unlock()
+}
+
+func (h *Handler) infoPath(id string) string {
+	return filepath.Join(h.dir, id+".json")
+}
+
+func (h *Handler) load(id string) (*info, error) {
+	data, err := os.ReadFile(h.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var up info
+	if err := json.Unmarshal(data, &up); err != nil {
+		return nil, err
+	}
+	return &up, nil
+}
+
+func (h *Handler) save(up *info) error {
+	data, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+	h.state.Lock()
+	defer h.state.Unlock()
+	return os.WriteFile(h.infoPath(up.ID), data, 0o600)
+}
+
+func (h *Handler) remove(up *info) {
+	os.Remove(up.DataPath)
+	os.Remove(h.infoPath(up.ID))
+}
+
+// expireLoop removes uploads abandoned for longer than expireAfter
+func (h *Handler) expireLoop() {
+	for {
+		entries, _ := os.ReadDir(h.dir)
+		for _, e := range entries {
+			id, ok := strings.CutSuffix(e.Name(), ".json")
+			if !ok || !validID(id) {
+				continue
+			}
+			up, err := h.load(id)
+			if err != nil {
+				continue
+			}
+			last := up.Created
+			if st, err := os.Stat(up.DataPath); err == nil {
+				last = st.ModTime()
+			}
+			if time.Since(last) > expireAfter && h.acquire(id) {
+				log.Printf("Removing abandoned upload %s of %s", id, up.Metadata["filename"])
+				h.remove(up)
+				h.release(id)
+			}
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma-separated pairs of
+// a key and an optional base64 value
+func parseMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+func encodeMetadata(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for k, v := range meta {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func equalSum(got, want []byte) bool {
+	return len(got) == len(want) && string(got) == string(want)
+}
+
+// targetError answers a failed Backend call with a matching status
+func targetError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Not Found: "+err.Error(), http.StatusNotFound)
+	case errors.Is(err, os.ErrExist):
+		http.Error(w, "Conflict: "+err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Error handling resumable upload: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -7,36 +7,51 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"fileserv/internal/auth"
+	"fileserv/internal/config"
 	"fileserv/internal/handler"
+	"fileserv/internal/index"
+	"fileserv/internal/models"
+	"fileserv/internal/netfilter"
+	"fileserv/internal/ratelimit"
 	"fileserv/internal/server"
+	"fileserv/internal/tus"
 )
 
 const version = "1.0.0"
 
-// expandTilde expands ~ to the user's home directory
-func expandTilde(path string) string {
-	if strings.HasPrefix(path, "~/") {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			return filepath.Join(home, path[2:])
-		}
-	}
-	if path == "~" {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			return home
-		}
-	}
-	return path
-}
-
 func main() {
 	var directories []string
 	var port string
+	var configPath string
+	var stateDir string
+	var tlsCert, tlsKey, httpRedirect string
+	var tlsSelfSigned bool
+	var htpasswd, htgroups string
+	var writable bool
+	var indexed bool
+	var maxUpload, onConflict string
+	var trashRetention string
 	var showVersion bool
 	var showHelp bool
 
+	// Flags taking a single value, accepted with one or two leading dashes
+	valueFlags := map[string]*string{
+		"port":            &port,
+		"config":          &configPath,
+		"state-dir":       &stateDir,
+		"tls-cert":        &tlsCert,
+		"tls-key":         &tlsKey,
+		"http-redirect":   &httpRedirect,
+		"htpasswd":        &htpasswd,
+		"htgroups":        &htgroups,
+		"max-upload":      &maxUpload,
+		"on-conflict":     &onConflict,
+		"trash-retention": &trashRetention,
+	}
+
 	// Manual flag parsing to handle mixed flags and arguments
 	args := os.Args[1:]
 
@@ -44,14 +59,26 @@ func main() {
 	for i < len(args) {
 		arg := args[i]
 
-		switch arg {
-		case "-port", "--port":
+		if dst, ok := valueFlags[strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")]; ok && strings.HasPrefix(arg, "-") {
 			if i+1 < len(args) {
-				port = args[i+1]
+				*dst = args[i+1]
 				i += 2
 			} else {
 				i++
 			}
+			continue
+		}
+
+		switch arg {
+		case "-tls-self-signed", "--tls-self-signed":
+			tlsSelfSigned = true
+			i++
+		case "-writable", "--writable":
+			writable = true
+			i++
+		case "-index", "--index":
+			indexed = true
+			i++
 		case "-dir", "--dir":
 			i++
 			// Collect all following arguments until we hit another flag
@@ -63,7 +90,7 @@ func main() {
 					for _, part := range parts {
 						trimmed := strings.TrimSpace(part)
 						if trimmed != "" {
-							directories = append(directories, expandTilde(trimmed))
+							directories = append(directories, config.ExpandTilde(trimmed))
 						}
 					}
 				} else {
@@ -87,11 +114,6 @@ func main() {
 		}
 	}
 
-	// Set default port if not specified
-	if port == "" {
-		port = "8000"
-	}
-
 	// Handle version flag
 	if showVersion {
 		fmt.Printf("fileserv version %s\n", version)
@@ -104,8 +126,76 @@ func main() {
 		os.Exit(0)
 	}
 
-	// If no directories specified, use current directory
-	if len(directories) == 0 {
+	// Load the configuration file, if any
+	cfg := &config.Config{}
+	if configPath != "" {
+		loaded, err := config.Load(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg = loaded
+	}
+
+	// Command line flags take precedence over the configuration file
+	if stateDir != "" {
+		cfg.StateDir = config.ExpandTilde(stateDir)
+	}
+	if cfg.StateDir == "" {
+		cfg.StateDir = config.DefaultStateDir()
+	}
+	if tlsCert != "" || tlsKey != "" {
+		if tlsCert == "" || tlsKey == "" {
+			log.Fatal("-tls-cert and -tls-key must be used together")
+		}
+		cfg.TLS.Cert, cfg.TLS.Key = tlsCert, tlsKey
+		cfg.TLS.SelfSigned = false
+	}
+	if tlsSelfSigned {
+		cfg.TLS.SelfSigned = true
+		cfg.TLS.Cert, cfg.TLS.Key = "", ""
+	}
+	if httpRedirect != "" {
+		cfg.TLS.Redirect = httpRedirect
+	}
+	if cfg.TLS.Redirect != "" && !cfg.TLS.Enabled() {
+		log.Fatal("-http-redirect requires -tls-cert/-tls-key or -tls-self-signed")
+	}
+	if htpasswd != "" {
+		cfg.Auth.Htpasswd = htpasswd
+	}
+	if htgroups != "" {
+		cfg.Auth.Htgroups = htgroups
+	}
+	if cfg.Auth.Htgroups != "" && cfg.Auth.Htpasswd == "" {
+		log.Fatal("-htgroups requires -htpasswd")
+	}
+	if maxUpload != "" {
+		n, err := config.ParseSize(maxUpload)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Upload.MaxSize = n
+	}
+	if onConflict != "" {
+		cfg.Upload.OnConflict = onConflict
+	}
+	if trashRetention != "" {
+		d, err := config.ParseDuration(trashRetention)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Trash.Retention = d
+	}
+	conflict := handler.ConflictRename
+	if cfg.Upload.OnConflict != "" {
+		var err error
+		if conflict, err = handler.ParseConflictPolicy(cfg.Upload.OnConflict); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// If no directories specified anywhere, use current directory
+	if len(directories) == 0 && len(cfg.Mounts) == 0 {
 		dir, err := os.Getwd()
 		if err != nil {
 			log.Fatal(err)
@@ -114,23 +204,202 @@ func main() {
 	}
 
 	// Validate directories
-	validDirs, err := server.ValidateDirectories(directories)
+	validDirs, err := server.BuildMounts(cfg.Mounts, directories, writable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	acl, err := server.BuildACL(cfg.ACL, validDirs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if indexed {
+		// The command line directories follow the configured mounts
+		for i := len(cfg.Mounts); i < len(validDirs); i++ {
+			validDirs[i].Index = true
+		}
+	}
+
+	// The -port flag takes precedence over the configured listen addresses
+	listen := cfg.Listen
+	if port != "" || len(listen) == 0 {
+		if port == "" {
+			port = "8000"
+			if cfg.TLS.Enabled() {
+				port = "8443"
+			}
+		}
+		listen = []string{":" + port}
+	}
+
+	// Load the TLS certificate
+	var certs *server.CertStore
+	if cfg.TLS.Enabled() {
+		if cfg.TLS.SelfSigned {
+			certs, err = server.NewSelfSignedCertStore(cfg.StateDir)
+		} else {
+			certs, err = server.NewCertStore(cfg.TLS.Cert, cfg.TLS.Key)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		certs.ReloadOnSignal()
+	}
+
+	// Load users, including those declared inline on mounts
+	users, err := auth.NewUserStore(cfg.Auth.Htpasswd, cfg.Auth.Htgroups)
 	if err != nil {
 		log.Fatal(err)
 	}
+	for _, dir := range validDirs {
+		for name, password := range dir.Credentials {
+			if err := users.AddPlain(name, password); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// Resumable uploads and file operations are offered when something is
+	// writable
+	resumablePath := ""
+	for _, dir := range validDirs {
+		if !dir.ReadOnly {
+			resumablePath = handler.ResumablePath
+		}
+	}
+
+	// Downloads share the bandwidth caps of the server, their mount and
+	// their connection
+	mountBandwidth := make(map[string]int64)
+	for _, dir := range validDirs {
+		mountBandwidth[dir.Name] = dir.Bandwidth
+	}
+	throttle := ratelimit.NewThrottle(cfg.Limits.Bandwidth, cfg.Limits.ConnectionBandwidth, cfg.Limits.BandwidthBurst, mountBandwidth)
 
 	// Create file server
-	fs := handler.NewFileServer(validDirs)
+	fs := handler.NewFileServer(validDirs, handler.Options{
+		MaxUploadSize:  cfg.Upload.MaxSize,
+		OnConflict:     conflict,
+		ResumablePath:  resumablePath,
+		TrashRetention: cfg.Trash.Retention,
+		IndexDir:       filepath.Join(cfg.StateDir, "index"),
+		IndexRescan:    cfg.Index.Rescan,
+		ThumbDir:       filepath.Join(cfg.StateDir, "thumbs"),
+		ShareDir:       filepath.Join(cfg.StateDir, "shares"),
+		// Without accounts there is nobody to sign in as
+		AnonymousShares: users.Empty(),
+		FullText: index.TextOptions{
+			MaxSize:    cfg.FullText.MaxSize,
+			Extensions: cfg.FullText.Extensions,
+			MimeTypes:  cfg.FullText.MimeTypes,
+		},
+		Admins:   auth.Admins{Users: cfg.Auth.Admins, Groups: cfg.Auth.AdminGroups},
+		ACL:      acl,
+		Throttle: throttle,
+	})
 
 	// Setup routes
-	http.HandleFunc("/", fs.HandleRequest)
+	var sessions *auth.Sessions
+	if !users.Empty() {
+		idle, lifetime := cfg.Auth.SessionIdle, cfg.Auth.SessionMax
+		if idle == 0 {
+			idle = 2 * time.Hour
+		}
+		if lifetime == 0 {
+			lifetime = 7 * 24 * time.Hour
+		}
+		sessions = auth.NewSessions(idle, lifetime)
+	}
+	guard := auth.NewGuard(users, sessions, fs.Mount, acl, cfg.Auth.Realm, cfg.Auth.Htpasswd != "")
+	// Signed-in users are limited after they are known, wherever they
+	// come from
+	var userLimiter *ratelimit.Limiter
+	if cfg.Limits.UserRequests > 0 {
+		userLimiter = ratelimit.NewLimiter(cfg.Limits.UserRequests, cfg.Limits.UserRequestsBurst)
+	}
+	protect := func(h http.Handler) http.Handler {
+		return guard.Wrap(userLimiter.Wrap(h, func(r *http.Request) string {
+			if u := auth.UserFrom(r.Context()); u != nil {
+				return u.Name
+			}
+			return ""
+		}))
+	}
+	if sessions != nil {
+		http.HandleFunc(auth.LoginPath, guard.ServeLogin)
+		http.HandleFunc(auth.LogoutPath, guard.ServeLogout)
+	}
+	if resumablePath != "" {
+		uploads, err := tus.NewHandler(resumablePath, filepath.Join(cfg.StateDir, "tus"), cfg.Upload.MaxSize, fs.ResumableBackend())
+		if err != nil {
+			log.Fatal(err)
+		}
+		http.Handle(resumablePath, protect(uploads))
+		http.Handle(handler.OpsPath, protect(http.HandlerFunc(fs.HandleOperation)))
+		http.Handle(handler.TrashPath, protect(http.HandlerFunc(fs.HandleTrash)))
+	}
+	http.Handle(handler.APIPath, protect(http.HandlerFunc(fs.HandleAPI)))
+	http.Handle(handler.SearchPath, protect(http.HandlerFunc(fs.HandleSearch)))
+	http.Handle(handler.ThumbPath, protect(http.HandlerFunc(fs.HandleThumbnail)))
+	http.Handle(handler.SharesPath, protect(http.HandlerFunc(fs.HandleShares)))
+	http.Handle(handler.SharesPath+"/", protect(http.HandlerFunc(fs.HandleShares)))
+	// Share links carry their own signed authorization
+	http.HandleFunc(handler.SharePath, fs.HandleShare)
+	http.Handle(handler.DAVPath, protect(fs.DAVHandler()))
+	http.Handle("/", protect(http.HandlerFunc(fs.HandleRequest)))
 
-	log.Printf("Serving directories %v on HTTP port: %s\n", validDirs, port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	// Address restrictions come before everything else. The configuration
+	// was checked when it was loaded.
+	allow, _ := netfilter.ParsePrefixes(cfg.Network.Allow)
+	deny, _ := netfilter.ParsePrefixes(cfg.Network.Deny)
+	proxies, _ := netfilter.ParsePrefixes(cfg.Network.TrustedProxies)
+	var ipLimiter *ratelimit.Limiter
+	if cfg.Limits.Requests > 0 {
+		ipLimiter = ratelimit.NewLimiter(cfg.Limits.Requests, cfg.Limits.RequestsBurst)
+	}
+	root := netfilter.New(allow, deny, proxies, fs.Mount).Wrap(ipLimiter.Wrap(http.DefaultServeMux, func(r *http.Request) string {
+		// Clients whose address a proxy hid share a bucket
+		if addr, ok := netfilter.Client(r.Context()); ok && addr.IsValid() {
+			return addr.String()
+		}
+		return "unknown"
+	}))
+
+	log.Printf("Serving directories %v\n", mountNames(validDirs))
+	errc := make(chan error, len(listen)+1)
+	for _, addr := range listen {
+		go func(addr string) {
+			srv := &http.Server{Addr: addr, Handler: root, ConnContext: throttle.ConnContext}
+			if certs == nil {
+				log.Printf("Listening for HTTP on %s\n", addr)
+				errc <- srv.ListenAndServe()
+				return
+			}
+
+			log.Printf("Listening for HTTPS on %s\n", addr)
+			srv.TLSConfig = certs.TLSConfig()
+			errc <- srv.ListenAndServeTLS("", "")
+		}(addr)
+	}
+	if cfg.TLS.Redirect != "" {
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS\n", cfg.TLS.Redirect)
+			errc <- http.ListenAndServe(cfg.TLS.Redirect, server.RedirectHandler(listen[0]))
+		}()
+	}
+	log.Fatal(<-errc)
+}
+
+// mountNames formats the mounts for the startup log
+func mountNames(dirs []models.Directory) []string {
+	names := make([]string, len(dirs))
+	for i, dir := range dirs {
+		names[i] = "/" + dir.Name + " -> " + dir.Path
+	}
+	return names
 }
 
 func printHelp() {
-	fmt.Println(`
+	fmt.Print(`
   ╔══════════════════════════════════════════════════════════════════╗
   ║                                                                  ║
   ║   ███████╗██╗██╗     ███████╗███████╗███████╗██████╗ ██╗   ██╗   ║
@@ -142,6 +411,7 @@ func printHelp() {
   ║                                                                  ║
   ║              A Modern Multi-Directory File Server                ║
   ╚══════════════════════════════════════════════════════════════════╝
+
 `)
 	fmt.Printf("  Version: %s\n\n", version)
 
@@ -155,11 +425,57 @@ func printHelp() {
 	fmt.Println("    -port <number>")
 	fmt.Println("        Port to serve HTTP on (default: 8000)")
 	fmt.Println()
+	fmt.Println("    -config <file>")
+	fmt.Println("        Load listen addresses and mounts from a configuration file")
+	fmt.Println("        Overridden by -port; directories on the command line are added")
+	fmt.Println()
+	fmt.Println("    -tls-cert <file> -tls-key <file>")
+	fmt.Println("        Serve HTTPS with the given PEM certificate and key")
+	fmt.Println("        Send SIGHUP to reload them without dropping connections")
+	fmt.Println()
+	fmt.Println("    -tls-self-signed")
+	fmt.Println("        Serve HTTPS with a generated self-signed certificate,")
+	fmt.Println("        cached in the state directory and renewed as needed")
+	fmt.Println()
+	fmt.Println("    -http-redirect <addr>")
+	fmt.Println("        Also listen for plain HTTP on addr and redirect to HTTPS")
+	fmt.Println()
+	fmt.Println("    -htpasswd <file>")
+	fmt.Println("        Require HTTP Basic authentication against an Apache")
+	fmt.Println("        htpasswd file (bcrypt, SHA-256/512 crypt, {SHA}, apr1)")
+	fmt.Println("        The file is reloaded automatically when it changes")
+	fmt.Println("        Browsers sign in through a login page instead")
+	fmt.Println()
+	fmt.Println("    -htgroups <file>")
+	fmt.Println("        Group memberships for per-mount group restrictions")
+	fmt.Println()
+	fmt.Println("    -state-dir <path>")
+	fmt.Println("        Directory for certificates, upload state, indexes and share links")
+	fmt.Println("        (default: fileserv in the user config dir)")
+	fmt.Println()
 	fmt.Println("    -dir <paths>")
 	fmt.Println("        Directories to serve (space or comma-separated)")
 	fmt.Println("        Can also pass directories as arguments after flags")
+	fmt.Println("        Use name=path to choose the URL name, e.g. public=/srv/docs")
 	fmt.Println("        If not specified, serves the current directory")
 	fmt.Println()
+	fmt.Println("    -writable")
+	fmt.Println("        Allow uploads and file changes in the directories given on the command line")
+	fmt.Println()
+	fmt.Println("    -index")
+	fmt.Println("        Keep a file name index of the directories given on the command line")
+	fmt.Println("        for fast searches, kept current as files change")
+	fmt.Println()
+	fmt.Println("    -max-upload <size>")
+	fmt.Println("        Largest file accepted per upload, e.g. 500MB (default: no limit)")
+	fmt.Println()
+	fmt.Println("    -on-conflict <policy>")
+	fmt.Println("        What to do when an upload's name is taken:")
+	fmt.Println("        reject, overwrite or rename (default: rename)")
+	fmt.Println()
+	fmt.Println("    -trash-retention <duration>")
+	fmt.Println("        How long deleted files stay in the trash, e.g. 7d (default: 30d)")
+	fmt.Println()
 	fmt.Println("    -version")
 	fmt.Println("        Show version information")
 	fmt.Println()
@@ -185,6 +501,18 @@ func printHelp() {
 	fmt.Println("    $ fileserv -port 3000 -dir ~/Documents ~/Downloads")
 	fmt.Println("    $ fileserv -dir ~/Documents ~/Downloads -port 3000")
 	fmt.Println()
+	fmt.Println("    # Serve directories under explicit (optionally nested) names")
+	fmt.Println("    $ fileserv public=/srv/a/docs team/docs=/srv/b/docs")
+	fmt.Println()
+	fmt.Println("    # Accept uploads of up to 2 GB into a drop folder")
+	fmt.Println("    $ fileserv -writable -max-upload 2GB ~/Incoming")
+	fmt.Println()
+	fmt.Println("    # Serve HTTPS with a self-signed certificate, redirecting port 80")
+	fmt.Println("    $ fileserv -tls-self-signed -port 443 -http-redirect :80")
+	fmt.Println()
+	fmt.Println("    # Serve the mounts declared in a configuration file")
+	fmt.Println("    $ fileserv -config /etc/fileserv.toml")
+	fmt.Println()
 	fmt.Println("    # Serve directories as standalone arguments")
 	fmt.Println("    $ fileserv ~/Documents ~/Downloads -port 3000")
 	fmt.Println()